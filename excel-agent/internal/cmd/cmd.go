@@ -1,36 +1,106 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"excel-agent/internal/config"
+	"excel-agent/internal/mcpserver"
 	"excel-agent/internal/processor"
 
 	"github.com/firebase/genkit/go/genkit"
 )
 
 type CLI struct {
-	Cmd  string
-	ID   string
-	File string
-	Key  string
+	Cmd       string
+	ID        string
+	File      string
+	Key       string
+	Typed     bool
+	Mode      string
+	Enhance   bool
+	Force     bool
+	Row       string
+	Filter    string
+	Limit     int
+	From      int64
+	To        int64
+	Version   int64
+	Format    string
+	Out       string
+	DryRun    bool
+	Source    string
+	Transport string
+	Addr      string
+	Session   string
+	Lang      string
+	Summary   bool
+	// Args are the positional arguments, e.g. the two sides of a diff.
+	Args []string
 }
 
 func ParseFlags() *CLI {
-	cmd := flag.String("cmd", "", "Command to run: xlsx, sheets, gen, redis, get")
-	id := flag.String("id", "", "Google Spreadsheet ID (for sheets command)")
-	file := flag.String("file", "", "JSON file name (for gen command)")
+	cmd := flag.String("cmd", "", "Command to run: auth, xlsx, sheets, export-xlsx, export-sheets, gen, redis, get, versions, version-diff, rollback, verify, diff, index, query, chat, localize, watch, validate, mcp")
+	id := flag.String("id", "", "Google Spreadsheet ID (for sheets and export-sheets commands)")
+	file := flag.String("file", "", "File name (for gen, validate, verify, localize and export commands)")
 	key := flag.String("key", "", "Redis key name (for get command)")
+	typed := flag.Bool("typed", false, "Keep number, bool and date cell types (for xlsx and diff commands)")
+	mode := flag.String("mode", "ai", "Struct generation mode (for gen command): ai or schema")
+	enhance := flag.Bool("enhance", false, "Let the AI improve names and comments of schema-generated structs")
+	force := flag.Bool("force", false, "Reconvert workbooks even if unchanged (for xlsx command)")
+	format := flag.String("format", "", "Output formats, e.g. json,csv,sqlite (for xlsx, sheets and watch commands, default: OUTPUT_FORMATS); text, json or html (for diff command)")
+	row := flag.String("row", "", "Primary key of a single row (for get command)")
+	filter := flag.String("filter", "", "Column filter such as Grade=SSR,Class=Warrior (for get command)")
+	limit := flag.Int("limit", 0, "Maximum number of rows to return (for get command)")
+	from := flag.Int64("from", 0, "Older Redis version (for version-diff command, default: the one before -to)")
+	to := flag.Int64("to", 0, "Newer Redis version (for version-diff command, default: current)")
+	version := flag.Int64("version", 0, "Redis version to restore (for rollback command, default: the previous one)")
+	out := flag.String("out", "", "Output path (for export-xlsx command, default: OUTPUT_DIR/xlsx/<file>.xlsx; for localize command, .json or .xlsx, default: OUTPUT_DIR/localize/review.xlsx; for diff command, default: stdout)")
+	dryRun := flag.Bool("dry-run", false, "Only print the cells that would change (for export-sheets and localize commands)")
+	transport := flag.String("transport", "stdio", "MCP transport (for mcp command): stdio or sse")
+	addr := flag.String("addr", ":8080", "Listen address of the SSE transport (for mcp command)")
+	sessionID := flag.String("session", "", "Chat session ID to continue (for query and chat commands)")
+	summary := flag.Bool("summary", false, "Let the AI summarize the balance-relevant changes (for diff command)")
+	lang := flag.String("lang", "", "Comma-separated target-language columns (for localize command, default: LOCALIZE_TARGETS)")
+	source := flag.String("source", "", "Comma-separated source names from SOURCES_FILE (for xlsx, sheets, redis, gen and watch commands, default: all)")
 	flag.Parse()
 
 	return &CLI{
-		Cmd:  *cmd,
-		ID:   *id,
-		File: *file,
-		Key:  *key,
+		Cmd:       *cmd,
+		ID:        *id,
+		File:      *file,
+		Key:       *key,
+		Typed:     *typed,
+		Mode:      *mode,
+		Enhance:   *enhance,
+		Force:     *force,
+		Row:       *row,
+		Filter:    *filter,
+		Limit:     *limit,
+		From:      *from,
+		To:        *to,
+		Version:   *version,
+		Format:    *format,
+		Out:       *out,
+		DryRun:    *dryRun,
+		Source:    *source,
+		Transport: *transport,
+		Addr:      *addr,
+		Session:   *sessionID,
+		Lang:      *lang,
+		Summary:   *summary,
+		Args:      flag.Args(),
 	}
 }
 
@@ -40,42 +110,376 @@ func (c *CLI) Handle(ctx context.Context, g *genkit.Genkit, cfg *config.Config,
 	}
 
 	switch c.Cmd {
+	case "auth":
+		if err := processor.AuthorizeGoogleOAuth(ctx, googleAuth(cfg), os.Stdout); err != nil {
+			log.Fatalf("Google authorization failed: %v", err)
+		}
+
 	case "xlsx":
 		log.Println("Processing local XLSX files...")
-		count, err := processor.ProcessXlsxFiles(cfg.XlsxDir, cfg.JsonDir)
+		opts, err := c.convertOptions(cfg, c.Typed || cfg.TypedCells)
+		if err != nil {
+			log.Fatalf("Invalid conversion options: %v", err)
+		}
+		var report *processor.ConversionReport
+		sources := c.sources(cfg)
+		workbooks := processor.SourcesOfKind(sources, false)
+		if len(workbooks) > 0 || c.Source != "" {
+			log.Printf("Converting %d workbook sources from %s...", len(workbooks), cfg.SourcesFile)
+			report, err = processor.ProcessXlsxSources(cfg.XlsxDir, cfg.JsonDir, workbooks, opts, c.Force)
+		} else {
+			report, err = processor.ProcessXlsxFiles(cfg.XlsxDir, cfg.JsonDir, opts, c.Force)
+		}
 		if err != nil {
 			log.Fatalf("XLSX processing failed: %v", err)
 		}
-		fmt.Printf("Successfully processed %d Excel files.\n", count)
+		fmt.Println(report)
+		if len(report.Converted) > 0 {
+			converted := make([]string, len(report.Converted))
+			for i, name := range report.Converted {
+				converted[i] = strings.TrimSuffix(name, filepath.Ext(name))
+				for _, s := range workbooks {
+					if filepath.Base(s.Workbook) == name {
+						converted[i] = s.Name
+					}
+				}
+			}
+			printValidation(cfg, opts.Layout, converted...)
+		}
 
 	case "sheets":
+		opts, err := c.convertOptions(cfg, false)
+		if err != nil {
+			log.Fatalf("Invalid conversion options: %v", err)
+		}
+		if c.ID == "" {
+			if spreadsheets := processor.SourcesOfKind(c.sources(cfg), true); len(spreadsheets) > 0 || c.Source != "" {
+				log.Printf("Processing %d spreadsheet sources from %s...", len(spreadsheets), cfg.SourcesFile)
+				reports, err := processor.ProcessSheetSources(ctx, cfg.JsonDir, googleAuth(cfg), spreadsheets, opts, sheetsFetchOptions(cfg))
+				partial := false
+				for _, report := range reports {
+					fmt.Println(report)
+					if report.JSONPath != "" {
+						printValidation(cfg, opts.Layout, report.JSONPath)
+					}
+					partial = partial || report.Partial()
+				}
+				if err != nil {
+					log.Fatalf("Google Sheet processing failed: %v", err)
+				}
+				if partial {
+					os.Exit(1)
+				}
+				break
+			}
+		}
 		sheetID := c.ID
 		if sheetID == "" {
 			sheetID = cfg.GoogleSheetID
 		}
 		if sheetID == "" {
-			log.Fatal("Google Spreadsheet ID is required (use -id flag or GOOGLE_SHEET_ID env)")
+			log.Fatal("Google Spreadsheet ID is required (use -id flag, GOOGLE_SHEET_ID env or SOURCES_FILE)")
 		}
 		log.Printf("Processing Google Sheet ID: %s", sheetID)
-		if err := processor.ConvertGoogleSheetToJSON(ctx, sheetID, cfg.JsonDir, cfg.GoogleAPIKey); err != nil {
+		report, err := processor.ConvertGoogleSheetToJSON(ctx, sheetID, cfg.JsonDir, googleAuth(cfg), opts, sheetsFetchOptions(cfg))
+		if report != nil {
+			fmt.Println(report)
+		}
+		if err != nil {
 			log.Fatalf("Google Sheet processing failed: %v", err)
 		}
 		fmt.Printf("Successfully processed Google Sheet: %s\n", sheetID)
+		printValidation(cfg, opts.Layout, report.JSONPath)
+		if report.Partial() {
+			os.Exit(1)
+		}
+
+	case "export-xlsx":
+		if c.File == "" {
+			log.Fatal("File name is required (use -file flag, e.g. Character)")
+		}
+		opts, err := processor.LoadConvertOptions(false, "", cfg.SheetLayoutFile)
+		if err != nil {
+			log.Fatalf("Invalid sheet layout: %v", err)
+		}
+		out := c.Out
+		if out == "" {
+			out = filepath.Join(cfg.OutputDir, "xlsx", c.File+".xlsx")
+		}
+		keys, err := processor.ParseKeyColumns(cfg.PrimaryKey, cfg.PrimaryKeys)
+		if err != nil {
+			log.Fatalf("Invalid primary keys: %v", err)
+		}
+		// The source workbook, if present, is the template so descriptor rows and styling survive.
+		template := filepath.Join(cfg.XlsxDir, c.File+".xlsx")
+		report, err := processor.ExportWorkbookToXlsx(filepath.Join(cfg.JsonDir, c.File+".json"), template, out, opts.Layout, keys)
+		if err != nil {
+			log.Fatalf("XLSX export failed: %v", err)
+		}
+		fmt.Println(report)
+
+	case "export-sheets":
+		sheetID := c.ID
+		if sheetID == "" {
+			sheetID = cfg.GoogleSheetID
+		}
+		if sheetID == "" {
+			log.Fatal("Google Spreadsheet ID is required (use -id flag or GOOGLE_SHEET_ID env)")
+		}
+		if c.File == "" {
+			log.Fatal("File name is required (use -file flag, e.g. Character)")
+		}
+		opts, err := processor.LoadConvertOptions(false, "", cfg.SheetLayoutFile)
+		if err != nil {
+			log.Fatalf("Invalid sheet layout: %v", err)
+		}
+		keys, err := processor.ParseKeyColumns(cfg.PrimaryKey, cfg.PrimaryKeys)
+		if err != nil {
+			log.Fatalf("Invalid primary keys: %v", err)
+		}
+		report, err := processor.ExportWorkbookToGoogleSheet(ctx, sheetID, filepath.Join(cfg.JsonDir, c.File+".json"), googleAuth(cfg), opts.Layout, keys, c.DryRun)
+		if err != nil {
+			log.Fatalf("Google Sheet export failed: %v", err)
+		}
+		fmt.Println(report)
+
+	case "validate":
+		if cfg.ValidationRulesFile == "" {
+			log.Fatal("Validation rules file is required (set VALIDATION_RULES_FILE)")
+		}
+		opts, err := processor.LoadConvertOptions(false, "", cfg.SheetLayoutFile)
+		if err != nil {
+			log.Fatalf("Invalid sheet layout: %v", err)
+		}
+		var files []string
+		if c.File != "" {
+			files = append(files, c.File)
+		}
+		report, err := processor.ValidateConverted(cfg.JsonDir, cfg.ValidationRulesFile, opts.Layout, files...)
+		if err != nil {
+			log.Fatalf("Validation failed: %v", err)
+		}
+		fmt.Println(report)
+		if report.HasErrors() {
+			os.Exit(1)
+		}
 
 	case "gen":
-		log.Printf("Generating Go structs from %s...", c.File)
-		res, err := processor.GenerateStructs(ctx, g, c.File, cfg.JsonDir, cfg.DataDir)
+		files := []string{c.File}
+		if c.File == "" {
+			if sources := c.sources(cfg); sources != nil {
+				files = files[:0]
+				for _, s := range sources {
+					files = append(files, s.JSONFile())
+				}
+			}
+		}
+		for _, file := range files {
+			c.generate(ctx, g, cfg, file)
+		}
+
+	case "watch":
+		opts, err := c.convertOptions(cfg, c.Typed || cfg.TypedCells)
 		if err != nil {
-			log.Fatalf("Struct generation failed: %v", err)
+			log.Fatalf("Invalid conversion options: %v", err)
+		}
+		redisOpts, err := loadRedisOptions(cfg)
+		if err != nil {
+			log.Fatalf("Invalid redis options: %v", err)
+		}
+		watchCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = processor.WatchXlsxDir(watchCtx, processor.WatchOptions{
+			XlsxDir:   cfg.XlsxDir,
+			JsonDir:   cfg.JsonDir,
+			Convert:   opts,
+			RedisAddr: cfg.RedisAddr,
+			RedisDB:   cfg.RedisDB,
+			Redis:     redisOpts,
+			Debounce:  cfg.WatchDebounce,
+			RulesFile: cfg.ValidationRulesFile,
+			Sources:   c.sources(cfg),
+			Logger:    slog.New(slog.NewJSONHandler(os.Stderr, nil)),
+		})
+		if err != nil {
+			log.Fatalf("Watch failed: %v", err)
 		}
-		fmt.Println(res)
 
 	case "redis":
-		log.Println("Caching JSON data to Redis...")
-		if err := processor.CacheJSONToRedis(ctx, cfg.JsonDir, cfg.RedisAddr, cfg.RedisDB); err != nil {
+		// With a sources manifest only its outputs are published; other files keep their current keys.
+		var files []string
+		for _, s := range c.sources(cfg) {
+			path := filepath.Join(cfg.JsonDir, s.JSONFile())
+			if _, err := os.Stat(path); err != nil {
+				log.Fatalf("Source %s has not been converted yet: %v", s, err)
+			}
+			files = append(files, path)
+		}
+		if cfg.ValidationRulesFile != "" {
+			opts, err := processor.LoadConvertOptions(false, "", cfg.SheetLayoutFile)
+			if err != nil {
+				log.Fatalf("Invalid sheet layout: %v", err)
+			}
+			report, err := processor.ValidateConverted(cfg.JsonDir, cfg.ValidationRulesFile, opts.Layout, files...)
+			if err != nil {
+				log.Fatalf("Validation failed: %v", err)
+			}
+			if report.HasErrors() {
+				fmt.Println(report)
+				log.Fatal("Refusing to cache data with validation errors")
+			}
+		}
+		redisOpts, err := loadRedisOptions(cfg)
+		if err != nil {
+			log.Fatalf("Invalid redis options: %v", err)
+		}
+		log.Printf("Caching JSON data to Redis (layout: %s)...", redisOpts.Layout)
+		var version int64
+		if len(files) > 0 {
+			version, err = processor.CacheJSONFilesToRedis(ctx, files, cfg.RedisAddr, cfg.RedisDB, redisOpts)
+		} else {
+			version, err = processor.CacheJSONToRedis(ctx, cfg.JsonDir, cfg.RedisAddr, cfg.RedisDB, redisOpts)
+		}
+		if err != nil {
 			log.Fatalf("Redis caching failed: %v", err)
 		}
-		fmt.Println("Successfully cached data to Redis.")
+		fmt.Printf("Successfully cached data to Redis (version %d).\n", version)
+
+		// Keep the search index in step with the published data; a failure does not undo the cache.
+		if cfg.SearchColumns != "" {
+			if err := indexRows(ctx, reg); err != nil {
+				log.Printf("Search indexing failed: %v", err)
+			}
+		}
+
+	case "index":
+		if err := indexRows(ctx, reg); err != nil {
+			log.Fatalf("Search indexing failed: %v", err)
+		}
+
+	case "verify":
+		opts, err := c.convertOptions(cfg, c.Typed || cfg.TypedCells)
+		if err != nil {
+			log.Fatalf("Invalid conversion options: %v", err)
+		}
+		var files []string
+		if c.File != "" {
+			files = append(files, c.File)
+		}
+		report, err := processor.VerifyPipeline(ctx, processor.VerifyOptions{
+			XlsxDir:   cfg.XlsxDir,
+			JsonDir:   cfg.JsonDir,
+			Convert:   opts,
+			RedisAddr: cfg.RedisAddr,
+			RedisDB:   cfg.RedisDB,
+			Files:     files,
+			Sources:   c.sources(cfg),
+		})
+		if err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		fmt.Println(report)
+		if report.HasMismatches() {
+			os.Exit(1)
+		}
+
+	case "diff":
+		// The old side defaults to the current Redis version: -cmd diff json/Character.json
+		sides := c.Args
+		if len(sides) == 1 {
+			sides = []string{processor.DiffRedis, sides[0]}
+		}
+		if len(sides) != 2 {
+			log.Fatal("Diff needs two workbooks or JSON files, or one and 'redis' (e.g. -cmd diff old/Character.xlsx xlsx/Character.xlsx)")
+		}
+		// -format selects the diff output here, not conversion formats.
+		opts, err := processor.LoadConvertOptions(c.Typed || cfg.TypedCells, cfg.TypeOverridesFile, cfg.SheetLayoutFile)
+		if err != nil {
+			log.Fatalf("Invalid conversion options: %v", err)
+		}
+		keys, err := processor.ParseKeyColumns(cfg.PrimaryKey, cfg.PrimaryKeys)
+		if err != nil {
+			log.Fatalf("Invalid primary keys: %v", err)
+		}
+		diff, err := processor.DiffWorkbooks(ctx, sides[0], sides[1], processor.DiffOptions{
+			Convert:   opts,
+			Keys:      keys,
+			RedisAddr: cfg.RedisAddr,
+			RedisDB:   cfg.RedisDB,
+			Sources:   c.sources(cfg),
+		})
+		if err != nil {
+			log.Fatalf("Diff failed: %v", err)
+		}
+		if c.Summary && diff.HasChanges() {
+			if diff.Summary, err = processor.SummarizeDiff(ctx, g, diff); err != nil {
+				log.Printf("Diff summary failed: %v", err)
+			}
+		}
+		out, err := diff.Render(c.Format)
+		if err != nil {
+			log.Fatalf("Diff failed: %v", err)
+		}
+		if c.Out == "" {
+			fmt.Println(out)
+			break
+		}
+		if err := os.WriteFile(c.Out, []byte(out), 0644); err != nil {
+			log.Fatalf("Failed to write diff: %v", err)
+		}
+		fmt.Printf("Diff written to %s (%d sheets changed).\n", c.Out, len(diff.Sheets))
+
+	case "versions":
+		versions, err := processor.ListRedisVersions(ctx, cfg.RedisAddr, cfg.RedisDB)
+		if err != nil {
+			log.Fatalf("Listing Redis versions failed: %v", err)
+		}
+		for _, v := range versions {
+			mark := " "
+			if v.Current {
+				mark = "*"
+			}
+			fmt.Printf("%s v%-6d %s  %-5s %s\n", mark, v.Version, v.Created, v.Layout, v.Source)
+		}
+
+	case "version-diff":
+		diff, err := processor.DiffRedisVersions(ctx, cfg.RedisAddr, cfg.RedisDB, c.From, c.To)
+		if err != nil {
+			log.Fatalf("Redis version diff failed: %v", err)
+		}
+		fmt.Println(diff)
+
+	case "rollback":
+		version, err := processor.RollbackRedis(ctx, cfg.RedisAddr, cfg.RedisDB, c.Version)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Printf("Current Redis version is now %d.\n", version)
+
+	case "get":
+		if c.Key == "" {
+			log.Fatal("Redis key is required (use -key flag, e.g. Character:UnitData)")
+		}
+		redisOpts, err := loadRedisOptions(cfg)
+		if err != nil {
+			log.Fatalf("Invalid redis options: %v", err)
+		}
+		input := &processor.RedisQueryInput{Key: c.Key, ID: c.Row, Limit: c.Limit}
+		if c.Filter != "" {
+			input.Filter = make(map[string]string)
+			for _, cond := range strings.Split(c.Filter, ",") {
+				col, val, ok := strings.Cut(cond, "=")
+				if !ok {
+					log.Fatalf("Invalid filter %q, want Column=Value", cond)
+				}
+				input.Filter[strings.TrimSpace(col)] = strings.TrimSpace(val)
+			}
+		}
+		res, err := processor.GetDataFromRedis(ctx, input, cfg.RedisAddr, cfg.RedisDB, redisOpts)
+		if err != nil {
+			log.Fatalf("Redis lookup failed: %v", err)
+		}
+		fmt.Println(res)
 
 	case "query":
 		if c.Key == "" {
@@ -83,11 +487,11 @@ func (c *CLI) Handle(ctx context.Context, g *genkit.Genkit, cfg *config.Config,
 		}
 		log.Printf("Querying agent with prompt: %s", c.Key)
 
-		// We use queryFlow which acts as an agent with the redis tool
+		// queryFlow plans a structured query, runs it in Go and phrases the answer from the result
 		if f, ok := reg["queryFlow"].(interface {
-			Run(context.Context, string) (string, error)
+			Run(context.Context, *processor.QueryInput) (*processor.QueryAnswer, error)
 		}); ok {
-			res, err := f.Run(ctx, c.Key)
+			res, err := f.Run(ctx, &processor.QueryInput{Question: c.Key, SessionID: c.Session})
 			if err != nil {
 				log.Fatalf("Agent query failed: %v", err)
 			}
@@ -96,9 +500,216 @@ func (c *CLI) Handle(ctx context.Context, g *genkit.Genkit, cfg *config.Config,
 			log.Fatal("queryFlow not found in registry")
 		}
 
+	case "chat":
+		c.chat(ctx, reg)
+
+	case "localize":
+		flow, ok := reg["localizeFlow"].(interface {
+			Run(context.Context, *processor.LocalizeInput) (*processor.LocalizeReport, error)
+		})
+		if !ok {
+			log.Fatal("localizeFlow not found in registry")
+		}
+		input := &processor.LocalizeInput{Sheet: c.File, Output: c.Out, DryRun: c.DryRun}
+		for _, lang := range strings.Split(c.Lang, ",") {
+			if lang = strings.TrimSpace(lang); lang != "" {
+				input.Languages = append(input.Languages, lang)
+			}
+		}
+		report, err := flow.Run(ctx, input)
+		if err != nil {
+			log.Fatalf("Localization failed: %v", err)
+		}
+		fmt.Println(report)
+		if c.DryRun {
+			for _, it := range report.Items {
+				fmt.Printf("  %s row %d (%s, %s): %s\n", it.Key, it.Row, it.ID, it.Language, it.Status)
+			}
+		}
+
+	case "mcp":
+		mcpCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := mcpserver.Serve(mcpCtx, mcpserver.New(cfg, reg), c.Transport, c.Addr); err != nil {
+			log.Fatalf("MCP server failed: %v", err)
+		}
+
 	default:
-		log.Fatalf("Unknown command: %s. Use xlsx, sheets, gen, redis, or get.", c.Cmd)
+		log.Fatalf("Unknown command: %s. Use auth, xlsx, sheets, export-xlsx, export-sheets, gen, redis, get, versions, version-diff, rollback, verify, diff, index, query, chat, localize, watch, validate or mcp.", c.Cmd)
 	}
 
 	return true
 }
+
+// generate writes the Go structs of one converted JSON file using -mode.
+func (c *CLI) generate(ctx context.Context, g *genkit.Genkit, cfg *config.Config, file string) {
+	log.Printf("Generating Go structs from %s (mode: %s)...", file, c.Mode)
+	var res string
+	var err error
+	switch c.Mode {
+	case "ai":
+		result, genErr := processor.GenerateStructs(ctx, g, file, cfg.JsonDir, cfg.DataDir, cfg.StructRepairAttempts)
+		if genErr == nil && result.Status != processor.GenerateStatusOK {
+			log.Fatal(result)
+		}
+		if result != nil {
+			res = result.String()
+		}
+		err = genErr
+	case "schema":
+		keys, keyErr := processor.ParseKeyColumns(cfg.PrimaryKey, cfg.PrimaryKeys)
+		if keyErr != nil {
+			log.Fatalf("Invalid primary keys: %v", keyErr)
+		}
+		res, err = processor.GenerateStructsFromSchema(ctx, g, file, cfg.JsonDir, cfg.DataDir, keys, c.Enhance)
+	default:
+		log.Fatalf("Unknown generation mode: %s. Use ai or schema.", c.Mode)
+	}
+	if err != nil {
+		log.Fatalf("Struct generation failed: %v", err)
+	}
+	fmt.Println(res)
+}
+
+// indexRows rebuilds the semantic search index with indexRowsFlow.
+func indexRows(ctx context.Context, reg map[string]interface{}) error {
+	flow, ok := reg["indexRowsFlow"].(interface {
+		Run(context.Context, string) (*processor.SearchIndexReport, error)
+	})
+	if !ok {
+		return fmt.Errorf("indexRowsFlow not found in registry")
+	}
+	log.Println("Indexing text columns for semantic search...")
+	report, err := flow.Run(ctx, "")
+	if err != nil {
+		return err
+	}
+	fmt.Println(report)
+	return nil
+}
+
+// chat answers questions read from stdin in one session until EOF or "exit".
+func (c *CLI) chat(ctx context.Context, reg map[string]interface{}) {
+	flow, ok := reg["queryFlow"].(interface {
+		Run(context.Context, *processor.QueryInput) (*processor.QueryAnswer, error)
+	})
+	if !ok {
+		log.Fatal("queryFlow not found in registry")
+	}
+	sessionID := c.Session
+	if sessionID == "" {
+		sessionID = rand.Text()
+	}
+	fmt.Printf("Session %s (continue later with -session %s)\n", sessionID, sessionID)
+	fmt.Println("Type /plan for the plan and result of the last answer, /new for a new session, exit to quit.")
+
+	var last *processor.QueryAnswer
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "exit", "quit":
+			return
+		case "/new":
+			sessionID, last = rand.Text(), nil
+			fmt.Printf("Session %s\n", sessionID)
+			continue
+		case "/plan":
+			if last == nil {
+				fmt.Println("No answer yet.")
+				continue
+			}
+			plan, _ := json.Marshal(last.Plan)
+			res, _ := json.Marshal(last.Result)
+			fmt.Printf("Plan (attempt %d): %s\nResult: %s\n", len(last.Attempts), plan, res)
+			continue
+		}
+
+		answer, err := flow.Run(ctx, &processor.QueryInput{Question: line, SessionID: sessionID})
+		if err != nil {
+			log.Printf("Agent query failed: %v", err)
+			continue
+		}
+		last = answer
+		fmt.Println(answer.Answer)
+	}
+}
+
+// convertOptions builds the conversion options, taking the output formats from -format or OUTPUT_FORMATS.
+func (c *CLI) convertOptions(cfg *config.Config, typed bool) (*processor.ConvertOptions, error) {
+	opts, err := processor.LoadConvertOptions(typed, cfg.TypeOverridesFile, cfg.SheetLayoutFile)
+	if err != nil {
+		return nil, err
+	}
+	spec := c.Format
+	if spec == "" {
+		spec = cfg.OutputFormats
+	}
+	if opts.Formats, err = processor.ParseOutputFormats(spec); err != nil {
+		return nil, err
+	}
+	opts.OutputDir = cfg.OutputDir
+	return opts, nil
+}
+
+// sources returns the sources named by -source, or every source of SOURCES_FILE.
+// It returns nil if no sources manifest is configured.
+func (c *CLI) sources(cfg *config.Config) []*processor.Source {
+	if cfg.SourcesFile == "" {
+		if c.Source != "" {
+			log.Fatal("-source needs a sources manifest (set SOURCES_FILE)")
+		}
+		return nil
+	}
+	m, err := processor.LoadSourceManifest(cfg.SourcesFile)
+	if err != nil {
+		log.Fatalf("Invalid sources manifest: %v", err)
+	}
+	sources, err := m.Select(processor.ParseSourceNames(c.Source))
+	if err != nil {
+		log.Fatalf("Invalid -source: %v", err)
+	}
+	return sources
+}
+
+func sheetsFetchOptions(cfg *config.Config) *processor.SheetsFetchOptions {
+	return &processor.SheetsFetchOptions{
+		BatchSize:    cfg.SheetsBatchSize,
+		Concurrency:  cfg.SheetsConcurrency,
+		MaxRetries:   cfg.SheetsMaxRetries,
+		AllowPartial: cfg.SheetsAllowPartial,
+	}
+}
+
+// googleAuth returns the configured Google credential methods.
+func googleAuth(cfg *config.Config) *processor.GoogleAuthOptions {
+	auth, err := processor.LoadGoogleAuthOptions(cfg.GoogleAuthMethods, cfg.GoogleCredentialsFile, cfg.GoogleCredentialsJSON,
+		cfg.GoogleOAuthClientFile, cfg.GoogleOAuthTokenFile, cfg.GoogleAPIKey)
+	if err != nil {
+		log.Fatalf("Invalid GOOGLE_AUTH_METHODS: %v", err)
+	}
+	return auth
+}
+
+func loadRedisOptions(cfg *config.Config) (*processor.RedisOptions, error) {
+	return processor.LoadRedisOptions(cfg.RedisLayout, cfg.PrimaryKey, cfg.PrimaryKeys, cfg.RedisIndexes, cfg.RedisKeepVersions)
+}
+
+// printValidation checks freshly converted files against the rules file, if one is configured.
+func printValidation(cfg *config.Config, layout *processor.SheetLayout, files ...string) {
+	report, err := processor.ValidateConverted(cfg.JsonDir, cfg.ValidationRulesFile, layout, files...)
+	if err != nil {
+		log.Printf("Validation failed: %v", err)
+		return
+	}
+	if report != nil {
+		fmt.Println(report)
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +18,80 @@ type Config struct {
 	GoogleAPIKey  string
 	RedisAddr     string
 	RedisDB       int
+
+	// TypedCells keeps number, bool and date cell types when converting xlsx files.
+	TypedCells bool
+	// TypeOverridesFile is an optional JSON file forcing the type of specific columns.
+	TypeOverridesFile string
+	// SheetLayoutFile is an optional JSON descriptor of header, type and comment rows.
+	SheetLayoutFile string
+	// OutputFormats lists the formats conversions write, e.g. "json,csv,sqlite".
+	OutputFormats string
+	// OutputDir is where formats other than JSON are written.
+	OutputDir string
+	// StructRepairAttempts bounds the model round trips of AI struct generation.
+	StructRepairAttempts int
+	// QueryPlanAttempts bounds the query plans the agent may write for one question.
+	QueryPlanAttempts int
+	// ChatHistoryTurns is how many turns of a chat session are kept and shown to the model.
+	ChatHistoryTurns int
+	// ChatSessionTTL is how long a chat session is kept in Redis after its last turn.
+	ChatSessionTTL time.Duration
+	// Embedder embeds text for semantic search: "googleai/<model>" or "ollama/<model>".
+	Embedder string
+	// OllamaServerAddress is the Ollama server used by "ollama/" embedders.
+	OllamaServerAddress string
+	// SearchColumns lists the text columns indexed for semantic search, e.g. "Dialogue:Lines=Text,Quest=Description".
+	SearchColumns string
+	// SearchIndexFile holds the embedded vectors of SearchColumns.
+	SearchIndexFile string
+	// LocalizeSheets lists the files or "File:Sheet" keys localizeFlow translates.
+	LocalizeSheets string
+	// LocalizeSource is the source-language column; LocalizeTargets the target-language columns, e.g. "EN,JP".
+	LocalizeSource  string
+	LocalizeTargets string
+	// LocalizeGlossary is the "File:Sheet" key of the glossary, with the same language columns.
+	LocalizeGlossary string
+	// LocalizeMaxLengths limits translation lengths per sheet, e.g. "120,LocalSeet:UI=30".
+	LocalizeMaxLengths string
+	// LocalizeBatchSize is the number of texts sent to the model per request.
+	LocalizeBatchSize int
+	// LocalizeAttempts bounds the model round trips for translations that break placeholders or limits.
+	LocalizeAttempts int
+	// PrimaryKey is the default primary-key column of every sheet.
+	PrimaryKey string
+	// PrimaryKeys overrides the key per sheet, e.g. "Item:ItemList=ItemCode,Shop=ProductID".
+	PrimaryKeys string
+	// RedisLayout is how sheets are stored in Redis: "json" (one key per sheet) or "hash" (one key per row).
+	RedisLayout string
+	// RedisIndexes lists secondary-index columns of the hash layout, e.g. "Grade,Item:ItemList=Type".
+	RedisIndexes string
+	// RedisKeepVersions is how many published Redis versions are retained for diff and rollback.
+	RedisKeepVersions int
+	// ValidationRulesFile is an optional YAML or JSON rules file checked after conversion.
+	ValidationRulesFile string
+	// SourcesFile is an optional YAML or JSON manifest of spreadsheets and workbooks with stable output names.
+	SourcesFile string
+	// SheetsBatchSize is the number of tabs read per Google Sheets BatchGet call.
+	SheetsBatchSize int
+	// SheetsConcurrency is the number of Google Sheets requests in flight.
+	SheetsConcurrency int
+	// SheetsMaxRetries bounds the retries of Google Sheets requests failing with 429 or 5xx.
+	SheetsMaxRetries int
+	// SheetsAllowPartial writes the tabs that were read even if others failed.
+	SheetsAllowPartial bool
+	// GoogleAuthMethods is the order Google credentials are tried in, e.g. "env,adc".
+	GoogleAuthMethods string
+	// GoogleCredentialsFile is a service-account or authorized-user JSON file.
+	GoogleCredentialsFile string
+	// GoogleCredentialsJSON is service-account JSON passed directly through the environment.
+	GoogleCredentialsJSON string
+	// GoogleOAuthClientFile is the OAuth client secret of a desktop app, used by "-cmd auth".
+	GoogleOAuthClientFile string
+	// GoogleOAuthTokenFile caches the OAuth token, including its refresh token.
+	GoogleOAuthTokenFile string
+	// WatchDebounce is how long a workbook must stay unchanged before watch mode processes it.
+	WatchDebounce time.Duration
 }
 
 func LoadConfig() *Config {
@@ -35,6 +111,46 @@ func LoadConfig() *Config {
 		GoogleAPIKey:  os.Getenv("GOOGLE_API_KEY"),
 		RedisAddr:     getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisDB:       redisDB,
+
+		TypedCells:        getEnvBool("TYPED_CELLS", false),
+		TypeOverridesFile: os.Getenv("TYPE_OVERRIDES_FILE"),
+		SheetLayoutFile:   os.Getenv("SHEET_LAYOUT_FILE"),
+		OutputFormats:     getEnv("OUTPUT_FORMATS", "json"),
+		OutputDir:         getEnv("OUTPUT_DIR", "out"),
+
+		StructRepairAttempts: getEnvInt("STRUCT_REPAIR_ATTEMPTS", 3),
+		QueryPlanAttempts:    getEnvInt("QUERY_PLAN_ATTEMPTS", 3),
+		ChatHistoryTurns:     getEnvInt("CHAT_HISTORY_TURNS", 10),
+		ChatSessionTTL:       getEnvDuration("CHAT_SESSION_TTL", 24*time.Hour),
+		Embedder:             getEnv("EMBEDDER", "googleai/gemini-embedding-001"),
+		OllamaServerAddress:  getEnv("OLLAMA_SERVER_ADDRESS", "http://localhost:11434"),
+		SearchColumns:        os.Getenv("SEARCH_COLUMNS"),
+		SearchIndexFile:      getEnv("SEARCH_INDEX_FILE", ".search-index.json"),
+		LocalizeSheets:       getEnv("LOCALIZE_SHEETS", "LocalSeet,Dialogue"),
+		LocalizeSource:       getEnv("LOCALIZE_SOURCE", "KR"),
+		LocalizeTargets:      getEnv("LOCALIZE_TARGETS", "EN"),
+		LocalizeGlossary:     os.Getenv("LOCALIZE_GLOSSARY"),
+		LocalizeMaxLengths:   os.Getenv("LOCALIZE_MAX_LENGTHS"),
+		LocalizeBatchSize:    getEnvInt("LOCALIZE_BATCH_SIZE", 30),
+		LocalizeAttempts:     getEnvInt("LOCALIZE_ATTEMPTS", 3),
+		PrimaryKey:           getEnv("PRIMARY_KEY", "ID"),
+		PrimaryKeys:          os.Getenv("PRIMARY_KEYS"),
+		WatchDebounce:        getEnvDuration("WATCH_DEBOUNCE", 2*time.Second),
+		ValidationRulesFile:  os.Getenv("VALIDATION_RULES_FILE"),
+		RedisLayout:          getEnv("REDIS_LAYOUT", "json"),
+		RedisIndexes:         os.Getenv("REDIS_INDEXES"),
+		RedisKeepVersions:    getEnvInt("REDIS_KEEP_VERSIONS", 5),
+		SourcesFile:          os.Getenv("SOURCES_FILE"),
+		SheetsBatchSize:      getEnvInt("SHEETS_BATCH_SIZE", 10),
+		SheetsConcurrency:    getEnvInt("SHEETS_CONCURRENCY", 4),
+		SheetsMaxRetries:     getEnvInt("SHEETS_MAX_RETRIES", 5),
+		SheetsAllowPartial:   getEnvBool("SHEETS_ALLOW_PARTIAL", false),
+
+		GoogleAuthMethods:     getEnv("GOOGLE_AUTH_METHODS", "file,env,oauth,adc,apikey"),
+		GoogleCredentialsFile: getEnv("GOOGLE_CREDENTIALS_FILE", "credentials.json"),
+		GoogleCredentialsJSON: os.Getenv("GOOGLE_CREDENTIALS_JSON"),
+		GoogleOAuthClientFile: os.Getenv("GOOGLE_OAUTH_CLIENT_FILE"),
+		GoogleOAuthTokenFile:  getEnv("GOOGLE_OAUTH_TOKEN_FILE", ".google-token.json"),
 	}
 }
 
@@ -45,6 +161,42 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
 func (c *Config) EnsureDirs() error {
 	dirs := []string{c.XlsxDir, c.JsonDir, c.DataDir}
 	for _, dir := range dirs {
@@ -2,47 +2,108 @@ package flows
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
 
 	"excel-agent/internal/config"
 	"excel-agent/internal/processor"
 
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/x/session"
 	"github.com/firebase/genkit/go/genkit"
 )
 
-func registerTools(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}) ai.Tool {
+func registerTools(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}) *processor.CatalogLoader {
+	redisOpts, err := processor.LoadRedisOptions(cfg.RedisLayout, cfg.PrimaryKey, cfg.PrimaryKeys, cfg.RedisIndexes, cfg.RedisKeepVersions)
+	if err != nil {
+		log.Printf("Invalid redis options, using defaults: %v", err)
+		redisOpts = nil
+	}
+
 	// Register Redis Query Tool
 	redisTool := genkit.DefineTool(
 		g,
 		"queryRedis",
-		"Queries spreadsheet data from Redis using a key. Key format is usually 'FileName:SheetName'.",
+		"Queries spreadsheet data from Redis using a key. Key format is usually 'FileName:SheetName'. "+
+			"Pass 'id' to fetch a single row by primary key, or 'filter' and 'limit' to fetch only matching rows.",
 		func(ctx *ai.ToolContext, input *processor.RedisQueryInput) (*processor.RedisQueryOutput, error) {
-			return processor.QueryRedisTool(ctx, input, cfg.RedisAddr, cfg.RedisDB)
+			return processor.QueryRedisTool(ctx, input, cfg.RedisAddr, cfg.RedisDB, redisOpts)
 		},
 	)
 	registry["queryRedis"] = redisTool
-	return redisTool
+
+	// Register Data Catalog Tools
+	catalog := &processor.CatalogLoader{JsonDir: cfg.JsonDir, RedisAddr: cfg.RedisAddr, RedisDB: cfg.RedisDB, Redis: redisOpts}
+	listTool := genkit.DefineTool(
+		g,
+		"listDatasets",
+		"Lists the sheets that can be queried, with their 'FileName:SheetName' keys, row counts and column counts. "+
+			"Pass 'file' to list a single file.",
+		func(ctx *ai.ToolContext, input *processor.ListDatasetsInput) (*processor.DatasetList, error) {
+			return processor.ListDatasets(ctx, catalog, input.File)
+		},
+	)
+	registry["listDatasets"] = listTool
+	describeTool := genkit.DefineTool(
+		g,
+		"describeSheet",
+		"Describes a sheet by its 'FileName:SheetName' key: column names and types, primary key and row count.",
+		func(ctx *ai.ToolContext, input *processor.DescribeSheetInput) (*processor.SheetDescription, error) {
+			return processor.DescribeSheet(ctx, catalog, input.Key)
+		},
+	)
+	registry["describeSheet"] = describeTool
+
+	// Register Structured Query Tool
+	queryTool := genkit.DefineTool(
+		g,
+		"querySheet",
+		"Queries the rows of a sheet by its 'FileName:SheetName' key. Select 'columns', filter with 'where' "+
+			"(eq, ne, lt, lte, gt, gte, contains), order with 'sort' and page with 'limit' and 'offset'. "+
+			"Rows come back as arrays in 'columns' order, with the total number of matching rows.",
+		func(ctx *ai.ToolContext, input *processor.SheetQuery) (*processor.QueryResult, error) {
+			return processor.QuerySheetTool(ctx, catalog, input)
+		},
+	)
+	registry["querySheet"] = queryTool
+
+	return catalog
 }
 
-func registerAgentFlows(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}, redisTool ai.Tool) {
-	// Define the Smart Query Flow (Agent)
-	registry["queryFlow"] = genkit.DefineFlow(g, "queryFlow", func(ctx context.Context, prompt string) (string, error) {
-		systemPrompt := `You are an assistant that analyzes spreadsheet data stored in Redis.
-You can use the 'queryRedis' tool to fetch data. 
-The keys for the tool are in the format 'FileName:SheetName'.
-Available data files include: Arena, BaseOption, BattleGroup, Building, Character, Dialogue, Dungeon, Gacha, Item, LocalSeet, Node, Reward, Shop, Sound, Stat, Tag, Tutorial, Upgrade, WorldMap.
-When a user asks for data, first determine the correct key, fetch the data, and then provide a concise summary or answer based on the retrieved JSON.
-If the JSON is too large, summarize the most relevant parts.
-`
-		resp, err := genkit.GenerateText(ctx, g,
-			ai.WithSystem(systemPrompt),
-			ai.WithPrompt(prompt),
-			ai.WithTools(redisTool),
-		)
+func registerAgentFlows(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}, catalog *processor.CatalogLoader, searcher *processor.RowSearcher) {
+	store := &processor.ChatSessionStore{RedisAddr: cfg.RedisAddr, RedisDB: cfg.RedisDB, TTL: cfg.ChatSessionTTL}
+
+	// Define the Smart Query Flow (Agent): the model plans the query, Go computes the result.
+	registry["queryFlow"] = genkit.DefineFlow(g, "queryFlow", func(ctx context.Context, input *processor.QueryInput) (*processor.QueryAnswer, error) {
+		if input.SessionID == "" {
+			return processor.AnswerQuestion(ctx, g, input.Question, nil, catalog, searcher, cfg.QueryPlanAttempts)
+		}
+
+		// Load the existing session or start a new one under the given ID.
+		sess, err := session.Load(ctx, store, input.SessionID)
 		if err != nil {
-			return "", fmt.Errorf("AI agent query failed: %v", err)
+			var notFound *session.NotFoundError
+			if !errors.As(err, &notFound) {
+				return nil, err
+			}
+			sess, err = session.New(ctx,
+				session.WithID[processor.ChatState](input.SessionID),
+				session.WithStore[processor.ChatState](store),
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		state := sess.State()
+		answer, err := processor.AnswerQuestion(ctx, g, input.Question, state.Turns, catalog, searcher, cfg.QueryPlanAttempts)
+		if err != nil {
+			return answer, err
+		}
+		state.Add(answer, cfg.ChatHistoryTurns)
+		if err := sess.UpdateState(ctx, state); err != nil {
+			return answer, err
 		}
-		return resp, nil
+		return answer, nil
 	})
 }
@@ -2,6 +2,7 @@ package flows
 
 import (
 	"context"
+	"path/filepath"
 
 	"excel-agent/internal/config"
 	"excel-agent/internal/processor"
@@ -9,9 +10,42 @@ import (
 	"github.com/firebase/genkit/go/genkit"
 )
 
-func registerGeneratorFlows(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}) {
+func registerGeneratorFlows(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}, catalog *processor.CatalogLoader) {
 	// AI Go Struct Generator Flow
-	registry["generateStructsFlow"] = genkit.DefineFlow(g, "generateStructsFlow", func(ctx context.Context, fileName string) (string, error) {
-		return processor.GenerateStructs(ctx, g, fileName, cfg.JsonDir, cfg.DataDir)
+	registry["generateStructsFlow"] = genkit.DefineFlow(g, "generateStructsFlow", func(ctx context.Context, fileName string) (*processor.GenerateResult, error) {
+		return processor.GenerateStructs(ctx, g, fileName, cfg.JsonDir, cfg.DataDir, cfg.StructRepairAttempts)
 	})
+
+	// Deterministic Go Struct Generator Flow (optional AI enhancement)
+	registry["generateSchemaStructsFlow"] = genkit.DefineFlow(g, "generateSchemaStructsFlow", func(ctx context.Context, input *SchemaStructsInput) (string, error) {
+		if input == nil {
+			input = &SchemaStructsInput{}
+		}
+		keys, err := processor.ParseKeyColumns(cfg.PrimaryKey, cfg.PrimaryKeys)
+		if err != nil {
+			return "", err
+		}
+		return processor.GenerateStructsFromSchema(ctx, g, input.FileName, cfg.JsonDir, cfg.DataDir, keys, input.Enhance)
+	})
+
+	// AI Localization Flow: translates missing and stale cells into a review file
+	registry["localizeFlow"] = genkit.DefineFlow(g, "localizeFlow", func(ctx context.Context, input *processor.LocalizeInput) (*processor.LocalizeReport, error) {
+		if input == nil {
+			input = &processor.LocalizeInput{}
+		}
+		opts, err := processor.LoadLocalizeOptions(cfg.LocalizeSheets, cfg.LocalizeSource, cfg.LocalizeTargets, cfg.LocalizeGlossary, cfg.LocalizeMaxLengths, cfg.SheetLayoutFile)
+		if err != nil {
+			return nil, err
+		}
+		if input.Output == "" {
+			input.Output = filepath.Join(cfg.OutputDir, "localize", "review.xlsx")
+		}
+		return processor.Localize(ctx, g, catalog, opts, input, cfg.LocalizeBatchSize, cfg.LocalizeAttempts)
+	})
+}
+
+// SchemaStructsInput is the input of generateSchemaStructsFlow.
+type SchemaStructsInput struct {
+	FileName string `json:"fileName,omitempty" description:"JSON file in the json directory; the first one if empty"`
+	Enhance  bool   `json:"enhance,omitempty" description:"Let the AI improve field names and comments"`
 }
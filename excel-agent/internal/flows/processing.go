@@ -12,12 +12,29 @@ import (
 
 func registerProcessingFlows(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}) {
 	// Local Excel Processor Flow
-	registry["excelToJsonFlow"] = genkit.DefineFlow(g, "excelToJsonFlow", func(ctx context.Context, input string) (string, error) {
-		processedCount, err := processor.ProcessXlsxFiles(cfg.XlsxDir, cfg.JsonDir)
+	registry["excelToJsonFlow"] = genkit.DefineFlow(g, "excelToJsonFlow", func(ctx context.Context, input *XlsxConvertInput) (string, error) {
+		if input == nil {
+			input = &XlsxConvertInput{}
+		}
+		opts, err := convertOptions(cfg, cfg.TypedCells)
+		if err != nil {
+			return "", err
+		}
+		workbooks, err := xlsxSources(cfg, input.Sources)
+		if err != nil {
+			return "", err
+		}
+		var report *processor.ConversionReport
+		if len(workbooks) > 0 || len(input.Sources) > 0 {
+			// Convert under the manifest's stable source names, as -cmd xlsx does.
+			report, err = processor.ProcessXlsxSources(cfg.XlsxDir, cfg.JsonDir, workbooks, opts, input.Force)
+		} else {
+			report, err = processor.ProcessXlsxFiles(cfg.XlsxDir, cfg.JsonDir, opts, input.Force)
+		}
 		if err != nil {
 			return "", err
 		}
-		return fmt.Sprintf("Successfully processed %d Excel files.", processedCount), nil
+		return report.String(), nil
 	})
 
 	// Google Sheets Processor Flow
@@ -28,9 +45,83 @@ func registerProcessingFlows(g *genkit.Genkit, cfg *config.Config, registry map[
 		if spreadsheetID == "" {
 			return "", fmt.Errorf("spreadsheetID is required")
 		}
-		if err := processor.ConvertGoogleSheetToJSON(ctx, spreadsheetID, cfg.JsonDir, cfg.GoogleAPIKey); err != nil {
+		opts, err := convertOptions(cfg, false)
+		if err != nil {
 			return "", err
 		}
-		return fmt.Sprintf("Successfully processed Google Sheet ID: %s", spreadsheetID), nil
+		fetch := &processor.SheetsFetchOptions{
+			BatchSize:    cfg.SheetsBatchSize,
+			Concurrency:  cfg.SheetsConcurrency,
+			MaxRetries:   cfg.SheetsMaxRetries,
+			AllowPartial: cfg.SheetsAllowPartial,
+		}
+		auth, err := processor.LoadGoogleAuthOptions(cfg.GoogleAuthMethods, cfg.GoogleCredentialsFile, cfg.GoogleCredentialsJSON,
+			cfg.GoogleOAuthClientFile, cfg.GoogleOAuthTokenFile, cfg.GoogleAPIKey)
+		if err != nil {
+			return "", err
+		}
+		report, err := processor.ConvertGoogleSheetToJSON(ctx, spreadsheetID, cfg.JsonDir, auth, opts, fetch)
+		if err != nil {
+			if report != nil {
+				return "", fmt.Errorf("%w\n%s", err, report)
+			}
+			return "", err
+		}
+		return report.String(), nil
+	})
+
+	// Data Validation Flow
+	registry["validateFlow"] = genkit.DefineFlow(g, "validateFlow", func(ctx context.Context, fileName string) (*processor.ValidationReport, error) {
+		if cfg.ValidationRulesFile == "" {
+			return nil, fmt.Errorf("VALIDATION_RULES_FILE is not set")
+		}
+		opts, err := processor.LoadConvertOptions(false, "", cfg.SheetLayoutFile)
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		if fileName != "" {
+			files = append(files, fileName)
+		}
+		return processor.ValidateConverted(cfg.JsonDir, cfg.ValidationRulesFile, opts.Layout, files...)
 	})
 }
+
+// XlsxConvertInput is the input of excelToJsonFlow.
+type XlsxConvertInput struct {
+	Force   bool     `json:"force,omitempty" description:"Reconvert unchanged workbooks"`
+	Sources []string `json:"sources,omitempty" description:"SOURCES_FILE entries to convert; all of them if empty"`
+}
+
+// xlsxSources returns the named workbook sources of SOURCES_FILE, or all of them
+// when names is empty.
+func xlsxSources(cfg *config.Config, names []string) ([]*processor.Source, error) {
+	if cfg.SourcesFile == "" {
+		if len(names) > 0 {
+			return nil, fmt.Errorf("sources need a sources manifest (set SOURCES_FILE)")
+		}
+		return nil, nil
+	}
+	m, err := processor.LoadSourceManifest(cfg.SourcesFile)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := m.Select(names)
+	if err != nil {
+		return nil, err
+	}
+	return processor.SourcesOfKind(sources, false), nil
+}
+
+// convertOptions builds the conversion options with the configured output formats.
+func convertOptions(cfg *config.Config, typed bool) (*processor.ConvertOptions, error) {
+	opts, err := processor.LoadConvertOptions(typed, cfg.TypeOverridesFile, cfg.SheetLayoutFile)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Formats, err = processor.ParseOutputFormats(cfg.OutputFormats); err != nil {
+		return nil, err
+	}
+	opts.OutputDir = cfg.OutputDir
+	return opts, nil
+}
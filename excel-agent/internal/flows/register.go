@@ -11,14 +11,15 @@ func RegisterFlows(g *genkit.Genkit, cfg *config.Config) map[string]interface{}
 	registry := make(map[string]interface{})
 
 	// 1. Register Tools & Local Logic
-	redisTool := registerTools(g, cfg, registry)
+	catalog := registerTools(g, cfg, registry)
+	searcher := registerSearch(g, cfg, registry, catalog)
 
 	// 2. Register Processing (Conversion) Flows
 	registerProcessingFlows(g, cfg, registry)
 
 	// 3. Register AI-driven Flows
-	registerGeneratorFlows(g, cfg, registry)
-	registerAgentFlows(g, cfg, registry, redisTool)
+	registerGeneratorFlows(g, cfg, registry, catalog)
+	registerAgentFlows(g, cfg, registry, catalog, searcher)
 
 	return registry
 }
@@ -0,0 +1,77 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"excel-agent/internal/config"
+	"excel-agent/internal/processor"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/ollama"
+)
+
+// registerSearch registers the semantic row search: the searchRows tool, the rows retriever and
+// indexRowsFlow. It returns nil if SEARCH_COLUMNS is not set or the embedder is unavailable.
+func registerSearch(g *genkit.Genkit, cfg *config.Config, registry map[string]interface{}, catalog *processor.CatalogLoader) *processor.RowSearcher {
+	var searcher *processor.RowSearcher
+	opts, err := processor.LoadSearchOptions(cfg.SearchColumns, cfg.SearchIndexFile, cfg.SheetLayoutFile)
+	switch {
+	case err != nil:
+		log.Printf("Invalid search options, semantic search disabled: %v", err)
+	case opts.Enabled():
+		embedder, err := searchEmbedder(g, cfg)
+		if err != nil {
+			log.Printf("Semantic search disabled: %v", err)
+			break
+		}
+		searcher = &processor.RowSearcher{Embedder: embedder, EmbedderName: cfg.Embedder, Options: opts, Catalog: catalog}
+	}
+
+	// Define the Index Rows Flow: embeds the configured text columns into the search index.
+	registry["indexRowsFlow"] = genkit.DefineFlow(g, "indexRowsFlow", func(ctx context.Context, _ string) (*processor.SearchIndexReport, error) {
+		if searcher == nil {
+			return nil, fmt.Errorf("semantic search is not configured (set SEARCH_COLUMNS and EMBEDDER)")
+		}
+		return searcher.BuildIndex(ctx)
+	})
+	if searcher == nil {
+		return nil
+	}
+
+	// Register Semantic Search Tool & Retriever
+	searchTool := genkit.DefineTool(
+		g,
+		"searchRows",
+		"Searches the indexed text columns (dialogue lines, descriptions, ...) by meaning. "+
+			"Returns the most similar rows with their 'FileName:SheetName' key, row ID, spreadsheet row and column, and a similarity score. "+
+			"Pass 'key' to search a single sheet.",
+		func(ctx *ai.ToolContext, input *processor.SearchRowsInput) (*processor.SearchResult, error) {
+			return searcher.SearchTool(ctx, input)
+		},
+	)
+	registry["searchRows"] = searchTool
+	registry["rowsRetriever"] = genkit.DefineRetriever(g, "excelAgent/rows", nil, searcher.Retrieve)
+
+	return searcher
+}
+
+// searchEmbedder resolves cfg.Embedder. Ollama embedders are defined on first use, since the
+// plugin only knows its models once they are named.
+func searchEmbedder(g *genkit.Genkit, cfg *config.Config) (ai.Embedder, error) {
+	if model, ok := strings.CutPrefix(cfg.Embedder, "ollama/"); ok {
+		o, ok := genkit.LookupPlugin(g, "ollama").(*ollama.Ollama)
+		if !ok {
+			return nil, fmt.Errorf("ollama plugin is not initialized")
+		}
+		return o.DefineEmbedder(g, cfg.OllamaServerAddress, model, nil), nil
+	}
+	embedder := genkit.LookupEmbedder(g, cfg.Embedder)
+	if embedder == nil {
+		return nil, fmt.Errorf("embedder %s not found", cfg.Embedder)
+	}
+	return embedder, nil
+}
@@ -0,0 +1,245 @@
+// Package mcpserver exposes the converted game data and the conversion flows as MCP tools.
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"excel-agent/internal/config"
+	"excel-agent/internal/flows"
+	"excel-agent/internal/processor"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Transports accepted by Serve.
+const (
+	TransportStdio = "stdio"
+	TransportSSE   = "sse"
+)
+
+const instructions = `excel-agent serves game data converted from Excel workbooks and Google Sheets.
+Call listDatasets to find sheets and describeSheet for their columns, then querySheet with a 'File:Sheet' key
+to fetch only the columns and rows you need. getRows returns single rows by primary key.`
+
+// New creates the MCP server. Conversion and validation run the flows registered in reg,
+// so they behave exactly like the Genkit flows and CLI commands.
+func New(cfg *config.Config, reg map[string]interface{}) *server.MCPServer {
+	s := server.NewMCPServer("excel-agent", "1.0.0",
+		server.WithToolCapabilities(false),
+		server.WithInstructions(instructions),
+		server.WithRecovery(),
+	)
+	redisOpts, err := processor.LoadRedisOptions(cfg.RedisLayout, cfg.PrimaryKey, cfg.PrimaryKeys, cfg.RedisIndexes, cfg.RedisKeepVersions)
+	if err != nil {
+		log.Printf("Invalid redis options, using defaults: %v", err)
+		redisOpts = nil
+	}
+	h := &handlers{
+		cfg:     cfg,
+		reg:     reg,
+		redis:   redisOpts,
+		catalog: &processor.CatalogLoader{JsonDir: cfg.JsonDir, RedisAddr: cfg.RedisAddr, RedisDB: cfg.RedisDB, Redis: redisOpts},
+	}
+
+	s.AddTool(mcp.NewTool("listDatasets",
+		mcp.WithDescription("List the sheets that can be queried, with their 'File:Sheet' keys, row counts and column counts"),
+		mcp.WithString("file", mcp.Description("Only list the sheets of this file, e.g. Character")),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), h.listDatasets)
+
+	s.AddTool(mcp.NewTool("describeSheet",
+		mcp.WithDescription("Describe a sheet: column names and types, primary key and row count"),
+		mcp.WithString("key", mcp.Required(), mcp.Description("Sheet key in the form 'File:Sheet', e.g. Character:UnitData")),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), h.describeSheet)
+
+	s.AddTool(mcp.NewTool("getRows",
+		mcp.WithDescription("Fetch rows of a sheet from the current Redis version. "+
+			"Pass 'id' for a single row by primary key, or 'filter' and 'limit' for matching rows."),
+		mcp.WithString("key", mcp.Required(), mcp.Description("Sheet key in the form 'File:Sheet', e.g. Character:UnitData")),
+		mcp.WithString("id", mcp.Description("Primary key of a single row, e.g. 1001")),
+		mcp.WithObject("filter", mcp.Description("Only return rows whose columns equal these values, e.g. {\"Grade\": \"SSR\"}")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of rows to return; 0 means all")),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), h.getRows)
+
+	s.AddTool(mcp.NewTool("querySheet",
+		mcp.WithDescription("Query the rows of a sheet by its 'File:Sheet' key. Select 'columns', filter with 'where' "+
+			"(op: eq, ne, lt, lte, gt, gte, contains), order with 'sort' and page with 'limit' (default 20, at most 200) and 'offset'. "+
+			"Rows come back as arrays in 'columns' order, with the total number of matching rows."),
+		mcp.WithInputSchema[processor.SheetQuery](),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), h.querySheet)
+
+	s.AddTool(mcp.NewTool("validate",
+		mcp.WithDescription("Check converted files against the validation rules (VALIDATION_RULES_FILE)"),
+		mcp.WithString("file", mcp.Description("Only validate this file; empty validates every file")),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), h.validate)
+
+	s.AddTool(mcp.NewTool("convert",
+		mcp.WithDescription("Convert local xlsx workbooks or a Google Spreadsheet to JSON. "+
+			"Unchanged workbooks are skipped unless 'force' is set. Converted data is not published to Redis."),
+		mcp.WithString("source", mcp.Required(), mcp.Enum("xlsx", "sheets"), mcp.Description("What to convert")),
+		mcp.WithString("spreadsheetId", mcp.Description("Google Spreadsheet ID for source 'sheets' (default: GOOGLE_SHEET_ID)")),
+		mcp.WithBoolean("force", mcp.Description("Reconvert unchanged workbooks (source 'xlsx')")),
+		mcp.WithString("sources", mcp.Description("Comma-separated SOURCES_FILE entries to convert (source 'xlsx'; default: all)")),
+		mcp.WithDestructiveHintAnnotation(false),
+	), h.convert)
+
+	return s
+}
+
+// Serve runs s over stdio or over SSE on addr until ctx is cancelled.
+func Serve(ctx context.Context, s *server.MCPServer, transport, addr string) error {
+	switch transport {
+	case TransportStdio:
+		// Stdout carries the protocol; the processor's progress output goes to stderr instead.
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		defer func() { os.Stdout = out }()
+		log.Println("MCP server listening on stdio")
+		return server.NewStdioServer(s).Listen(ctx, os.Stdin, out)
+
+	case TransportSSE:
+		sse := server.NewSSEServer(s)
+		errc := make(chan error, 1)
+		go func() { errc <- sse.Start(addr) }()
+		log.Printf("MCP server listening on %s (SSE endpoint: /sse)", addr)
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return sse.Shutdown(context.Background())
+		}
+	}
+	return fmt.Errorf("unknown MCP transport %q, want stdio or sse", transport)
+}
+
+type handlers struct {
+	cfg     *config.Config
+	reg     map[string]interface{}
+	redis   *processor.RedisOptions
+	catalog *processor.CatalogLoader
+}
+
+func (h *handlers) listDatasets(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	list, err := processor.ListDatasets(ctx, h.catalog, req.GetString("file", ""))
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("listing datasets failed", err), nil
+	}
+	return jsonResult(list)
+}
+
+func (h *handlers) describeSheet(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	key, err := req.RequireString("key")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	desc, err := processor.DescribeSheet(ctx, h.catalog, key)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("describing sheet failed", err), nil
+	}
+	if desc.Error != "" {
+		return mcp.NewToolResultError(desc.Error), nil
+	}
+	return jsonResult(desc.Sheet)
+}
+
+func (h *handlers) getRows(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	key, err := req.RequireString("key")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	input := &processor.RedisQueryInput{
+		Key:   key,
+		ID:    req.GetString("id", ""),
+		Limit: req.GetInt("limit", 0),
+	}
+	if filter, ok := req.GetArguments()["filter"].(map[string]interface{}); ok && len(filter) > 0 {
+		input.Filter = make(map[string]string, len(filter))
+		for col, v := range filter {
+			input.Filter[col] = fmt.Sprint(v)
+		}
+	}
+
+	data, err := processor.GetDataFromRedis(ctx, input, h.cfg.RedisAddr, h.cfg.RedisDB, h.redis)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	return mcp.NewToolResultText(data), nil
+}
+
+func (h *handlers) querySheet(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var q processor.SheetQuery
+	if err := req.BindArguments(&q); err != nil {
+		return mcp.NewToolResultErrorFromErr("invalid arguments", err), nil
+	}
+	res, err := processor.QuerySheet(ctx, h.catalog, &q)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	return jsonResult(res)
+}
+
+func (h *handlers) validate(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	flow, ok := h.reg["validateFlow"].(interface {
+		Run(context.Context, string) (*processor.ValidationReport, error)
+	})
+	if !ok {
+		return mcp.NewToolResultError("validateFlow not found in registry"), nil
+	}
+	report, err := flow.Run(ctx, req.GetString("file", ""))
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("validation failed", err), nil
+	}
+	return mcp.NewToolResultText(report.String()), nil
+}
+
+func (h *handlers) convert(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var (
+		report string
+		err    error
+	)
+	switch source := req.GetString("source", ""); source {
+	case "xlsx":
+		flow, ok := h.reg["excelToJsonFlow"].(interface {
+			Run(context.Context, *flows.XlsxConvertInput) (string, error)
+		})
+		if !ok {
+			return mcp.NewToolResultError("excelToJsonFlow not found in registry"), nil
+		}
+		report, err = flow.Run(ctx, &flows.XlsxConvertInput{
+			Force:   req.GetBool("force", false),
+			Sources: processor.ParseSourceNames(req.GetString("sources", "")),
+		})
+	case "sheets":
+		flow, ok := h.reg["googleSheetToJsonFlow"].(interface {
+			Run(context.Context, string) (string, error)
+		})
+		if !ok {
+			return mcp.NewToolResultError("googleSheetToJsonFlow not found in registry"), nil
+		}
+		report, err = flow.Run(ctx, req.GetString("spreadsheetId", ""))
+	default:
+		return mcp.NewToolResultErrorf("unknown source %q, want xlsx or sheets", source), nil
+	}
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("conversion failed", err), nil
+	}
+	return mcp.NewToolResultText(report), nil
+}
+
+// jsonResult encodes v as an indented JSON text result.
+func jsonResult(v interface{}) (*mcp.CallToolResult, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError("failed to marshal result"), nil
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
@@ -0,0 +1,397 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// catalogSampleRows bounds the rows read per hash-layout sheet to infer column types.
+const catalogSampleRows = 200
+
+// Catalog describes the data the agent can query: files, sheets, columns and row counts.
+type Catalog struct {
+	// Source is where the catalog was read from: "redis v<N>" or the JSON directory.
+	Source string         `json:"source"`
+	Files  []*CatalogFile `json:"files"`
+}
+
+// CatalogFile is one converted file and its sheets, in workbook order when read from JSON.
+type CatalogFile struct {
+	File   string          `json:"file"`
+	Sheets []*CatalogSheet `json:"sheets"`
+}
+
+// CatalogSheet describes one sheet. Key is the 'File:Sheet' key used by the query tools.
+type CatalogSheet struct {
+	File       string          `json:"file"`
+	Name       string          `json:"name"`
+	Key        string          `json:"key"`
+	PrimaryKey string          `json:"primary_key,omitempty"`
+	Rows       int             `json:"rows"`
+	Columns    []CatalogColumn `json:"columns"`
+}
+
+// CatalogColumn is a column and the type inferred from its values: int, float, bool, string,
+// date, object, mixed, or an array such as int[]. Columns without any value are reported as string.
+type CatalogColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// BuildJSONCatalog describes the converted files in jsonDir. If files are given, only those are included.
+func BuildJSONCatalog(jsonDir string, keys *KeyColumns, files ...string) (*Catalog, error) {
+	if len(files) == 0 {
+		entries, err := os.ReadDir(jsonDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read json directory: %w", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && filepath.Ext(e.Name()) == ".json" {
+				files = append(files, e.Name())
+			}
+		}
+	}
+
+	c := &Catalog{Source: jsonDir}
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		wb, err := ReadJSONWorkbook(filepath.Join(jsonDir, name+".json"))
+		if err != nil {
+			return nil, err
+		}
+		cf := &CatalogFile{File: name}
+		for _, s := range wb.Sheets {
+			cf.Sheets = append(cf.Sheets, catalogSheet(name, s, len(s.Rows), keys))
+		}
+		c.Files = append(c.Files, cf)
+	}
+	return c, nil
+}
+
+// buildRedisCatalog describes the sheets of a Redis version. Sheets cached with the json
+// layout are read in full; for the hash layout the row count comes from the metadata and
+// column types are inferred from the first rows.
+func buildRedisCatalog(ctx context.Context, rdb *redis.Client, version int64, opts *RedisOptions) (*Catalog, error) {
+	prefix := versionPrefix(version)
+	keys, err := scanKeys(ctx, rdb, prefix)
+	if err != nil {
+		return nil, err
+	}
+	// Sheet keys are File:Sheet; row, ID-list and index keys of the hash layout have more parts.
+	var sheetKeys []string
+	for _, key := range keys {
+		if strings.Count(strings.TrimPrefix(key, prefix), ":") == 1 {
+			sheetKeys = append(sheetKeys, key)
+		}
+	}
+	sort.Strings(sheetKeys)
+
+	pipe := rdb.Pipeline()
+	types := make([]*redis.StatusCmd, len(sheetKeys))
+	for i, key := range sheetKeys {
+		types[i] = pipe.Type(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && len(sheetKeys) > 0 {
+		return nil, err
+	}
+
+	c := &Catalog{Source: fmt.Sprintf("redis v%d", version)}
+	files := make(map[string]*CatalogFile)
+	for i, key := range sheetKeys {
+		file, name, _ := strings.Cut(strings.TrimPrefix(key, prefix), ":")
+		var sheet *CatalogSheet
+		switch types[i].Val() {
+		case "string":
+			val, err := rdb.Get(ctx, key).Result()
+			if err != nil {
+				return nil, err
+			}
+			s, err := decodeJSONSheet([]byte(val))
+			if err != nil {
+				log.Printf("Catalog: skipping %s: %v", key, err)
+				continue
+			}
+			s.Name = name
+			sheet = catalogSheet(file, s, len(s.Rows), opts.keys())
+		case "hash":
+			sheet, err = hashCatalogSheet(ctx, rdb, key, file, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if sheet == nil {
+			continue
+		}
+		cf := files[file]
+		if cf == nil {
+			cf = &CatalogFile{File: file}
+			files[file] = cf
+			c.Files = append(c.Files, cf)
+		}
+		cf.Sheets = append(cf.Sheets, sheet)
+	}
+	return c, nil
+}
+
+// hashCatalogSheet describes a sheet stored with the hash layout, or returns nil if key is not one.
+func hashCatalogSheet(ctx context.Context, rdb *redis.Client, key, file, name string) (*CatalogSheet, error) {
+	meta, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if meta["layout"] != RedisLayoutHash {
+		return nil, nil
+	}
+	ids, err := rdb.ZRange(ctx, rowIDsKey(key), 0, catalogSampleRows-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, rowKey(key, id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil && len(ids) > 0 {
+		return nil, err
+	}
+
+	s := &Sheet{Name: name, Columns: metaColumns(meta)}
+	for _, cmd := range cmds {
+		s.Rows = append(s.Rows, decodeTypedRow(cmd.Val()))
+	}
+	if len(s.Columns) == 0 {
+		s.Columns = rowColumns(s.Rows)
+	}
+	rows := len(ids)
+	fmt.Sscanf(meta["rows"], "%d", &rows)
+
+	sheet := catalogSheet(file, s, rows, nil)
+	sheet.PrimaryKey = meta["key"]
+	return sheet, nil
+}
+
+func catalogSheet(file string, s *Sheet, rows int, keys *KeyColumns) *CatalogSheet {
+	kinds := make(map[string]*kindSet, len(s.Columns))
+	for _, col := range s.Columns {
+		kinds[col] = &kindSet{}
+	}
+	for _, row := range s.Rows {
+		for col, v := range row {
+			if k := kinds[col]; k != nil {
+				k.add(v)
+			}
+		}
+	}
+
+	sheet := &CatalogSheet{File: file, Name: s.Name, Key: file + ":" + s.Name, Rows: rows, Columns: make([]CatalogColumn, 0, len(s.Columns))}
+	if keys != nil {
+		sheet.PrimaryKey, _ = keys.Find(file, s.Name, s.Columns)
+	}
+	for _, col := range s.Columns {
+		sheet.Columns = append(sheet.Columns, CatalogColumn{Name: col, Type: kinds[col].typeName()})
+	}
+	return sheet
+}
+
+// typeName names the kinds of a column the way sheet type rows do.
+func (k *kindSet) typeName() string {
+	switch t := k.goType(); {
+	case strings.HasPrefix(t, "[]"):
+		return k.elem.typeName() + "[]"
+	case t == "int64":
+		return "int"
+	case t == "float64":
+		return "float"
+	case t == dateType:
+		return "date"
+	case t == "map[string]interface{}":
+		return "object"
+	case t == "interface{}":
+		return "mixed"
+	default:
+		return t
+	}
+}
+
+// Sheet finds a sheet by its 'File:Sheet' key, ignoring case if there is no exact match.
+func (c *Catalog) Sheet(key string) (*CatalogSheet, bool) {
+	var folded *CatalogSheet
+	for _, f := range c.Files {
+		for _, s := range f.Sheets {
+			if s.Key == key {
+				return s, true
+			}
+			if folded == nil && strings.EqualFold(s.Key, key) {
+				folded = s
+			}
+		}
+	}
+	return folded, folded != nil
+}
+
+// summary lists every sheet on one line with its key, row count and typed columns, for prompts.
+func (c *Catalog) summary() string {
+	var b strings.Builder
+	for _, f := range c.Files {
+		for _, s := range f.Sheets {
+			fmt.Fprintf(&b, "- %s (%d rows", s.Key, s.Rows)
+			if s.PrimaryKey != "" {
+				fmt.Fprintf(&b, ", primary key %s", s.PrimaryKey)
+			}
+			b.WriteString("):")
+			for i, col := range s.Columns {
+				if i > 0 {
+					b.WriteString(",")
+				}
+				fmt.Fprintf(&b, " %s %s", col.Name, col.Type)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// CatalogLoader reads the catalog from the current Redis version, falling back to the
+// JSON directory when nothing is published. A Redis catalog is rebuilt only when the
+// current version changes.
+type CatalogLoader struct {
+	JsonDir   string
+	RedisAddr string
+	RedisDB   int
+	Redis     *RedisOptions
+
+	mu      sync.Mutex
+	version int64
+	cached  *Catalog
+}
+
+// Load returns the catalog of the data the query tools currently see.
+func (l *CatalogLoader) Load(ctx context.Context) (*Catalog, error) {
+	rdb := redis.NewClient(&redis.Options{Addr: l.RedisAddr, DB: l.RedisDB})
+	defer rdb.Close()
+
+	cur, ok, err := currentVersion(ctx, rdb)
+	if err != nil || !ok {
+		if err != nil {
+			log.Printf("Catalog: Redis unavailable, reading %s instead: %v", l.JsonDir, err)
+		}
+		return BuildJSONCatalog(l.JsonDir, l.Redis.keys())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cached != nil && l.version == cur {
+		return l.cached, nil
+	}
+	c, err := buildRedisCatalog(ctx, rdb, cur, l.Redis)
+	if err != nil {
+		return nil, err
+	}
+	l.version, l.cached = cur, c
+	return c, nil
+}
+
+// ListDatasetsInput optionally narrows listDatasets down to one file.
+type ListDatasetsInput struct {
+	File string `json:"file,omitempty" description:"Only list the sheets of this file (e.g., 'Character')"`
+}
+
+// DatasetList is the output of the listDatasets tool.
+type DatasetList struct {
+	Source   string         `json:"source"`
+	Datasets []DatasetEntry `json:"datasets"`
+}
+
+// DatasetEntry summarizes one sheet; describeSheet returns its columns.
+type DatasetEntry struct {
+	Key     string `json:"key"`
+	Rows    int    `json:"rows"`
+	Columns int    `json:"columns"`
+}
+
+// SheetDescription is the output of the describeSheet tool. Error explains an unknown key.
+type SheetDescription struct {
+	Sheet *CatalogSheet `json:"sheet,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// DescribeSheetInput names the sheet describeSheet returns.
+type DescribeSheetInput struct {
+	Key string `json:"key" description:"Sheet key in the form 'File:Sheet', as returned by listDatasets"`
+}
+
+// ListDatasets lists every sheet of the catalog, or of one file, with its row and column counts.
+func ListDatasets(ctx context.Context, loader *CatalogLoader, file string) (*DatasetList, error) {
+	c, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := &DatasetList{Source: c.Source, Datasets: []DatasetEntry{}}
+	for _, f := range c.Files {
+		if file != "" && !strings.EqualFold(f.File, file) {
+			continue
+		}
+		for _, s := range f.Sheets {
+			out.Datasets = append(out.Datasets, DatasetEntry{Key: s.Key, Rows: s.Rows, Columns: len(s.Columns)})
+		}
+	}
+	return out, nil
+}
+
+// DescribeSheet returns the columns, column types, primary key and row count of a sheet.
+// An unknown key is reported with the keys of the same file, so the model can correct itself.
+func DescribeSheet(ctx context.Context, loader *CatalogLoader, key string) (*SheetDescription, error) {
+	c, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := c.Sheet(key); ok {
+		return &SheetDescription{Sheet: s}, nil
+	}
+	file, _, _ := strings.Cut(key, ":")
+	var similar []string
+	for _, f := range c.Files {
+		if strings.EqualFold(f.File, file) {
+			for _, s := range f.Sheets {
+				similar = append(similar, s.Key)
+			}
+		}
+	}
+	if len(similar) > 0 {
+		return &SheetDescription{Error: fmt.Sprintf("unknown sheet %q; %s has: %s", key, file, strings.Join(similar, ", "))}, nil
+	}
+	return &SheetDescription{Error: fmt.Sprintf("unknown sheet %q; call listDatasets for the available keys", key)}, nil
+}
+
+// ReadSheet reads every row of a sheet from the source Load describes: the current Redis
+// version, or the JSON directory when nothing is published.
+func (l *CatalogLoader) ReadSheet(ctx context.Context, key string) (*Sheet, error) {
+	rdb := redis.NewClient(&redis.Options{Addr: l.RedisAddr, DB: l.RedisDB})
+	defer rdb.Close()
+
+	if _, ok, err := currentVersion(ctx, rdb); err == nil && ok {
+		return readCurrentSheet(ctx, rdb, key)
+	}
+	file, name, ok := strings.Cut(key, ":")
+	if !ok {
+		return nil, fmt.Errorf("key '%s' is not in the form 'File:Sheet'", key)
+	}
+	wb, err := ReadJSONWorkbook(filepath.Join(l.JsonDir, file+".json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range wb.Sheets {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("key '%s' not found", key)
+}
@@ -0,0 +1,280 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Column types understood by the type-override file.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeDate   = "date"
+)
+
+// TypeOverrides maps a scope ("File:Sheet", "Sheet" or "*") to column -> type.
+//
+//	{
+//	  "*":                  {"Code": "string"},
+//	  "Character:UnitData": {"ID": "string"}
+//	}
+type TypeOverrides map[string]map[string]string
+
+// LoadTypeOverrides reads a JSON type-override file.
+func LoadTypeOverrides(path string) (TypeOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read type overrides: %w", err)
+	}
+	var overrides TypeOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse type overrides %s: %w", path, err)
+	}
+	for scope, cols := range overrides {
+		for col, typ := range cols {
+			switch typ {
+			case TypeString, TypeInt, TypeFloat, TypeBool, TypeDate:
+			default:
+				return nil, fmt.Errorf("type overrides %s: unknown type %q for %s.%s", path, typ, scope, col)
+			}
+		}
+	}
+	return overrides, nil
+}
+
+// Lookup returns the override for a column, preferring the most specific scope.
+func (o TypeOverrides) Lookup(file, sheet, column string) (string, bool) {
+	for _, scope := range []string{file + ":" + sheet, sheet, "*"} {
+		if typ, ok := o[scope][column]; ok {
+			return typ, true
+		}
+	}
+	return "", false
+}
+
+// cellReader extracts typed values from a single worksheet.
+type cellReader struct {
+	f        *excelize.File
+	sheet    string
+	date1904 bool
+	dateFmt  map[int]bool
+}
+
+func newCellReader(f *excelize.File, sheet string) *cellReader {
+	return &cellReader{f: f, sheet: sheet, date1904: isDate1904(f), dateFmt: make(map[int]bool)}
+}
+
+// isDate1904 reports whether the workbook counts date serials from 1904 instead of 1900.
+func isDate1904(f *excelize.File) bool {
+	props, err := f.GetWorkbookProps()
+	return err == nil && props.Date1904 != nil && *props.Date1904
+}
+
+// value returns the typed value of the cell at (col, row), both zero-based.
+// formatted is the display value reported by GetRows. A nil result means the cell is empty.
+func (r *cellReader) value(col, row int, formatted string) (interface{}, error) {
+	axis, err := excelize.CoordinatesToCellName(col+1, row+1)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := r.f.GetCellValue(r.sheet, axis, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, err
+	}
+	cellType, err := r.f.GetCellType(r.sheet, axis)
+	if err != nil {
+		return nil, err
+	}
+
+	// Formula cells without a cached result are calculated on the fly,
+	// and the result is typed like a plain value.
+	if raw == "" {
+		if formula, _ := r.f.GetCellFormula(r.sheet, axis); formula != "" {
+			if raw, err = r.f.CalcCellValue(r.sheet, axis, excelize.Options{RawCellValue: true}); err != nil {
+				return nil, fmt.Errorf("failed to calculate %s: %w", axis, err)
+			}
+			if formatted == "" {
+				formatted = raw
+			}
+			cellType = excelize.CellTypeUnset
+		}
+	}
+	if raw == "" && formatted == "" {
+		return nil, nil
+	}
+
+	switch cellType {
+	case excelize.CellTypeBool:
+		return raw == "1" || strings.EqualFold(raw, "TRUE"), nil
+	case excelize.CellTypeDate:
+		if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return formatISODate(t), nil
+		}
+		return formatted, nil
+	case excelize.CellTypeSharedString, excelize.CellTypeInlineString, excelize.CellTypeFormula, excelize.CellTypeError:
+		return formatted, nil
+	}
+
+	num, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return formatted, nil
+	}
+	if r.isDateCell(axis) {
+		if t, err := excelize.ExcelDateToTime(num, r.date1904); err == nil {
+			return formatISODate(t), nil
+		}
+	}
+	return numberValue(num), nil
+}
+
+// isDateCell reports whether the cell's number format renders a date or time.
+func (r *cellReader) isDateCell(axis string) bool {
+	styleID, err := r.f.GetCellStyle(r.sheet, axis)
+	if err != nil {
+		return false
+	}
+	if isDate, ok := r.dateFmt[styleID]; ok {
+		return isDate
+	}
+	isDate := false
+	if style, err := r.f.GetStyle(styleID); err == nil && style != nil {
+		if style.CustomNumFmt != nil {
+			isDate = isDateFormatCode(*style.CustomNumFmt)
+		} else {
+			isDate = isBuiltInDateFormat(style.NumFmt)
+		}
+	}
+	r.dateFmt[styleID] = isDate
+	return isDate
+}
+
+func isBuiltInDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// isDateFormatCode detects date tokens in a custom number format, ignoring
+// quoted literals, escaped characters and bracketed sections such as colors.
+func isDateFormatCode(code string) bool {
+	inQuote, inBracket := false, false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case inQuote:
+			inQuote = c != '"'
+		case inBracket:
+			if c == ']' {
+				inBracket = false
+			} else if c == 'h' || c == 'H' || c == 's' || c == 'S' {
+				// Elapsed time sections like [h] or [ss].
+				return true
+			}
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			inBracket = true
+		case c == '\\' || c == '_' || c == '*':
+			i++
+		case strings.IndexByte("yYmMdDhHsS", c) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+// formatISODate renders a date as YYYY-MM-DD, or as a full ISO-8601 timestamp when it has a time part.
+func formatISODate(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// numberValue keeps integral numbers as int64 so they marshal without a fraction.
+func numberValue(f float64) interface{} {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
+	}
+	return f
+}
+
+// coerceValue converts a cell value to the requested override type.
+// Empty cells yield nil; values that cannot be converted are kept as they are.
+// Numbers with a fraction in an int column are kept as floats and reported in the error,
+// so a wrong ID or stat is not silently rounded away. date1904 selects the workbook's date epoch.
+func coerceValue(value interface{}, formatted, typ string, date1904 bool) (interface{}, error) {
+	if formatted == "" && (value == nil || value == "") {
+		return nil, nil
+	}
+	switch typ {
+	case TypeString:
+		return formatted, nil
+	case TypeInt:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return integralValue(v)
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(formatted), 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(formatted), 64); err == nil {
+			return integralValue(f)
+		}
+	case TypeFloat:
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(formatted), 64); err == nil {
+			return f, nil
+		}
+	case TypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+		if b, err := strconv.ParseBool(strings.TrimSpace(formatted)); err == nil {
+			return b, nil
+		}
+	case TypeDate:
+		if s, ok := value.(string); ok && s != formatted {
+			return s, nil
+		}
+		switch v := value.(type) {
+		case int64:
+			if t, err := excelize.ExcelDateToTime(float64(v), date1904); err == nil {
+				return formatISODate(t), nil
+			}
+		case float64:
+			if t, err := excelize.ExcelDateToTime(v, date1904); err == nil {
+				return formatISODate(t), nil
+			}
+		}
+	}
+	if value != nil {
+		return value, nil
+	}
+	return formatted, nil
+}
+
+// integralValue converts an int-column number to int64, or keeps it with an error if it has a fraction.
+func integralValue(f float64) (interface{}, error) {
+	if f != math.Trunc(f) || math.Abs(f) >= 1<<63 {
+		return f, fmt.Errorf("%v is not an integer", f)
+	}
+	return int64(f), nil
+}
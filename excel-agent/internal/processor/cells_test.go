@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		formatted string
+		typ       string
+		date1904  bool
+		want      interface{}
+		wantErr   bool
+	}{
+		{"empty", nil, "", TypeInt, false, nil, false},
+		{"string keeps the display text", int64(7), "007", TypeString, false, "007", false},
+		{"int from float", float64(12), "12", TypeInt, false, int64(12), false},
+		{"int from text", "42", " 42 ", TypeInt, false, int64(42), false},
+		{"int keeps a fraction", 1.5, "1.5", TypeInt, false, 1.5, true},
+		{"int keeps a text fraction", "2.25", "2.25", TypeInt, false, 2.25, true},
+		{"int keeps text", "n/a", "n/a", TypeInt, false, "n/a", false},
+		{"float from int", int64(3), "3", TypeFloat, false, float64(3), false},
+		{"float from text", "0.5", "0.5", TypeFloat, false, 0.5, false},
+		{"bool from int", int64(0), "0", TypeBool, false, false, false},
+		{"bool from text", "TRUE", "TRUE", TypeBool, false, true, false},
+		{"date from serial", int64(45000), "45000", TypeDate, false, "2023-03-15", false},
+		{"date from serial with time", 45000.5, "45000.5", TypeDate, false, "2023-03-15T12:00:00", false},
+		{"date from 1904 serial", int64(45000), "45000", TypeDate, true, "2027-03-16", false},
+		{"date already read as a date", "2023-03-15", "3/15/23", TypeDate, false, "2023-03-15", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := coerceValue(tt.value, tt.formatted, tt.typ, tt.date1904)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coerceValue = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoerceColumnValueSplitsArrays(t *testing.T) {
+	layout := DefaultSheetLayout()
+	got, err := layout.coerceColumnValue("1, 2,,3.5", "1, 2,,3.5", "int[]", false)
+	if want := []interface{}{int64(1), int64(2), 3.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("array = %#v, want %#v", got, want)
+	}
+	if err == nil {
+		t.Error("expected the 3.5 element to be reported")
+	}
+	if got, _ := layout.coerceColumnValue("", "", "string[]", false); !reflect.DeepEqual(got, []interface{}{}) {
+		t.Errorf("empty array cell = %#v, want []", got)
+	}
+}
+
+func TestTypeOverridesLookup(t *testing.T) {
+	o := TypeOverrides{
+		"*":                  {"Code": TypeString, "ID": TypeInt},
+		"UnitData":           {"ID": TypeFloat},
+		"Character:UnitData": {"ID": TypeString},
+	}
+	tests := []struct {
+		file, sheet, column string
+		want                string
+		ok                  bool
+	}{
+		{"Character", "UnitData", "ID", TypeString, true},
+		{"Monster", "UnitData", "ID", TypeFloat, true},
+		{"Monster", "Drops", "ID", TypeInt, true},
+		{"Monster", "Drops", "Code", TypeString, true},
+		{"Monster", "Drops", "Name", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := o.Lookup(tt.file, tt.sheet, tt.column)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%s, %s, %s) = %q, %v; want %q, %v", tt.file, tt.sheet, tt.column, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIsDateFormatCode(t *testing.T) {
+	tests := map[string]bool{
+		"yyyy-mm-dd":          true,
+		"h:mm AM/PM":          true,
+		"[h]:mm":              true,
+		"[Red]0.00":           false,
+		`0.0 "days"`:          false,
+		`#,##0 \d`:            false,
+		"0.00%":               false,
+		`[$-409]d-mmm-yy;@`:   true,
+		`_(* #,##0_);_(* "-"`: false,
+	}
+	for code, want := range tests {
+		if got := isDateFormatCode(code); got != want {
+			t.Errorf("isDateFormatCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestNumberValue(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want interface{}
+	}{
+		{3, int64(3)},
+		{-2, int64(-2)},
+		{2.5, 2.5},
+		{1 << 60, float64(1 << 60)},
+	}
+	for _, tt := range tests {
+		if got := numberValue(tt.in); got != tt.want {
+			t.Errorf("numberValue(%v) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestReadWorkbookDate1904 checks date overrides on a workbook that counts dates from 1904.
+func TestReadWorkbookDate1904(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Events.xlsx")
+	f := excelize.NewFile()
+	date1904 := true
+	if err := f.SetWorkbookProps(&excelize.WorkbookPropsOptions{Date1904: &date1904}); err != nil {
+		t.Fatal(err)
+	}
+	for cell, v := range map[string]interface{}{"A1": "ID", "B1": "Start", "A2": 1, "B2": 45000} {
+		if err := f.SetCellValue("Sheet1", cell, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	opts := &ConvertOptions{Typed: true, Overrides: TypeOverrides{"*": {"Start": TypeDate}}}
+	wb, err := ReadWorkbook(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wb.Sheets[0].Rows[0]["Start"]; got != "2027-03-16" {
+		t.Errorf("Start = %#v, want 2027-03-16", got)
+	}
+}
@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/core/x/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisSessionPrefix namespaces chat sessions, apart from the versioned sheet keys.
+const redisSessionPrefix = "session:"
+
+// QueryInput is the input of queryFlow. With a SessionID the earlier turns of that session
+// are taken into account, so follow-up questions keep their context, and the new turn is saved.
+type QueryInput struct {
+	Question  string `json:"question"`
+	SessionID string `json:"sessionId,omitempty" description:"Chat session to continue; created if it does not exist"`
+}
+
+// ChatState is the state of a chat session: its recent turns, oldest first.
+type ChatState struct {
+	Turns []ChatTurn `json:"turns"`
+}
+
+// ChatTurn is one question of a session, with the plan that answered it. Only the last
+// turn keeps its result, the dataset a follow-up question most likely refers to.
+type ChatTurn struct {
+	Question string       `json:"question"`
+	Answer   string       `json:"answer"`
+	Plan     *QueryPlan   `json:"plan,omitempty"`
+	Result   *QueryResult `json:"result,omitempty"`
+}
+
+// Add appends the turn of answer, keeping at most maxTurns turns (all of them if maxTurns <= 0).
+func (s *ChatState) Add(answer *QueryAnswer, maxTurns int) {
+	for i := range s.Turns {
+		s.Turns[i].Result = nil
+	}
+	s.Turns = append(s.Turns, ChatTurn{Question: answer.Question, Answer: answer.Answer, Plan: answer.Plan, Result: answer.Result})
+	if maxTurns > 0 && len(s.Turns) > maxTurns {
+		s.Turns = s.Turns[len(s.Turns)-maxTurns:]
+	}
+}
+
+// historyPrompt renders earlier turns for a prompt; withPlans includes the plan of each turn.
+// The result of the last turn is included too, so a follow-up can refer to its rows.
+func historyPrompt(turns []ChatTurn, withPlans bool) string {
+	if len(turns) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Conversation so far (oldest first):\n")
+	for i, t := range turns {
+		fmt.Fprintf(&b, "Q: %s\n", t.Question)
+		if withPlans && t.Plan != nil {
+			plan, _ := json.Marshal(t.Plan)
+			fmt.Fprintf(&b, "Plan: %s\n", plan)
+		}
+		if i == len(turns)-1 && t.Result != nil {
+			res, _ := json.Marshal(t.Result)
+			fmt.Fprintf(&b, "Result: %s\n", res)
+		}
+		fmt.Fprintf(&b, "A: %s\n", strings.TrimSpace(t.Answer))
+	}
+	return b.String() + "\n"
+}
+
+// ChatSessionStore keeps chat sessions in Redis as JSON under "session:<id>". Sessions expire
+// after TTL without a new turn; a zero TTL keeps them forever. It implements session.Store.
+type ChatSessionStore struct {
+	RedisAddr string
+	RedisDB   int
+	TTL       time.Duration
+}
+
+var _ session.Store[ChatState] = (*ChatSessionStore)(nil)
+
+// Get reads a session, returning nil if it does not exist or has expired.
+func (s *ChatSessionStore) Get(ctx context.Context, sessionID string) (*session.Data[ChatState], error) {
+	rdb := redis.NewClient(&redis.Options{Addr: s.RedisAddr, DB: s.RedisDB})
+	defer rdb.Close()
+
+	val, err := rdb.Get(ctx, redisSessionPrefix+sessionID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %w", sessionID, err)
+	}
+	var data session.Data[ChatState]
+	if err := json.Unmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("session %s is not valid JSON: %w", sessionID, err)
+	}
+	return &data, nil
+}
+
+// Save writes a session and restarts its TTL.
+func (s *ChatSessionStore) Save(ctx context.Context, sessionID string, data *session.Data[ChatState]) error {
+	rdb := redis.NewClient(&redis.Options{Addr: s.RedisAddr, DB: s.RedisDB})
+	defer rdb.Close()
+
+	val, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := rdb.Set(ctx, redisSessionPrefix+sessionID, val, s.TTL).Err(); err != nil {
+		return fmt.Errorf("failed to save session %s: %w", sessionID, err)
+	}
+	return nil
+}
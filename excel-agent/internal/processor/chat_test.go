@@ -0,0 +1,30 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistoryPromptKeepsLastResult(t *testing.T) {
+	var state ChatState
+	state.Add(&QueryAnswer{Question: "swords?", Answer: "two", Plan: &QueryPlan{SheetQuery: SheetQuery{Key: "Items:Weapons"}},
+		Result: &QueryResult{Key: "Items:Weapons", Columns: []string{"ID"}, Rows: [][]interface{}{{1}, {2}}, Total: 2}}, 2)
+	state.Add(&QueryAnswer{Question: "the first one?", Answer: "ID 1", Plan: &QueryPlan{SheetQuery: SheetQuery{Key: "Items:Weapons"}},
+		Result: &QueryResult{Key: "Items:Weapons", Columns: []string{"Name"}, Rows: [][]interface{}{{"Blade"}}, Total: 1}}, 2)
+	state.Add(&QueryAnswer{Question: "its price?", Answer: "100 gold",
+		Result: &QueryResult{Key: "Items:Weapons", Columns: []string{"Price"}, Rows: [][]interface{}{{100}}, Total: 1}}, 2)
+
+	if len(state.Turns) != 2 || state.Turns[0].Result != nil {
+		t.Fatalf("want two turns with only the last result kept, got %+v", state.Turns)
+	}
+	prompt := historyPrompt(state.Turns, true)
+	if strings.Contains(prompt, "swords?") {
+		t.Error("turns beyond the limit should be dropped")
+	}
+	if !strings.Contains(prompt, `Result: {"key":"Items:Weapons","columns":["Price"],"rows":[[100]],"total":1}`) {
+		t.Errorf("last result missing from the prompt:\n%s", prompt)
+	}
+	if strings.Count(prompt, "Result:") != 1 || !strings.Contains(prompt, "Plan: ") {
+		t.Errorf("want plans and a single result:\n%s", prompt)
+	}
+}
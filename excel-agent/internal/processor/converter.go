@@ -2,167 +2,346 @@ package processor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/xuri/excelize/v2"
-	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
-// ProcessXlsxFiles converts all .xlsx files in a directory to JSON.
-func ProcessXlsxFiles(xlsxDir, jsonDir string) (int, error) {
+// ConvertOptions controls how sheet rows are turned into JSON values.
+// A nil *ConvertOptions keeps the legacy behaviour: one header row and every cell as a string.
+type ConvertOptions struct {
+	// Typed reads excelize cell types so numbers, booleans and dates keep their JSON type.
+	Typed bool
+	// Overrides forces the type of ambiguous columns (e.g. IDs with leading zeros).
+	Overrides TypeOverrides
+	// Layout locates the header, type and comment rows. Nil means DefaultSheetLayout.
+	Layout *SheetLayout
+	// Formats lists the output formats to write; empty means JSON only. See ParseOutputFormats.
+	Formats []string
+	// OutputDir is where formats other than JSON are written. Empty means DefaultOutputDir.
+	OutputDir string
+	// Name is the output name, replacing the one derived from the workbook file name or
+	// spreadsheet title, so renaming the source does not change the JSON file.
+	Name string `json:",omitempty"`
+	// IncludeSheets and ExcludeSheets select tabs by name or glob pattern (e.g. "Stage*").
+	// An empty IncludeSheets selects every tab.
+	IncludeSheets []string `json:",omitempty"`
+	ExcludeSheets []string `json:",omitempty"`
+}
+
+// LoadConvertOptions builds ConvertOptions, reading the type-override and layout files if paths are given.
+func LoadConvertOptions(typed bool, overridesPath, layoutPath string) (*ConvertOptions, error) {
+	opts := &ConvertOptions{Typed: typed, Layout: DefaultSheetLayout()}
+	if overridesPath != "" {
+		overrides, err := LoadTypeOverrides(overridesPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Overrides = overrides
+	}
+	if layoutPath != "" {
+		layout, err := LoadSheetLayout(layoutPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Layout = layout
+	}
+	return opts, nil
+}
+
+func (o *ConvertOptions) layout() *SheetLayout {
+	if o == nil || o.Layout == nil {
+		return DefaultSheetLayout()
+	}
+	return o.Layout
+}
+
+func (o *ConvertOptions) overrides() TypeOverrides {
+	if o == nil {
+		return nil
+	}
+	return o.Overrides
+}
+
+func (o *ConvertOptions) formats() []string {
+	if o == nil || len(o.Formats) == 0 {
+		return []string{FormatJSON}
+	}
+	return o.Formats
+}
+
+// outputName returns Name, or fallback if no name is configured.
+func (o *ConvertOptions) outputName(fallback string) string {
+	if o == nil || o.Name == "" {
+		return fallback
+	}
+	return o.Name
+}
+
+// includeSheet reports whether a tab passes the include and exclude patterns.
+func (o *ConvertOptions) includeSheet(name string) bool {
+	if o == nil {
+		return true
+	}
+	if len(o.IncludeSheets) > 0 && !matchesAny(o.IncludeSheets, name) {
+		return false
+	}
+	return !matchesAny(o.ExcludeSheets, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); p == name || (err == nil && ok) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ConvertOptions) outputDir() string {
+	if o == nil || o.OutputDir == "" {
+		return DefaultOutputDir
+	}
+	return o.OutputDir
+}
+
+// ProcessXlsxFiles converts the .xlsx files in a directory to JSON.
+// Workbooks whose content hash matches the manifest in jsonDir are skipped unless force is set.
+func ProcessXlsxFiles(xlsxDir, jsonDir string, opts *ConvertOptions, force bool) (*ConversionReport, error) {
 	files, err := os.ReadDir(xlsxDir)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read xlsx directory: %w", err)
+		return nil, fmt.Errorf("failed to read xlsx directory: %w", err)
+	}
+
+	m, err := LoadManifest(jsonDir)
+	if err != nil {
+		return nil, err
 	}
 
-	processedCount := 0
+	report := &ConversionReport{}
+	present := make(map[string]bool)
 	for _, file := range files {
 		if file.IsDir() || filepath.Ext(file.Name()) != ".xlsx" {
 			continue
 		}
+		present[file.Name()] = true
 
 		filePath := filepath.Join(xlsxDir, file.Name())
-		if err := ConvertExcelToJSON(filePath, jsonDir); err != nil {
+		if err := m.convert(filePath, jsonDir, opts, force, report); err != nil {
 			log.Printf("Failed to convert %s: %v", file.Name(), err)
-			continue
+			report.Failed = append(report.Failed, file.Name())
 		}
-		processedCount++
 	}
+	m.prune(jsonDir, present, report)
 
-	return processedCount, nil
+	if err := m.Save(jsonDir); err != nil {
+		return report, err
+	}
+	return report, nil
 }
 
-// ConvertExcelToJSON converts a single Excel file to JSON.
-func ConvertExcelToJSON(excelPath, jsonDir string) error {
-	f, err := excelize.OpenFile(excelPath)
+// ConvertExcelToJSON converts a single Excel file to JSON, plus any other formats in opts.
+// With opts.Typed set, cell values keep their spreadsheet type instead of becoming strings.
+func ConvertExcelToJSON(excelPath, jsonDir string, opts *ConvertOptions) error {
+	wb, err := ReadWorkbook(excelPath, opts)
 	if err != nil {
 		return err
 	}
+
+	jsonPath, _, err := writeOutputs(wb, jsonDir, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %s to %s (Sheets: %d)\n", excelPath, jsonPath, len(wb.Sheets))
+	return nil
+}
+
+// ReadExcelSheets reads every sheet of an Excel file as JSON-ready rows keyed by sheet name.
+func ReadExcelSheets(excelPath string, opts *ConvertOptions) (map[string][]map[string]interface{}, error) {
+	wb, err := ReadWorkbook(excelPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return wb.sheetMap(), nil
+}
+
+// ReadWorkbook reads every sheet of an Excel file into the in-memory sheet model.
+func ReadWorkbook(excelPath string, opts *ConvertOptions) (*Workbook, error) {
+	f, err := excelize.OpenFile(excelPath)
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
 
-	allSheetsData := make(map[string][]map[string]interface{})
+	baseName := filepath.Base(excelPath)
+	fileName := opts.outputName(strings.TrimSuffix(baseName, filepath.Ext(baseName)))
+
+	wb := &Workbook{Name: fileName}
 	sheets := f.GetSheetList()
 	if len(sheets) == 0 {
-		return fmt.Errorf("no sheets found in %s", excelPath)
+		return nil, fmt.Errorf("no sheets found in %s", excelPath)
 	}
 
 	for _, sheetName := range sheets {
+		if !opts.includeSheet(sheetName) {
+			continue
+		}
 		rows, err := f.GetRows(sheetName)
 		if err != nil {
 			log.Printf("Failed to get rows for sheet %s in %s: %v", sheetName, excelPath, err)
 			continue
 		}
 
-		if len(rows) < 2 {
-			log.Printf("sheet %s in %s has not enough data", sheetName, excelPath)
-			continue
+		var cellValue cellValueFunc
+		if opts != nil && opts.Typed {
+			cellValue = newCellReader(f, sheetName).value
 		}
-
-		headers := rows[0]
-		var sheetData []map[string]interface{}
-
-		for _, row := range rows[1:] {
-			entry := make(map[string]interface{})
-			for i, cell := range row {
-				if i < len(headers) {
-					entry[headers[i]] = cell
-				}
-			}
-			sheetData = append(sheetData, entry)
+		sheet, err := buildSheet(fileName, sheetName, rows, cellValue, isDate1904(f), opts)
+		if err != nil {
+			log.Printf("Skipping sheet %s in %s: %v", sheetName, excelPath, err)
+			continue
 		}
-		allSheetsData[sheetName] = sheetData
-	}
-
-	jsonData, err := json.MarshalIndent(allSheetsData, "", "  ")
-	if err != nil {
-		return err
+		wb.Sheets = append(wb.Sheets, sheet)
 	}
+	return wb, nil
+}
 
+// excelJSONName is the JSON file name written for an Excel file.
+func excelJSONName(excelPath string, opts *ConvertOptions) string {
 	baseName := filepath.Base(excelPath)
-	jsonFileName := strings.TrimSuffix(baseName, filepath.Ext(baseName)) + ".json"
-	jsonPath := filepath.Join(jsonDir, jsonFileName)
+	return safeFileName(opts.outputName(strings.TrimSuffix(baseName, filepath.Ext(baseName)))) + ".json"
+}
 
-	if err := os.WriteFile(jsonPath, jsonData, 0644); err != nil {
-		return err
-	}
+// cellValueFunc returns the typed value of a cell given its zero-based coordinates.
+// A nil value means the cell is empty and is left out of the entry.
+type cellValueFunc func(col, row int, formatted string) (interface{}, error)
 
-	fmt.Printf("Converted %s to %s (Sheets: %d)\n", excelPath, jsonPath, len(allSheetsData))
-	return nil
-}
+// buildSheet turns the rows of a sheet into JSON entries following the sheet layout.
+// Descriptor rows and ignored columns are dropped; the type row and overrides drive value coercion.
+// date1904 is the date epoch of the workbook, for numbers coerced to dates.
+func buildSheet(fileName, sheetName string, rows [][]string, cellValue cellValueFunc, date1904 bool, opts *ConvertOptions) (*Sheet, error) {
+	layout := opts.layout()
+	start := layout.dataStart()
+	if len(rows) <= start {
+		return nil, fmt.Errorf("not enough data")
+	}
+	cols := layout.columns(rows)
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns in header row %d", layout.HeaderRow)
+	}
 
-// ConvertGoogleSheetToJSON fetches data from a Google Spreadsheet and saves it as JSON.
-func ConvertGoogleSheetToJSON(ctx context.Context, spreadsheetID, jsonDir, apiKey string) error {
-	var opts []option.ClientOption
+	sheet := &Sheet{Name: sheetName}
+	seen := make(map[string]bool)
+	for _, col := range cols {
+		if !seen[col.name] {
+			seen[col.name] = true
+			sheet.Columns = append(sheet.Columns, col.name)
+		}
+	}
 
-	if _, err := os.Stat("credentials.json"); err == nil {
-		opts = append(opts, option.WithCredentialsFile("credentials.json"))
-	} else if apiKey != "" {
-		opts = append(opts, option.WithAPIKey(apiKey))
-	} else {
-		return fmt.Errorf("credentials.json not found and GOOGLE_API_KEY not set")
+	for r := start; r < len(rows); r++ {
+		if layout.isDescriptorRow(r) {
+			continue
+		}
+		row := rows[r]
+		entry := make(map[string]interface{})
+		for _, col := range cols {
+			if col.index >= len(row) {
+				continue
+			}
+			cell := row[col.index]
+			var value interface{} = cell
+			if cellValue != nil {
+				v, err := cellValue(col.index, r, cell)
+				if err != nil {
+					return nil, err
+				}
+				if v == nil {
+					continue
+				}
+				value = v
+			}
+			var err error
+			if typ, ok := opts.overrides().Lookup(fileName, sheetName, col.name); ok {
+				value, err = coerceValue(value, cell, typ, date1904)
+			} else if col.typ != "" {
+				value, err = layout.coerceColumnValue(value, cell, col.typ, date1904)
+			}
+			if err != nil {
+				log.Printf("Warning: %s %s row %d, column %s: %v; keeping the value unrounded", fileName, sheetName, r+1, col.name, err)
+			}
+			if value == nil {
+				continue
+			}
+			entry[col.name] = value
+		}
+		sheet.Rows = append(sheet.Rows, entry)
 	}
+	return sheet, nil
+}
 
-	srv, err := sheets.NewService(ctx, opts...)
+// ConvertGoogleSheetToJSON fetches data from a Google Spreadsheet and saves it as JSON (plus any other
+// formats in opts). Tabs are read in batches as configured by fetch; the report lists the tabs that
+// failed or were skipped. Unless fetch allows partial results, nothing is written if any tab failed.
+// The sheet layout and type overrides in opts are applied the same way as for xlsx files.
+func ConvertGoogleSheetToJSON(ctx context.Context, spreadsheetID, jsonDir string, auth *GoogleAuthOptions, opts *ConvertOptions, fetch *SheetsFetchOptions) (*SheetsReport, error) {
+	srv, err := fetch.service(ctx, auth)
 	if err != nil {
-		return fmt.Errorf("unable to retrieve Sheets client: %v", err)
+		return nil, err
 	}
 
-	resp, err := srv.Spreadsheets.Get(spreadsheetID).Do()
+	tabs, report, err := fetchSpreadsheet(ctx, srv, spreadsheetID, opts.includeSheet, fetch)
 	if err != nil {
-		return fmt.Errorf("unable to retrieve spreadsheet: %v", err)
+		return report, err
 	}
 
-	allSheetsData := make(map[string][]map[string]interface{})
-
-	for _, sheet := range resp.Sheets {
-		title := sheet.Properties.Title
-
-		valResp, err := srv.Spreadsheets.Values.Get(spreadsheetID, title).Do()
-		if err != nil {
-			log.Printf("Unable to retrieve data from sheet %s: %v", title, err)
+	wb := &Workbook{Name: opts.outputName(report.Title)}
+	for _, tab := range tabs {
+		if tab.err != nil {
+			log.Printf("Unable to retrieve data from sheet %s: %v", tab.title, tab.err)
 			continue
 		}
-
-		if len(valResp.Values) < 2 {
-			log.Printf("Sheet %s has insufficient data", title)
+		s, err := buildSheet(wb.Name, tab.title, tab.rows, nil, false, opts)
+		if err != nil {
+			log.Printf("Skipping sheet %s: %v", tab.title, err)
+			report.Skipped = append(report.Skipped, SheetFailure{Sheet: tab.title, Error: err.Error()})
 			continue
 		}
+		wb.Sheets = append(wb.Sheets, s)
+		report.Sheets = append(report.Sheets, tab.title)
+	}
 
-		headers := valResp.Values[0]
-		var sheetData []map[string]interface{}
-
-		for _, row := range valResp.Values[1:] {
-			entry := make(map[string]interface{})
-			for i, cell := range row {
-				if i < len(headers) {
-					headerName := fmt.Sprintf("%v", headers[i])
-					entry[headerName] = cell
-				}
-			}
-			sheetData = append(sheetData, entry)
-		}
-		allSheetsData[title] = sheetData
+	if report.Partial() && (!fetch.allowPartial() || len(wb.Sheets) == 0) {
+		return report, fmt.Errorf("%d of %d sheets could not be read; nothing written", len(report.Failed), len(tabs))
 	}
 
-	jsonData, err := json.MarshalIndent(allSheetsData, "", "  ")
+	jsonPath, _, err := writeOutputs(wb, jsonDir, opts)
 	if err != nil {
-		return err
+		return report, err
 	}
+	report.JSONPath = jsonPath
 
-	fileName := fmt.Sprintf("%s.json", resp.Properties.Title)
-	fileName = strings.ReplaceAll(fileName, "/", "_")
-	jsonPath := filepath.Join(jsonDir, fileName)
+	fmt.Printf("Converted Spreadsheet '%s' (%s) to %s (Sheets: %d)\n", report.Title, spreadsheetID, jsonPath, len(wb.Sheets))
+	return report, nil
+}
 
-	if err := os.WriteFile(jsonPath, jsonData, 0644); err != nil {
-		return err
+// newSheetsService creates a Sheets client with the first credential method of auth that works.
+// write asks for read-write access.
+func newSheetsService(ctx context.Context, auth *GoogleAuthOptions, write bool) (*sheets.Service, error) {
+	clientOpt, method, err := auth.clientOption(ctx, write)
+	if err != nil {
+		return nil, err
 	}
-
-	fmt.Printf("Converted Spreadsheet '%s' (%s) to %s (Sheets: %d)\n", resp.Properties.Title, spreadsheetID, jsonPath, len(allSheetsData))
-	return nil
+	srv, err := sheets.NewService(ctx, clientOpt)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Sheets client (auth %s): %v", method, err)
+	}
+	return srv, nil
 }
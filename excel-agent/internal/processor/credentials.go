@@ -0,0 +1,373 @@
+package processor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Google credential methods accepted in GoogleAuthOptions.Methods.
+const (
+	// AuthFile reads a service-account or authorized-user JSON file.
+	AuthFile = "file"
+	// AuthEnv reads service-account JSON from the GOOGLE_CREDENTIALS_JSON environment variable.
+	AuthEnv = "env"
+	// AuthOAuth uses the token cached by the installed-app OAuth flow (see AuthorizeGoogleOAuth).
+	AuthOAuth = "oauth"
+	// AuthADC uses Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS, gcloud, metadata server).
+	AuthADC = "adc"
+	// AuthAPIKey uses GOOGLE_API_KEY, which can only read public spreadsheets.
+	AuthAPIKey = "apikey"
+)
+
+// DefaultAuthMethods is the order credentials are tried in when none is configured.
+var DefaultAuthMethods = []string{AuthFile, AuthEnv, AuthOAuth, AuthADC, AuthAPIKey}
+
+// Default paths of the credential files.
+const (
+	DefaultCredentialsFile = "credentials.json"
+	DefaultOAuthTokenFile  = ".google-token.json"
+)
+
+// oauthScope is requested by the OAuth flow; one token serves both reads and exports.
+const oauthScope = sheets.SpreadsheetsScope
+
+// GoogleAuthOptions configures how Sheets clients authenticate.
+type GoogleAuthOptions struct {
+	// Methods lists the credential methods to try, in order. Empty means DefaultAuthMethods.
+	Methods []string
+	// CredentialsFile is the path used by AuthFile. Empty means DefaultCredentialsFile.
+	CredentialsFile string
+	// CredentialsJSON is the service-account JSON used by AuthEnv.
+	CredentialsJSON string
+	// OAuthClientFile is the OAuth client secret of a desktop app, used by AuthOAuth.
+	OAuthClientFile string
+	// OAuthTokenFile caches the OAuth token. Empty means DefaultOAuthTokenFile.
+	OAuthTokenFile string
+	// APIKey is used by AuthAPIKey.
+	APIKey string
+}
+
+// LoadGoogleAuthOptions builds GoogleAuthOptions from configuration values; methods is a
+// comma-separated list such as "env,adc".
+func LoadGoogleAuthOptions(methods, credentialsFile, credentialsJSON, oauthClientFile, oauthTokenFile, apiKey string) (*GoogleAuthOptions, error) {
+	parsed, err := ParseAuthMethods(methods)
+	if err != nil {
+		return nil, err
+	}
+	return &GoogleAuthOptions{
+		Methods:         parsed,
+		CredentialsFile: credentialsFile,
+		CredentialsJSON: credentialsJSON,
+		OAuthClientFile: oauthClientFile,
+		OAuthTokenFile:  oauthTokenFile,
+		APIKey:          apiKey,
+	}, nil
+}
+
+// ParseAuthMethods parses a comma-separated list such as "env,adc".
+func ParseAuthMethods(spec string) ([]string, error) {
+	var methods []string
+	for _, m := range strings.Split(spec, ",") {
+		m = strings.ToLower(strings.TrimSpace(m))
+		switch m {
+		case "":
+			continue
+		case AuthFile, AuthEnv, AuthOAuth, AuthADC, AuthAPIKey:
+			methods = append(methods, m)
+		default:
+			return nil, fmt.Errorf("unknown auth method %q, want one of file, env, oauth, adc, apikey", m)
+		}
+	}
+	return methods, nil
+}
+
+func (o *GoogleAuthOptions) methods() []string {
+	if o == nil || len(o.Methods) == 0 {
+		return DefaultAuthMethods
+	}
+	return o.Methods
+}
+
+func (o *GoogleAuthOptions) credentialsFile() string {
+	if o == nil || o.CredentialsFile == "" {
+		return DefaultCredentialsFile
+	}
+	return o.CredentialsFile
+}
+
+func (o *GoogleAuthOptions) tokenFile() string {
+	if o == nil || o.OAuthTokenFile == "" {
+		return DefaultOAuthTokenFile
+	}
+	return o.OAuthTokenFile
+}
+
+// AuthAttempt is one credential method that was tried and why it was not used.
+type AuthAttempt struct {
+	Method string
+	Reason string
+}
+
+// AuthError reports that no credential method succeeded.
+type AuthError struct {
+	Attempts []AuthAttempt
+}
+
+func (e *AuthError) Error() string {
+	var b strings.Builder
+	b.WriteString("no usable Google credentials; tried:")
+	for _, a := range e.Attempts {
+		fmt.Fprintf(&b, "\n  %s: %s", a.Method, a.Reason)
+	}
+	return b.String()
+}
+
+// clientOption resolves the first working credential method. write asks for read-write access,
+// which rules out API keys. The chosen method is returned for logging.
+func (o *GoogleAuthOptions) clientOption(ctx context.Context, write bool) (option.ClientOption, string, error) {
+	scope := sheets.SpreadsheetsReadonlyScope
+	if write {
+		scope = sheets.SpreadsheetsScope
+	}
+	authErr := &AuthError{}
+	for _, m := range o.methods() {
+		opt, err := o.try(ctx, m, scope, write)
+		if err == nil {
+			return opt, m, nil
+		}
+		authErr.Attempts = append(authErr.Attempts, AuthAttempt{Method: m, Reason: err.Error()})
+	}
+	return nil, "", authErr
+}
+
+func (o *GoogleAuthOptions) try(ctx context.Context, method, scope string, write bool) (option.ClientOption, error) {
+	switch method {
+	case AuthFile:
+		path := o.credentialsFile()
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s not found", path)
+		} else if err != nil {
+			return nil, err
+		}
+		creds, err := google.CredentialsFromJSON(ctx, data, scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return option.WithCredentials(creds), nil
+
+	case AuthEnv:
+		if o == nil || o.CredentialsJSON == "" {
+			return nil, fmt.Errorf("GOOGLE_CREDENTIALS_JSON is not set")
+		}
+		var head struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(o.CredentialsJSON), &head); err != nil {
+			return nil, fmt.Errorf("GOOGLE_CREDENTIALS_JSON is not valid JSON: %v", err)
+		}
+		if head.Type != "service_account" {
+			return nil, fmt.Errorf("GOOGLE_CREDENTIALS_JSON has type %q, want service_account", head.Type)
+		}
+		creds, err := google.CredentialsFromJSON(ctx, []byte(o.CredentialsJSON), scope)
+		if err != nil {
+			return nil, fmt.Errorf("GOOGLE_CREDENTIALS_JSON: %v", err)
+		}
+		return option.WithCredentials(creds), nil
+
+	case AuthOAuth:
+		cfg, err := o.oauthConfig()
+		if err != nil {
+			return nil, err
+		}
+		tok, err := readToken(o.tokenFile())
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no cached token in %s; run -cmd auth to authorize", o.tokenFile())
+		} else if err != nil {
+			return nil, err
+		}
+		ts := &savingTokenSource{src: cfg.TokenSource(ctx, tok), path: o.tokenFile(), last: tok}
+		return option.WithTokenSource(oauth2.ReuseTokenSource(tok, ts)), nil
+
+	case AuthADC:
+		creds, err := google.FindDefaultCredentials(ctx, scope)
+		if err != nil {
+			return nil, err
+		}
+		return option.WithCredentials(creds), nil
+
+	case AuthAPIKey:
+		if o == nil || o.APIKey == "" {
+			return nil, fmt.Errorf("GOOGLE_API_KEY is not set")
+		}
+		if write {
+			return nil, fmt.Errorf("API keys are read-only and cannot write to spreadsheets")
+		}
+		return option.WithAPIKey(o.APIKey), nil
+	}
+	return nil, fmt.Errorf("unknown auth method")
+}
+
+func (o *GoogleAuthOptions) oauthConfig() (*oauth2.Config, error) {
+	if o == nil || o.OAuthClientFile == "" {
+		return nil, fmt.Errorf("GOOGLE_OAUTH_CLIENT_FILE is not set")
+	}
+	data, err := os.ReadFile(o.OAuthClientFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := google.ConfigFromJSON(data, oauthScope)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", o.OAuthClientFile, err)
+	}
+	return cfg, nil
+}
+
+// AuthorizeGoogleOAuth runs the installed-app OAuth flow: it prints a consent URL to out,
+// receives the authorization code on a loopback address and caches the token, including
+// its refresh token, in the token file for later runs.
+func AuthorizeGoogleOAuth(ctx context.Context, auth *GoogleAuthOptions, out io.Writer) error {
+	cfg, err := auth.oauthConfig()
+	if err != nil {
+		return fmt.Errorf("oauth: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("oauth: unable to listen for the redirect: %w", err)
+	}
+	defer ln.Close()
+	cfg.RedirectURL = "http://" + ln.Addr().String() + "/"
+
+	state, err := randomState()
+	if err != nil {
+		return err
+	}
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res result
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			res.err = fmt.Errorf("oauth: authorization denied: %s", q.Get("error"))
+		default:
+			res.code = q.Get("code")
+		}
+		fmt.Fprintln(w, "excel-agent: authorization received, you can close this window.")
+		select {
+		case done <- res:
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	fmt.Fprintf(out, "Open this URL in a browser to authorize Google Sheets access:\n\n%s\n\n", cfg.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce))
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("oauth: no authorization received: %w", ctx.Err())
+	}
+	if res.err != nil {
+		return res.err
+	}
+	tok, err := cfg.Exchange(ctx, res.code)
+	if err != nil {
+		return fmt.Errorf("oauth: token exchange failed: %w", err)
+	}
+	if tok.RefreshToken == "" {
+		return fmt.Errorf("oauth: no refresh token was issued; revoke the app's access and authorize again")
+	}
+	if err := writeToken(auth.tokenFile(), tok); err != nil {
+		return fmt.Errorf("oauth: %w", err)
+	}
+	fmt.Fprintf(out, "Token saved to %s\n", auth.tokenFile())
+	return nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func readToken(path string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(data, tok); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if tok.RefreshToken == "" {
+		return nil, fmt.Errorf("%s holds no refresh token; run -cmd auth again", path)
+	}
+	return tok, nil
+}
+
+// writeToken stores a token readable only by the current user.
+func writeToken(path string, tok *oauth2.Token) error {
+	data, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// savingTokenSource writes refreshed tokens back to the cache file.
+type savingTokenSource struct {
+	src  oauth2.TokenSource
+	path string
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, fmt.Errorf("oauth: refreshing the cached token in %s failed: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil || tok.AccessToken != s.last.AccessToken {
+		if tok.RefreshToken == "" && s.last != nil {
+			tok.RefreshToken = s.last.RefreshToken
+		}
+		if err := writeToken(s.path, tok); err != nil {
+			return nil, err
+		}
+		s.last = tok
+	}
+	return tok, nil
+}
@@ -0,0 +1,483 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/redis/go-redis/v9"
+)
+
+// DiffRedis names the current Redis version as a side of a diff.
+const DiffRedis = "redis"
+
+// Output formats of WorkbookDiff.Render.
+const (
+	DiffFormatText = "text"
+	DiffFormatJSON = "json"
+	DiffFormatHTML = "html"
+)
+
+// diffSummaryLimit bounds the diff JSON sent to the model for a summary.
+const diffSummaryLimit = 100000
+
+// DiffOptions configures DiffWorkbooks.
+type DiffOptions struct {
+	// Convert is used to read workbooks; it should match how the other side was converted.
+	Convert *ConvertOptions
+	// Keys decides the primary key rows are matched by. Sheets without one are matched by position.
+	Keys      *KeyColumns
+	RedisAddr string
+	RedisDB   int
+	// Sources, if set, gives listed workbooks their stable name, tab selection and header row.
+	// A workbook is matched by file name, so an older copy kept elsewhere maps to the same source.
+	Sources []*Source
+}
+
+// diffSide is one side of a diff: its path, the file name it stands for and how a workbook is read.
+type diffSide struct {
+	path    string
+	name    string
+	convert *ConvertOptions
+}
+
+// WorkbookDiff lists what changed between two versions of a workbook, sheet by sheet.
+// Only sheets with changes are listed; Unchanged counts the others.
+type WorkbookDiff struct {
+	Old       string      `json:"old"`
+	New       string      `json:"new"`
+	Sheets    []SheetDiff `json:"sheets"`
+	Unchanged int         `json:"unchanged"`
+	Summary   string      `json:"summary,omitempty"`
+}
+
+// SheetDiff is the change of one sheet. Status is "added", "removed" or "modified".
+// Key is the primary-key column rows were matched by, or empty if they were matched by position.
+type SheetDiff struct {
+	Sheet          string      `json:"sheet"`
+	Status         string      `json:"status"`
+	Key            string      `json:"key,omitempty"`
+	AddedColumns   []string    `json:"added_columns,omitempty"`
+	RemovedColumns []string    `json:"removed_columns,omitempty"`
+	Added          []RowChange `json:"added,omitempty"`
+	Removed        []RowChange `json:"removed,omitempty"`
+	Modified       []RowChange `json:"modified,omitempty"`
+}
+
+// RowChange is an added or removed row with its values, or a modified row with its changed cells.
+// Row is the spreadsheet row in the new version, or in the old one for removed rows.
+type RowChange struct {
+	ID     string                 `json:"id"`
+	Row    int                    `json:"row"`
+	Values map[string]interface{} `json:"values,omitempty"`
+	Cells  []CellDiff             `json:"cells,omitempty"`
+}
+
+// CellDiff is the old and new value of a changed cell; a nil value means the cell is empty.
+type CellDiff struct {
+	Column string      `json:"column"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// Statuses of a SheetDiff.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// HasChanges reports whether any sheet differs.
+func (d *WorkbookDiff) HasChanges() bool {
+	return len(d.Sheets) > 0
+}
+
+// DiffWorkbooks compares two versions of a workbook. Each side is an .xlsx workbook, a converted
+// .json file, or DiffRedis for the current Redis version of the other side's file. Rows are
+// matched by primary key, so reordered rows are not reported as changes.
+func DiffWorkbooks(ctx context.Context, oldPath, newPath string, opts DiffOptions) (*WorkbookDiff, error) {
+	if oldPath == DiffRedis && newPath == DiffRedis {
+		return nil, fmt.Errorf("at most one side of a diff can be %s", DiffRedis)
+	}
+	oldSide, err := opts.side(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newSide, err := opts.side(newPath)
+	if err != nil {
+		return nil, err
+	}
+	// The Redis side stands for the file of the other side.
+	if oldPath == DiffRedis {
+		oldSide.name, oldSide.convert = newSide.name, newSide.convert
+	}
+	if newPath == DiffRedis {
+		newSide.name, newSide.convert = oldSide.name, oldSide.convert
+	}
+	name := newSide.name
+	older, err := readDiffSide(ctx, oldSide, opts)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := readDiffSide(ctx, newSide, opts)
+	if err != nil {
+		return nil, err
+	}
+	layout := newSide.convert.layout()
+
+	diff := &WorkbookDiff{Old: oldPath, New: newPath, Sheets: []SheetDiff{}}
+	oldSheets := make(map[string]*Sheet, len(older.Sheets))
+	for _, s := range older.Sheets {
+		oldSheets[s.Name] = s
+	}
+	seen := make(map[string]bool)
+	for _, s := range newer.Sheets {
+		seen[s.Name] = true
+		sd := diffSheet(name, oldSheets[s.Name], s, opts.Keys, layout)
+		if sd == nil {
+			diff.Unchanged++
+			continue
+		}
+		diff.Sheets = append(diff.Sheets, *sd)
+	}
+	for _, s := range older.Sheets {
+		if !seen[s.Name] {
+			diff.Sheets = append(diff.Sheets, *diffSheet(name, s, nil, opts.Keys, layout))
+		}
+	}
+	return diff, nil
+}
+
+// side resolves the file name a path stands for, used to find primary keys and Redis keys.
+// A workbook listed in Sources stands for the source's name; other paths for their base name.
+func (o DiffOptions) side(path string) (*diffSide, error) {
+	base := filepath.Base(path)
+	side := &diffSide{path: path, name: strings.TrimSuffix(base, filepath.Ext(base)), convert: o.Convert}
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".xlsx" && ext != ".xlsm" {
+		return side, nil
+	}
+	for _, s := range SourcesOfKind(o.Sources, false) {
+		if !strings.EqualFold(filepath.Base(s.Workbook), base) {
+			continue
+		}
+		convert, err := s.ConvertOptions(o.Convert)
+		if err != nil {
+			return nil, err
+		}
+		side.name, side.convert = s.Name, convert
+		break
+	}
+	return side, nil
+}
+
+// readDiffSide reads one side of a diff into the sheet model.
+func readDiffSide(ctx context.Context, side *diffSide, opts DiffOptions) (*Workbook, error) {
+	if side.path == DiffRedis {
+		return readRedisWorkbook(ctx, side.name, opts.RedisAddr, opts.RedisDB)
+	}
+	switch strings.ToLower(filepath.Ext(side.path)) {
+	case ".xlsx", ".xlsm":
+		return ReadWorkbook(side.path, side.convert)
+	case ".json":
+		return ReadJSONWorkbook(side.path)
+	}
+	return nil, fmt.Errorf("cannot diff %s: want an .xlsx or .json file, or %s", side.path, DiffRedis)
+}
+
+// readRedisWorkbook reads the sheets of a file from the current Redis version, sorted by name.
+func readRedisWorkbook(ctx context.Context, name, redisAddr string, redisDB int) (*Workbook, error) {
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr, DB: redisDB})
+	defer rdb.Close()
+
+	if _, ok, err := currentVersion(ctx, rdb); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	} else if !ok {
+		return nil, fmt.Errorf("no version has been published to Redis")
+	}
+	prefix, err := currentKey(ctx, rdb, "")
+	if err != nil {
+		return nil, err
+	}
+	names, err := redisSheetNames(ctx, rdb, prefix, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s is not in the current Redis version", name)
+	}
+	slices.Sort(names)
+	wb := &Workbook{Name: name}
+	for _, sheetName := range names {
+		sheet, err := readCurrentSheet(ctx, rdb, name+":"+sheetName)
+		if err != nil {
+			return nil, err
+		}
+		sheet.Name = sheetName
+		wb.Sheets = append(wb.Sheets, sheet)
+	}
+	return wb, nil
+}
+
+// diffSheet compares two versions of a sheet, either of which may be nil. It returns nil if they are equal.
+func diffSheet(file string, older, newer *Sheet, keys *KeyColumns, layout *SheetLayout) *SheetDiff {
+	switch {
+	case older == nil:
+		sd := &SheetDiff{Sheet: newer.Name, Status: DiffAdded, AddedColumns: newer.Columns}
+		sd.Key, _ = keys.Find(file, newer.Name, newer.Columns)
+		for i, row := range newer.Rows {
+			sd.Added = append(sd.Added, RowChange{ID: rowID(row, sd.Key, i), Row: layout.sheetRow(i), Values: row})
+		}
+		return sd
+	case newer == nil:
+		sd := &SheetDiff{Sheet: older.Name, Status: DiffRemoved, RemovedColumns: older.Columns}
+		sd.Key, _ = keys.Find(file, older.Name, older.Columns)
+		for i, row := range older.Rows {
+			sd.Removed = append(sd.Removed, RowChange{ID: rowID(row, sd.Key, i), Row: layout.sheetRow(i), Values: row})
+		}
+		return sd
+	}
+
+	sd := &SheetDiff{Sheet: newer.Name, Status: DiffModified}
+	oldCols, newCols := columnsOf(older), columnsOf(newer)
+	for _, c := range newCols {
+		if !slices.Contains(oldCols, c) {
+			sd.AddedColumns = append(sd.AddedColumns, c)
+		}
+	}
+	for _, c := range oldCols {
+		if !slices.Contains(newCols, c) {
+			sd.RemovedColumns = append(sd.RemovedColumns, c)
+		}
+	}
+	// Rows are matched by a key both versions have; otherwise by position.
+	if k, ok := keys.Find(file, newer.Name, newCols); ok && slices.Contains(oldCols, k) {
+		sd.Key = k
+	}
+	// Added and removed columns are reported once above, not as a change in every row.
+	var columns []string
+	for _, c := range newCols {
+		if slices.Contains(oldCols, c) {
+			columns = append(columns, c)
+		}
+	}
+
+	oldIDs := rowIDs(older.Rows, sd.Key)
+	oldIndex := make(map[string]int, len(oldIDs))
+	for i, id := range oldIDs {
+		oldIndex[id] = i
+	}
+	matched := make(map[string]bool)
+	for i, id := range rowIDs(newer.Rows, sd.Key) {
+		row := newer.Rows[i]
+		j, ok := oldIndex[id]
+		if !ok {
+			sd.Added = append(sd.Added, RowChange{ID: id, Row: layout.sheetRow(i), Values: row})
+			continue
+		}
+		matched[id] = true
+		var cells []CellDiff
+		for _, c := range columns {
+			if !sameValue(older.Rows[j][c], row[c]) {
+				cells = append(cells, CellDiff{Column: c, Old: emptyToNil(older.Rows[j][c]), New: emptyToNil(row[c])})
+			}
+		}
+		if len(cells) > 0 {
+			sd.Modified = append(sd.Modified, RowChange{ID: id, Row: layout.sheetRow(i), Cells: cells})
+		}
+	}
+	for j, id := range oldIDs {
+		if !matched[id] {
+			sd.Removed = append(sd.Removed, RowChange{ID: id, Row: layout.sheetRow(j), Values: older.Rows[j]})
+		}
+	}
+
+	if len(sd.AddedColumns)+len(sd.RemovedColumns)+len(sd.Added)+len(sd.Removed)+len(sd.Modified) == 0 {
+		return nil
+	}
+	return sd
+}
+
+// rowIDs identifies each row by primary key or position. Repeated keys get a "#2", "#3", ... suffix
+// so every row can still be matched.
+func rowIDs(rows []map[string]interface{}, key string) []string {
+	ids := make([]string, len(rows))
+	count := make(map[string]int)
+	for i, row := range rows {
+		id := rowID(row, key, i)
+		count[id]++
+		if n := count[id]; n > 1 {
+			id = fmt.Sprintf("%s#%d", id, n)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func columnsOf(s *Sheet) []string {
+	if len(s.Columns) > 0 {
+		return s.Columns
+	}
+	return rowColumns(s.Rows)
+}
+
+// sameValue compares cells by their JSON form, so int64 1 and float64 1 are equal, as are
+// missing and empty cells.
+func sameValue(a, b interface{}) bool {
+	if isEmptyValue(a) || isEmptyValue(b) {
+		return isEmptyValue(a) && isEmptyValue(b)
+	}
+	return canonicalJSON(a) == canonicalJSON(b)
+}
+
+func canonicalJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var canonical interface{}
+	if err := json.Unmarshal(data, &canonical); err != nil {
+		return string(data)
+	}
+	data, _ = json.Marshal(canonical)
+	return string(data)
+}
+
+func emptyToNil(v interface{}) interface{} {
+	if isEmptyValue(v) {
+		return nil
+	}
+	return v
+}
+
+// Render formats the diff as text, JSON or HTML.
+func (d *WorkbookDiff) Render(format string) (string, error) {
+	switch format {
+	case "", DiffFormatText:
+		return d.String(), nil
+	case DiffFormatJSON:
+		return string(marshalText(d, "  ")), nil
+	case DiffFormatHTML:
+		var b strings.Builder
+		if err := diffHTML.Execute(&b, d); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("unknown diff format %q, want text, json or html", format)
+}
+
+func (d *WorkbookDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s -> %s: %d sheets changed, %d unchanged", d.Old, d.New, len(d.Sheets), d.Unchanged)
+	if d.Summary != "" {
+		fmt.Fprintf(&b, "\n\n%s\n", strings.TrimSpace(d.Summary))
+	}
+	for _, s := range d.Sheets {
+		match := "by position"
+		if s.Key != "" {
+			match = "by " + s.Key
+		}
+		fmt.Fprintf(&b, "\n[%s] %s (%s): %d added, %d removed, %d modified rows",
+			s.Status, s.Sheet, match, len(s.Added), len(s.Removed), len(s.Modified))
+		if len(s.AddedColumns) > 0 {
+			fmt.Fprintf(&b, "\n  + columns: %s", strings.Join(s.AddedColumns, ", "))
+		}
+		if len(s.RemovedColumns) > 0 {
+			fmt.Fprintf(&b, "\n  - columns: %s", strings.Join(s.RemovedColumns, ", "))
+		}
+		for _, r := range s.Added {
+			fmt.Fprintf(&b, "\n  + %s (row %d): %s", r.ID, r.Row, marshalText(r.Values, ""))
+		}
+		for _, r := range s.Removed {
+			fmt.Fprintf(&b, "\n  - %s (row %d): %s", r.ID, r.Row, marshalText(r.Values, ""))
+		}
+		for _, r := range s.Modified {
+			parts := make([]string, len(r.Cells))
+			for i, c := range r.Cells {
+				parts[i] = fmt.Sprintf("%s: %s -> %s", c.Column, marshalText(c.Old, ""), marshalText(c.New, ""))
+			}
+			fmt.Fprintf(&b, "\n  ~ %s (row %d): %s", r.ID, r.Row, strings.Join(parts, "; "))
+		}
+	}
+	return b.String()
+}
+
+var diffHTML = template.Must(template.New("diff").Funcs(template.FuncMap{
+	"json": func(v interface{}) string { return string(marshalText(v, "")) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Old}} → {{.New}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+.added { background: #e6ffec; }
+.removed { background: #ffebe9; }
+.old { color: #cf222e; text-decoration: line-through; }
+.new { color: #1a7f37; }
+pre { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Old}} → {{.New}}</h1>
+<p>{{len .Sheets}} sheets changed, {{.Unchanged}} unchanged</p>
+{{with .Summary}}<h2>Summary</h2><pre>{{.}}</pre>{{end}}
+{{range .Sheets}}
+<h2>{{.Sheet}} <small>({{.Status}}{{with .Key}}, matched by {{.}}{{end}})</small></h2>
+{{with .AddedColumns}}<p class="new">Added columns: {{range $i, $c := .}}{{if $i}}, {{end}}{{$c}}{{end}}</p>{{end}}
+{{with .RemovedColumns}}<p class="old">Removed columns: {{range $i, $c := .}}{{if $i}}, {{end}}{{$c}}{{end}}</p>{{end}}
+{{if .Modified}}
+<table>
+<tr><th>ID</th><th>Row</th><th>Column</th><th>Old</th><th>New</th></tr>
+{{range .Modified}}{{$r := .}}{{range $i, $c := .Cells}}
+<tr>{{if not $i}}<td rowspan="{{len $r.Cells}}">{{$r.ID}}</td><td rowspan="{{len $r.Cells}}">{{$r.Row}}</td>{{end}}<td>{{$c.Column}}</td><td class="old">{{json $c.Old}}</td><td class="new">{{json $c.New}}</td></tr>
+{{end}}{{end}}
+</table>
+{{end}}
+{{if or .Added .Removed}}
+<table>
+<tr><th></th><th>ID</th><th>Row</th><th>Values</th></tr>
+{{range .Added}}<tr class="added"><td>+</td><td>{{.ID}}</td><td>{{.Row}}</td><td>{{json .Values}}</td></tr>
+{{end}}{{range .Removed}}<tr class="removed"><td>-</td><td>{{.ID}}</td><td>{{.Row}}</td><td>{{json .Values}}</td></tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+const diffSummaryPrompt = `You review changes to game data tables for a game designer.
+Summarize the changes that matter for game balance: stats, costs, rewards, drop rates, cooldowns, level requirements and the like.
+Group them by sheet, name the rows by ID (and name if there is one), and give old -> new values with the direction of the change.
+Mention added and removed rows briefly, and note cosmetic changes (text, icons) in a single line at most.
+Do not invent changes that are not in the diff. Answer in Korean.`
+
+// SummarizeDiff asks the model for a summary of the balance-relevant changes of d.
+func SummarizeDiff(ctx context.Context, g *genkit.Genkit, d *WorkbookDiff) (string, error) {
+	data := marshalText(d, "")
+	note := ""
+	if len(data) > diffSummaryLimit {
+		data = bytes.ToValidUTF8(data[:diffSummaryLimit], nil)
+		note = "\n(The diff was cut off here; mention that the summary is partial.)"
+	}
+	text, err := genkit.GenerateText(ctx, g,
+		ai.WithSystem(diffSummaryPrompt),
+		// Cell values may contain printf verbs, so the prompt must not be a format string.
+		ai.WithPrompt("%s", fmt.Sprintf("Diff of %s -> %s:\n%s%s", d.Old, d.New, data, note)),
+	)
+	if err != nil {
+		return "", fmt.Errorf("AI diff summary failed: %v", err)
+	}
+	return text, nil
+}
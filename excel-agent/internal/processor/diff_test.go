@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func diffKeys(t *testing.T) *KeyColumns {
+	t.Helper()
+	keys, err := ParseKeyColumns("ID", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestDiffSheetMatchesRowsByKey(t *testing.T) {
+	older := &Sheet{Name: "Items", Columns: []string{"ID", "Name", "Price"}, Rows: []map[string]interface{}{
+		{"ID": int64(1), "Name": "Sword", "Price": int64(100)},
+		{"ID": int64(2), "Name": "Shield", "Price": int64(80)},
+		{"ID": int64(3), "Name": "Bow", "Price": int64(120)},
+	}}
+	newer := &Sheet{Name: "Items", Columns: []string{"ID", "Name", "Price"}, Rows: []map[string]interface{}{
+		{"ID": int64(2), "Name": "Shield", "Price": float64(80)},
+		{"ID": int64(1), "Name": "Sword", "Price": int64(150)},
+		{"ID": int64(4), "Name": "Staff", "Price": int64(90)},
+	}}
+
+	sd := diffSheet("Items", older, newer, diffKeys(t), DefaultSheetLayout())
+	if sd == nil {
+		t.Fatal("expected a diff")
+	}
+	if sd.Status != DiffModified || sd.Key != "ID" {
+		t.Errorf("status/key = %s/%s, want modified/ID", sd.Status, sd.Key)
+	}
+	// The reordered Shield row with an equal float price is not a change.
+	if len(sd.Modified) != 1 || sd.Modified[0].ID != "1" || sd.Modified[0].Row != 3 {
+		t.Fatalf("modified = %+v, want only row 1 at sheet row 3", sd.Modified)
+	}
+	if c := sd.Modified[0].Cells; len(c) != 1 || c[0].Column != "Price" || c[0].Old != int64(100) || c[0].New != int64(150) {
+		t.Errorf("cells = %+v, want Price 100 -> 150", c)
+	}
+	if len(sd.Added) != 1 || sd.Added[0].ID != "4" {
+		t.Errorf("added = %+v, want row 4", sd.Added)
+	}
+	if len(sd.Removed) != 1 || sd.Removed[0].ID != "3" || sd.Removed[0].Row != 4 {
+		t.Errorf("removed = %+v, want row 3 at sheet row 4", sd.Removed)
+	}
+}
+
+func TestDiffSheetReportsColumnChangesOnce(t *testing.T) {
+	older := &Sheet{Name: "Items", Columns: []string{"ID", "Name", "Icon"}, Rows: []map[string]interface{}{
+		{"ID": int64(1), "Name": "Sword", "Icon": "sword.png"},
+		{"ID": int64(2), "Name": "Shield", "Icon": "shield.png"},
+	}}
+	newer := &Sheet{Name: "Items", Columns: []string{"ID", "Name", "Weight"}, Rows: []map[string]interface{}{
+		{"ID": int64(1), "Name": "Sword", "Weight": 3.5},
+		{"ID": int64(2), "Name": "Great Shield", "Weight": 6.0},
+	}}
+
+	sd := diffSheet("Items", older, newer, diffKeys(t), DefaultSheetLayout())
+	if sd == nil {
+		t.Fatal("expected a diff")
+	}
+	if len(sd.AddedColumns) != 1 || sd.AddedColumns[0] != "Weight" {
+		t.Errorf("added columns = %v, want Weight", sd.AddedColumns)
+	}
+	if len(sd.RemovedColumns) != 1 || sd.RemovedColumns[0] != "Icon" {
+		t.Errorf("removed columns = %v, want Icon", sd.RemovedColumns)
+	}
+	if len(sd.Modified) != 1 || sd.Modified[0].ID != "2" {
+		t.Fatalf("modified = %+v, want only row 2", sd.Modified)
+	}
+	if c := sd.Modified[0].Cells; len(c) != 1 || c[0].Column != "Name" {
+		t.Errorf("cells = %+v, want only Name", c)
+	}
+}
+
+func TestDiffSheetByPosition(t *testing.T) {
+	older := &Sheet{Name: "Notes", Columns: []string{"Text"}, Rows: []map[string]interface{}{
+		{"Text": "a"}, {"Text": ""},
+	}}
+	newer := &Sheet{Name: "Notes", Columns: []string{"Text"}, Rows: []map[string]interface{}{
+		{"Text": "a"}, {}, {"Text": "c"},
+	}}
+
+	sd := diffSheet("Notes", older, newer, diffKeys(t), DefaultSheetLayout())
+	if sd == nil {
+		t.Fatal("expected a diff")
+	}
+	if sd.Key != "" {
+		t.Errorf("key = %q, want rows matched by position", sd.Key)
+	}
+	// An empty and a missing cell are the same.
+	if len(sd.Modified) != 0 || len(sd.Removed) != 0 || len(sd.Added) != 1 {
+		t.Errorf("got %d modified, %d removed, %d added; want only one added row", len(sd.Modified), len(sd.Removed), len(sd.Added))
+	}
+	if diffSheet("Notes", older, older, diffKeys(t), DefaultSheetLayout()) != nil {
+		t.Error("a sheet compared with itself should have no diff")
+	}
+}
+
+func TestRowIDsNumberRepeatedKeys(t *testing.T) {
+	rows := []map[string]interface{}{{"ID": "a"}, {"ID": "b"}, {"ID": "a"}, {"ID": "a"}}
+	got := rowIDs(rows, "ID")
+	want := []string{"a", "b", "a#2", "a#3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rowIDs = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDiffWorkbooksResolvesSourceNames(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	xlsxDir, jsonDir, oldDir := t.TempDir(), t.TempDir(), t.TempDir()
+	writeItemWorkbook(t, filepath.Join(xlsxDir, "ItemTable_v3.xlsx"))
+	writeItemWorkbook(t, filepath.Join(oldDir, "ItemTable_v3.xlsx"))
+	sources := []*Source{{Name: "Item", Workbook: "ItemTable_v3.xlsx", Include: []string{"ItemList"}}}
+	if _, err := ProcessXlsxSources(xlsxDir, jsonDir, sources, &ConvertOptions{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CacheJSONToRedis(ctx, jsonDir, mr.Addr(), 0, &RedisOptions{Layout: RedisLayoutJSON}); err != nil {
+		t.Fatal(err)
+	}
+	opts := DiffOptions{Convert: &ConvertOptions{}, Keys: diffKeys(t), RedisAddr: mr.Addr(), Sources: sources}
+
+	tests := []struct {
+		name, old, new string
+	}{
+		{"redis to workbook", DiffRedis, filepath.Join(xlsxDir, "ItemTable_v3.xlsx")},
+		{"older copy to redis", filepath.Join(oldDir, "ItemTable_v3.xlsx"), DiffRedis},
+		{"json to workbook", filepath.Join(jsonDir, "Item.json"), filepath.Join(xlsxDir, "ItemTable_v3.xlsx")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffWorkbooks(ctx, tt.old, tt.new, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The Draft tab is not part of the source, so it is not reported as added.
+			if diff.HasChanges() || diff.Unchanged != 1 {
+				t.Errorf("diff = %+v, want ItemList unchanged", diff)
+			}
+		})
+	}
+
+	opts.Sources = nil
+	if _, err := DiffWorkbooks(ctx, DiffRedis, filepath.Join(xlsxDir, "ItemTable_v3.xlsx"), opts); err == nil || !strings.Contains(err.Error(), "ItemTable_v3 is not in the current Redis version") {
+		t.Errorf("without sources: err = %v, want the workbook's base name looked up", err)
+	}
+}
@@ -0,0 +1,448 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// maxListedChanges bounds how many cell changes ExportReport.String prints.
+const maxListedChanges = 50
+
+// CellChange is one cell that an export writes.
+type CellChange struct {
+	Sheet string `json:"sheet"`
+	Cell  string `json:"cell"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+
+	row, col int // zero-based
+	value    interface{}
+	date     bool // value is the date of a date column
+}
+
+// ExportReport lists what an export changed, or would change in a dry run.
+type ExportReport struct {
+	Target    string       `json:"target"`
+	NewSheets []string     `json:"new_sheets,omitempty"`
+	Changes   []CellChange `json:"changes,omitempty"`
+	Written   bool         `json:"written"`
+}
+
+func (r *ExportReport) String() string {
+	var b strings.Builder
+	verb := "would change"
+	if r.Written {
+		verb = "changed"
+	}
+	fmt.Fprintf(&b, "%s: %s %d cells", r.Target, verb, len(r.Changes))
+	if len(r.NewSheets) > 0 {
+		fmt.Fprintf(&b, ", new sheets: %s", strings.Join(r.NewSheets, ", "))
+	}
+	for i, c := range r.Changes {
+		if i == maxListedChanges {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(r.Changes)-maxListedChanges)
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s!%s: %q -> %q", c.Sheet, c.Cell, c.Old, c.New)
+	}
+	return b.String()
+}
+
+// sheetGrid is the current content of a sheet being exported to. Values are typed the way the
+// converters read them, so they compare directly with converted JSON; text is what the
+// spreadsheet displays and is used for the header and type rows and for reports.
+type sheetGrid struct {
+	text   [][]string
+	values [][]interface{}
+	// dates marks columns holding date-formatted cells.
+	dates map[int]bool
+}
+
+// readXlsxGrid reads a worksheet with typed values, like a typed conversion does.
+func readXlsxGrid(f *excelize.File, sheet string) (*sheetGrid, error) {
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, err
+	}
+	reader := newCellReader(f, sheet)
+	g := &sheetGrid{text: rows, values: make([][]interface{}, len(rows)), dates: make(map[int]bool)}
+	for r, row := range rows {
+		g.values[r] = make([]interface{}, len(row))
+		for c, text := range row {
+			v, err := reader.value(c, r, text)
+			if err != nil {
+				return nil, err
+			}
+			g.values[r][c] = v
+			if s, ok := v.(string); ok && isDateText(s) {
+				if axis, err := excelize.CoordinatesToCellName(c+1, r+1); err == nil && reader.isDateCell(axis) {
+					g.dates[c] = true
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *sheetGrid) rows() int {
+	if g == nil {
+		return 0
+	}
+	return len(g.text)
+}
+
+func (g *sheetGrid) width() int {
+	w := 0
+	for r := range g.rows() {
+		w = max(w, len(g.text[r]))
+	}
+	return w
+}
+
+func (g *sheetGrid) textAt(row, col int) string {
+	if r := cellRow(g.textRows(), row); col < len(r) {
+		return r[col]
+	}
+	return ""
+}
+
+func (g *sheetGrid) valueAt(row, col int) interface{} {
+	if g == nil || row >= len(g.values) || col >= len(g.values[row]) {
+		return nil
+	}
+	return g.values[row][col]
+}
+
+func (g *sheetGrid) textRows() [][]string {
+	if g == nil {
+		return nil
+	}
+	return g.text
+}
+
+// planSheetUpdate computes the cells to write so that a sheet whose current content is
+// current (nil for a new sheet) holds the rows of sheet. Existing header positions are
+// kept, unknown columns are appended after the header, and rows past the new data are emptied.
+// Rows are matched to the current ones by the key column, so the cells of columns that are
+// not exported (ignored and comment columns) move with their row when rows are added or removed.
+func planSheetUpdate(sheet *Sheet, current *sheetGrid, layout *SheetLayout, key string) ([]CellChange, error) {
+	var changes []CellChange
+	set := func(row, col int, value interface{}, date bool) error {
+		if sameCellValue(current.valueAt(row, col), current.textAt(row, col), value, date) {
+			return nil
+		}
+		cell, err := excelize.CoordinatesToCellName(col+1, row+1)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, CellChange{
+			Sheet: sheet.Name, Cell: cell, Old: current.textAt(row, col), New: textValue(value),
+			row: row, col: col, value: value, date: date,
+		})
+		return nil
+	}
+
+	index := make(map[string]int)
+	dates := make(map[int]bool)
+	next := 0
+	if headers := cellRow(current.textRows(), layout.HeaderRow-1); headers != nil {
+		for _, c := range layout.columns(current.text) {
+			if _, dup := index[c.name]; !dup {
+				index[c.name] = c.index
+			}
+			if typ, _ := normalizeColumnType(c.typ); typ == TypeDate {
+				dates[c.index] = true
+			}
+		}
+		next = len(headers)
+	}
+	keyCol := -1
+	if c, ok := index[key]; ok && key != "" {
+		keyCol = c
+	}
+	for _, col := range sheet.Columns {
+		if _, ok := index[col]; ok {
+			continue
+		}
+		index[col] = next
+		if err := set(layout.HeaderRow-1, next, col, false); err != nil {
+			return nil, err
+		}
+		next++
+	}
+	written := make(map[int]bool, len(sheet.Columns))
+	for _, col := range sheet.Columns {
+		written[index[col]] = true
+		if isDateColumn(sheet, col) || (current != nil && current.dates[index[col]]) {
+			dates[index[col]] = true
+		}
+	}
+	var carried []int
+	if current != nil {
+		for c := range current.width() {
+			if !written[c] {
+				carried = append(carried, c)
+			}
+		}
+	}
+
+	var oldRows []int
+	carries := false
+	for r := layout.dataStart(); r < current.rows(); r++ {
+		if layout.isDescriptorRow(r) {
+			continue
+		}
+		oldRows = append(oldRows, r)
+		for _, c := range carried {
+			carries = carries || current.valueAt(r, c) != nil
+		}
+	}
+	source, err := matchRows(sheet, current, oldRows, key, keyCol, carries)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := layout.separator()
+	r := layout.dataStart()
+	for i, row := range sheet.Rows {
+		for layout.isDescriptorRow(r) {
+			r++
+		}
+		for _, col := range sheet.Columns {
+			c := index[col]
+			if err := set(r, c, cellValue(row[col], sep), dates[c]); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range carried {
+			var v interface{}
+			if source[i] >= 0 {
+				v = current.valueAt(source[i], c)
+			}
+			if err := set(r, c, v, current.dates[c]); err != nil {
+				return nil, err
+			}
+		}
+		r++
+	}
+	for ; r < current.rows(); r++ {
+		if layout.isDescriptorRow(r) {
+			continue
+		}
+		for col := range current.values[r] {
+			if err := set(r, col, nil, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// matchRows returns for every new row the current row it replaces, or -1 for an added row.
+// Rows are matched by the key column, rows with the same key in order; without a key column
+// they are matched by position, which is refused if the row count changes while the sheet
+// has columns the export does not write, since their cells would end up on the wrong rows.
+func matchRows(sheet *Sheet, current *sheetGrid, oldRows []int, key string, keyCol int, carries bool) ([]int, error) {
+	source := make([]int, len(sheet.Rows))
+	if keyCol < 0 {
+		if carries && len(oldRows) > 0 && len(oldRows) != len(sheet.Rows) {
+			return nil, fmt.Errorf("rows cannot be matched: the sheet has no primary key column and has columns that are not exported, "+
+				"so changing its %d rows to %d would move their cells to other rows", len(oldRows), len(sheet.Rows))
+		}
+		for i := range source {
+			source[i] = -1
+			if i < len(oldRows) {
+				source[i] = oldRows[i]
+			}
+		}
+		return source, nil
+	}
+
+	byKey := make(map[string][]int)
+	for _, r := range oldRows {
+		k := textValue(current.valueAt(r, keyCol))
+		byKey[k] = append(byKey[k], r)
+	}
+	for i, row := range sheet.Rows {
+		source[i] = -1
+		k := textValue(row[key])
+		if rows := byKey[k]; len(rows) > 0 {
+			source[i], byKey[k] = rows[0], rows[1:]
+		}
+	}
+	return source, nil
+}
+
+// isDateColumn reports whether every value of a column is a date, as written by the converters.
+func isDateColumn(sheet *Sheet, col string) bool {
+	k := &kindSet{}
+	for _, row := range sheet.Rows {
+		k.add(row[col])
+	}
+	return k.goType() == dateType
+}
+
+// sameCellValue reports whether a cell holding old, displayed as oldText, already holds v.
+// Numbers compare by value and a date with the serial number of a date cell, so number
+// formats such as "1,000" and the float or int form of a number do not show up as changes.
+func sameCellValue(old interface{}, oldText string, v interface{}, date bool) bool {
+	if a, ok := old.(int64); ok {
+		if b, ok := v.(int64); ok {
+			return a == b
+		}
+	}
+	if a, ok := cellNumber(old); ok {
+		if t, ok := dateValue(v); ok && date {
+			return a == excelSerial(t)
+		}
+		if b, ok := cellNumber(v); ok {
+			return a == b
+		}
+	}
+	text := textValue(v)
+	return text == textValue(old) || (oldText != "" && text == oldText)
+}
+
+// cellNumber returns the value of a number; numeric text is not a number.
+func cellNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// dateValue parses a date as written by the converters.
+func dateValue(v interface{}) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// excelSerial is the spreadsheet serial number of a date (days since 1899-12-30).
+func excelSerial(t time.Time) float64 {
+	return float64(t.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC))) / float64(24*time.Hour)
+}
+
+// writeValue is the value to write for a change. Dates of date columns are passed through date,
+// so each target stores them as its own date type; other values keep their type, so numbers
+// and booleans stay numbers and booleans.
+func (c CellChange) writeValue(date func(time.Time) interface{}) interface{} {
+	if t, ok := dateValue(c.value); ok && c.date {
+		return date(t)
+	}
+	return c.value
+}
+
+// cellValue turns a model value into a spreadsheet cell value; arrays are joined
+// with the layout separator so they convert back to the same array.
+func cellValue(v interface{}, sep string) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = textValue(e)
+		}
+		return strings.Join(parts, sep)
+	case map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+	return v
+}
+
+// ExportWorkbookToXlsx writes the sheets of jsonPath to outPath. If templatePath names an
+// existing workbook, its sheets, header, type and comment rows and formatting are kept and only
+// the data cells are rewritten; otherwise a new workbook following layout is created.
+// Rows are matched to the template's by the primary key from keys.
+func ExportWorkbookToXlsx(jsonPath, templatePath, outPath string, layout *SheetLayout, keys *KeyColumns) (*ExportReport, error) {
+	if layout == nil {
+		layout = DefaultSheetLayout()
+	}
+	wb, err := ReadJSONWorkbook(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *excelize.File
+	fresh := true
+	if templatePath != "" {
+		if _, statErr := os.Stat(templatePath); statErr == nil {
+			if f, err = excelize.OpenFile(templatePath); err != nil {
+				return nil, err
+			}
+			fresh = false
+		}
+	}
+	if f == nil {
+		f = excelize.NewFile()
+	}
+	defer f.Close()
+
+	report := &ExportReport{Target: outPath}
+	existing := make(map[string]bool)
+	for _, name := range f.GetSheetList() {
+		existing[name] = true
+	}
+
+	for i, sheet := range wb.Sheets {
+		var current *sheetGrid
+		switch {
+		case fresh && i == 0:
+			// Reuse the default sheet of a new workbook so no empty sheet is left behind.
+			if err := f.SetSheetName(f.GetSheetList()[0], sheet.Name); err != nil {
+				return nil, fmt.Errorf("sheet %s: %w", sheet.Name, err)
+			}
+			report.NewSheets = append(report.NewSheets, sheet.Name)
+		case existing[sheet.Name]:
+			if current, err = readXlsxGrid(f, sheet.Name); err != nil {
+				return nil, fmt.Errorf("sheet %s: %w", sheet.Name, err)
+			}
+		default:
+			if _, err := f.NewSheet(sheet.Name); err != nil {
+				return nil, fmt.Errorf("sheet %s: %w", sheet.Name, err)
+			}
+			report.NewSheets = append(report.NewSheets, sheet.Name)
+		}
+
+		key, _ := keys.Find(wb.Name, sheet.Name, sheet.Columns)
+		changes, err := planSheetUpdate(sheet, current, layout, key)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %s: %w", sheet.Name, err)
+		}
+		for _, c := range changes {
+			value := c.writeValue(func(t time.Time) interface{} { return t })
+			if err := f.SetCellValue(sheet.Name, c.Cell, value); err != nil {
+				return nil, fmt.Errorf("sheet %s, cell %s: %w", sheet.Name, c.Cell, err)
+			}
+		}
+		report.Changes = append(report.Changes, changes...)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.SaveAs(outPath); err != nil {
+		return nil, err
+	}
+	report.Written = true
+	return report, nil
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ExportWorkbookToGoogleSheet writes the sheets of jsonPath into an existing spreadsheet.
+// Only cells whose value differs are sent, and tabs missing from the spreadsheet are added.
+// Rows are matched to the existing ones by the primary key from keys.
+// With dryRun nothing is written and the report lists the pending changes.
+func ExportWorkbookToGoogleSheet(ctx context.Context, spreadsheetID, jsonPath string, auth *GoogleAuthOptions, layout *SheetLayout, keys *KeyColumns, dryRun bool) (*ExportReport, error) {
+	if layout == nil {
+		layout = DefaultSheetLayout()
+	}
+	wb, err := ReadJSONWorkbook(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := newSheetsService(ctx, auth, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := srv.Spreadsheets.Get(spreadsheetID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve spreadsheet: %v", err)
+	}
+	existing := make(map[string]bool)
+	for _, s := range resp.Sheets {
+		existing[s.Properties.Title] = true
+	}
+
+	report := &ExportReport{Target: fmt.Sprintf("%s (%s)", resp.Properties.Title, spreadsheetID)}
+	for _, sheet := range wb.Sheets {
+		var current *sheetGrid
+		if existing[sheet.Name] {
+			valResp, err := srv.Spreadsheets.Values.Get(spreadsheetID, sheetRange(sheet.Name, "")).
+				ValueRenderOption("UNFORMATTED_VALUE").Do()
+			if err != nil {
+				return nil, fmt.Errorf("unable to read sheet %s: %v", sheet.Name, err)
+			}
+			current = &sheetGrid{text: make([][]string, len(valResp.Values)), values: make([][]interface{}, len(valResp.Values))}
+			for i, row := range valResp.Values {
+				current.text[i] = make([]string, len(row))
+				current.values[i] = make([]interface{}, len(row))
+				for j, cell := range row {
+					if f, ok := cell.(float64); ok {
+						cell = numberValue(f)
+					}
+					if cell != "" {
+						current.values[i][j] = cell
+					}
+					current.text[i][j] = textValue(cell)
+				}
+			}
+		} else {
+			report.NewSheets = append(report.NewSheets, sheet.Name)
+		}
+
+		key, _ := keys.Find(wb.Name, sheet.Name, sheet.Columns)
+		changes, err := planSheetUpdate(sheet, current, layout, key)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %s: %w", sheet.Name, err)
+		}
+		report.Changes = append(report.Changes, changes...)
+	}
+	if dryRun || (len(report.Changes) == 0 && len(report.NewSheets) == 0) {
+		return report, nil
+	}
+
+	if len(report.NewSheets) > 0 {
+		var requests []*sheets.Request
+		for _, name := range report.NewSheets {
+			requests = append(requests, &sheets.Request{
+				AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: name}},
+			})
+		}
+		if _, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}).Do(); err != nil {
+			return nil, fmt.Errorf("unable to add sheets: %v", err)
+		}
+	}
+
+	data := make([]*sheets.ValueRange, 0, len(report.Changes))
+	for _, c := range report.Changes {
+		// Dates are written as serial numbers so date-formatted cells keep showing dates.
+		value := c.writeValue(func(t time.Time) interface{} { return excelSerial(t) })
+		if value == nil {
+			// An empty string clears the cell; nil would leave it untouched.
+			value = ""
+		}
+		data = append(data, &sheets.ValueRange{
+			Range:  sheetRange(c.Sheet, c.Cell),
+			Values: [][]interface{}{{value}},
+		})
+	}
+	if len(data) > 0 {
+		req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: data}
+		if _, err := srv.Spreadsheets.Values.BatchUpdate(spreadsheetID, req).Do(); err != nil {
+			return nil, fmt.Errorf("unable to write values: %v", err)
+		}
+	}
+	report.Written = true
+	return report, nil
+}
+
+// sheetRange builds an A1 range such as 'My Sheet'!B3, or the whole sheet if cell is empty.
+func sheetRange(sheet, cell string) string {
+	r := "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+	if cell != "" {
+		r += "!" + cell
+	}
+	return r
+}
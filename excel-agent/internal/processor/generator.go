@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"go/format"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,42 +14,72 @@ import (
 	"github.com/firebase/genkit/go/genkit"
 )
 
-func GenerateStructs(ctx context.Context, g *genkit.Genkit, fileName, jsonDir, dataDir string) (string, error) {
-	if fileName == "" {
-		// Find the first JSON file in json directory
-		files, err := os.ReadDir(jsonDir)
-		if err != nil || len(files) == 0 {
-			return "", fmt.Errorf("no JSON files found in %s", jsonDir)
-		}
-		for _, f := range files {
-			if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
-				fileName = f.Name()
-				break
-			}
+// Generation statuses reported in GenerateResult.
+const (
+	GenerateStatusOK     = "ok"
+	GenerateStatusFailed = "failed"
+)
+
+// GenerateResult reports how AI struct generation went for one file.
+type GenerateResult struct {
+	File     string            `json:"file"`
+	Status   string            `json:"status"`
+	Attempts []GenerateAttempt `json:"attempts"`
+}
+
+// GenerateAttempt is one model round trip and the compiler diagnostics it produced.
+type GenerateAttempt struct {
+	Attempt     int      `json:"attempt"`
+	Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+func (r *GenerateResult) String() string {
+	if r.Status == GenerateStatusOK {
+		return fmt.Sprintf("Successfully generated %s using AI (attempts: %d)", r.File, len(r.Attempts))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Failed to generate compilable %s after %d attempts", r.File, len(r.Attempts))
+	if n := len(r.Attempts); n > 0 {
+		for _, d := range r.Attempts[n-1].Diagnostics {
+			b.WriteString("\n  " + d)
 		}
 	}
+	return b.String()
+}
 
-	if fileName == "" {
-		return "", fmt.Errorf("could not find a JSON file to process")
+// GenerateStructs asks the model to write Go structs from a sample row of each sheet.
+// The reply is parsed and type-checked against the other files in dataDir; compiler
+// diagnostics are fed back to the model for up to maxAttempts attempts in total.
+// The file is only written once it compiles. See GenerateStructsFromSchema for the
+// deterministic generator.
+func GenerateStructs(ctx context.Context, g *genkit.Genkit, fileName, jsonDir, dataDir string, maxAttempts int) (*GenerateResult, error) {
+	fileName, err := resolveJSONFile(fileName, jsonDir)
+	if err != nil {
+		return nil, err
 	}
 
 	jsonPath := filepath.Join(jsonDir, fileName)
-	data, err := os.ReadFile(jsonPath)
+	wb, err := ReadJSONWorkbook(jsonPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read JSON file: %v", err)
-	}
-
-	var jsonRaw map[string]interface{}
-	if err := json.Unmarshal(data, &jsonRaw); err != nil {
-		return "", fmt.Errorf("failed to parse JSON: %v", err)
+		return nil, fmt.Errorf("failed to read JSON file: %v", err)
 	}
 
-	sample := make(map[string]interface{})
-	for sheetName, content := range jsonRaw {
-		rows, ok := content.([]interface{})
-		if ok && len(rows) > 0 {
-			sample[sheetName] = rows[0]
+	// The sample keeps sheet and column order so the model can keep fields in sheet order.
+	// Its row takes each column's first non-empty value, so columns empty in the first row are not lost.
+	sample := &Workbook{Name: wb.Name}
+	for _, s := range wb.Sheets {
+		if len(s.Rows) == 0 {
+			continue
+		}
+		row := make(map[string]interface{}, len(s.Columns))
+		for _, r := range s.Rows {
+			for col, v := range r {
+				if _, ok := row[col]; !ok && !isEmptyValue(v) {
+					row[col] = v
+				}
+			}
 		}
+		sample.Sheets = append(sample.Sheets, &Sheet{Name: s.Name, Columns: s.Columns, Rows: []map[string]interface{}{row}})
 	}
 
 	baseName := filepath.Base(fileName)
@@ -56,7 +88,7 @@ func GenerateStructs(ctx context.Context, g *genkit.Genkit, fileName, jsonDir, d
 	prompt := fmt.Sprintf(`Generate Go structs based on the following JSON sample. 
 The JSON represents a spreadsheet where each top-level key is a sheet name, 
 and its value is an array of objects.
-Use the keys in the sample objects to define the struct fields.
+Use the keys in the sample objects to define the struct fields, in the order they appear.
 Exclude and ignore any sheet names or object keys that contain Korean characters (Hangul).
 Include standard JSON tags.
 Name the individual structs after the sheet names (converted to PascalCase).
@@ -69,23 +101,85 @@ Output ONLY the Go code, starting with 'package data'.
 JSON Sample:
 %s`, baseName, baseName, string(sampleData))
 
-	resp, err := genkit.GenerateText(ctx, g, ai.WithPrompt(prompt))
-	if err != nil {
-		return "", fmt.Errorf("AI generation failed: %v", err)
+	goFileName := strings.TrimSuffix(baseName, filepath.Ext(baseName)) + ".go"
+	result := &GenerateResult{File: goFileName, Status: GenerateStatusFailed}
+	maxAttempts = max(maxAttempts, 1)
+
+	code := ""
+	var diags []string
+	for i := 1; i <= maxAttempts; i++ {
+		attemptPrompt := prompt
+		if i > 1 {
+			attemptPrompt = fmt.Sprintf(`%s
+
+[Previous Code]:
+%s
+
+[Compiler Errors]:
+%s
+
+The previous code does not compile together with the other files of package data.
+Fix every error above. Do not redeclare types that already exist in other files.
+Output ONLY the corrected Go code, starting with 'package data'.`, prompt, code, strings.Join(diags, "\n"))
+		}
+
+		resp, err := genkit.GenerateText(ctx, g, ai.WithPrompt(attemptPrompt))
+		if err != nil {
+			return result, fmt.Errorf("AI generation failed: %v", err)
+		}
+
+		code = extractGoCode(resp)
+		diags = checkDataFile(dataDir, goFileName, []byte(code))
+		result.Attempts = append(result.Attempts, GenerateAttempt{Attempt: i, Diagnostics: diags})
+		if len(diags) == 0 {
+			break
+		}
+		log.Printf("Generated %s does not compile (attempt %d/%d): %d errors", goFileName, i, maxAttempts, len(diags))
 	}
 
-	// Clean up markdown block if present
-	code := resp
-	code = strings.TrimPrefix(code, "```go")
-	code = strings.TrimPrefix(code, "```")
-	code = strings.TrimSuffix(code, "```")
-	code = strings.TrimSpace(code)
-	code = strings.TrimSuffix(code, "```")
+	if len(diags) > 0 {
+		return result, nil
+	}
 
-	goFileName := strings.TrimSuffix(baseName, filepath.Ext(baseName)) + ".go"
+	if formatted, err := format.Source([]byte(code)); err == nil {
+		code = string(formatted)
+	}
 	if err := os.WriteFile(filepath.Join(dataDir, goFileName), []byte(code), 0644); err != nil {
-		return "", fmt.Errorf("failed to write %s: %v", goFileName, err)
+		return result, fmt.Errorf("failed to write %s: %v", goFileName, err)
 	}
 
-	return "Successfully generated " + goFileName + " using AI", nil
+	result.Status = GenerateStatusOK
+	return result, nil
+}
+
+// extractGoCode pulls Go source out of a model reply: the first fenced code block
+// if there is one, without any prose in front of the package clause.
+func extractGoCode(resp string) string {
+	code := resp
+	if start := strings.Index(code, "```"); start >= 0 {
+		code = code[start+3:]
+		// Drop the info string (e.g. "go") on the opening fence line.
+		if nl := strings.IndexByte(code, '\n'); nl >= 0 {
+			code = code[nl+1:]
+		}
+		if end := strings.Index(code, "```"); end >= 0 {
+			code = code[:end]
+		}
+	}
+
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "package ") {
+			// Keep leading comments, drop anything else before the package clause.
+			var head []string
+			for _, l := range lines[:i] {
+				if t := strings.TrimSpace(l); t == "" || strings.HasPrefix(t, "//") {
+					head = append(head, l)
+				}
+			}
+			code = strings.Join(append(head, lines[i:]...), "\n")
+			break
+		}
+	}
+	return strings.TrimSpace(code) + "\n"
 }
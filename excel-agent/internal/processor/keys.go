@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyColumns names the primary-key column of each sheet.
+type KeyColumns struct {
+	// Default is used for sheets without an explicit entry.
+	Default string
+	// Sheets maps "File:Sheet" or "Sheet" to a column name.
+	Sheets map[string]string
+}
+
+// ParseKeyColumns builds KeyColumns from a default column and a spec such as
+// "Item:ItemList=ItemCode,Shop=ProductID".
+func ParseKeyColumns(defaultKey, spec string) (*KeyColumns, error) {
+	k := &KeyColumns{Default: defaultKey, Sheets: make(map[string]string)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		scope, col, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(scope) == "" || strings.TrimSpace(col) == "" {
+			return nil, fmt.Errorf("invalid primary key entry %q, want Sheet=Column or File:Sheet=Column", entry)
+		}
+		k.Sheets[strings.TrimSpace(scope)] = strings.TrimSpace(col)
+	}
+	return k, nil
+}
+
+// Column returns the configured primary-key column of a sheet.
+func (k *KeyColumns) Column(file, sheet string) string {
+	if k == nil {
+		return ""
+	}
+	if col, ok := k.Sheets[file+":"+sheet]; ok {
+		return col
+	}
+	if col, ok := k.Sheets[sheet]; ok {
+		return col
+	}
+	return k.Default
+}
+
+// Find returns the column among columns that matches the sheet's primary key,
+// comparing case-insensitively.
+func (k *KeyColumns) Find(file, sheet string, columns []string) (string, bool) {
+	want := k.Column(file, sheet)
+	if want == "" {
+		return "", false
+	}
+	for _, c := range columns {
+		if c == want {
+			return c, true
+		}
+	}
+	for _, c := range columns {
+		if strings.EqualFold(c, want) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// IndexColumns lists secondary-index columns per "File:Sheet", "Sheet" or "*" scope.
+type IndexColumns map[string][]string
+
+// ParseIndexColumns parses a spec such as "Grade,Item:ItemList=Type,Character=Class".
+// Entries without a scope apply to every sheet.
+func ParseIndexColumns(spec string) (IndexColumns, error) {
+	ix := make(IndexColumns)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		scope, col, ok := strings.Cut(entry, "=")
+		if !ok {
+			scope, col = "*", entry
+		}
+		scope, col = strings.TrimSpace(scope), strings.TrimSpace(col)
+		if scope == "" || col == "" {
+			return nil, fmt.Errorf("invalid index entry %q, want Column, Sheet=Column or File:Sheet=Column", entry)
+		}
+		ix[scope] = append(ix[scope], col)
+	}
+	return ix, nil
+}
+
+// Find returns the indexed columns of a sheet that exist among columns, comparing case-insensitively.
+func (ix IndexColumns) Find(file, sheet string, columns []string) []string {
+	var found []string
+	seen := make(map[string]bool)
+	for _, scope := range []string{file + ":" + sheet, sheet, "*"} {
+		for _, want := range ix[scope] {
+			for _, c := range columns {
+				if strings.EqualFold(c, want) && !seen[c] {
+					seen[c] = true
+					found = append(found, c)
+					break
+				}
+			}
+		}
+	}
+	return found
+}
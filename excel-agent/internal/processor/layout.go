@@ -0,0 +1,220 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SheetLayout describes where the descriptor rows of a game-data sheet live.
+// Row numbers are 1-based like in the spreadsheet UI; 0 means the row is absent.
+//
+//	{
+//	  "header_row": 1,
+//	  "type_row": 2,
+//	  "comment_rows": [3],
+//	  "ignore_prefixes": ["#", "~"]
+//	}
+type SheetLayout struct {
+	// HeaderRow holds the field names.
+	HeaderRow int `json:"header_row"`
+	// TypeRow holds column types such as int, string or int[]; they drive value coercion.
+	TypeRow int `json:"type_row,omitempty"`
+	// CommentRows hold designer notes and are never written as data.
+	CommentRows []int `json:"comment_rows,omitempty"`
+	// DataStartRow is the first data row. Defaults to the row after the last descriptor row.
+	DataStartRow int `json:"data_start_row,omitempty"`
+	// IgnorePrefixes drops columns whose header starts with one of the prefixes.
+	IgnorePrefixes []string `json:"ignore_prefixes,omitempty"`
+	// ArraySeparator splits cells of array types (e.g. "1,2,3" for int[]). Defaults to ",".
+	ArraySeparator string `json:"array_separator,omitempty"`
+}
+
+// DefaultSheetLayout is the single header row layout used when no layout file is configured.
+func DefaultSheetLayout() *SheetLayout {
+	return &SheetLayout{HeaderRow: 1}
+}
+
+// LoadSheetLayout reads a JSON layout descriptor.
+func LoadSheetLayout(path string) (*SheetLayout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet layout: %w", err)
+	}
+	layout := DefaultSheetLayout()
+	if err := json.Unmarshal(data, layout); err != nil {
+		return nil, fmt.Errorf("failed to parse sheet layout %s: %w", path, err)
+	}
+	if err := layout.validate(); err != nil {
+		return nil, fmt.Errorf("sheet layout %s: %w", path, err)
+	}
+	return layout, nil
+}
+
+func (l *SheetLayout) validate() error {
+	if l.HeaderRow < 1 {
+		return fmt.Errorf("header_row must be 1 or greater")
+	}
+	if l.TypeRow < 0 || l.DataStartRow < 0 {
+		return fmt.Errorf("row numbers must not be negative")
+	}
+	if l.TypeRow == l.HeaderRow {
+		return fmt.Errorf("type_row must differ from header_row")
+	}
+	for _, r := range l.CommentRows {
+		if r < 1 || r == l.HeaderRow || r == l.TypeRow {
+			return fmt.Errorf("invalid comment row %d", r)
+		}
+	}
+	if l.DataStartRow != 0 && l.DataStartRow <= l.HeaderRow {
+		return fmt.Errorf("data_start_row must come after header_row")
+	}
+	return nil
+}
+
+// dataStart returns the zero-based index of the first data row.
+func (l *SheetLayout) dataStart() int {
+	if l.DataStartRow > 0 {
+		return l.DataStartRow - 1
+	}
+	last := max(l.HeaderRow, l.TypeRow)
+	for _, r := range l.CommentRows {
+		last = max(last, r)
+	}
+	return last
+}
+
+// isDescriptorRow reports whether the zero-based row holds headers, types or comments.
+func (l *SheetLayout) isDescriptorRow(row int) bool {
+	n := row + 1
+	if n == l.HeaderRow || n == l.TypeRow {
+		return true
+	}
+	for _, r := range l.CommentRows {
+		if n == r {
+			return true
+		}
+	}
+	return false
+}
+
+// sheetRow returns the 1-based spreadsheet row of the i-th converted data row.
+func (l *SheetLayout) sheetRow(i int) int {
+	for r := l.dataStart(); ; r++ {
+		if l.isDescriptorRow(r) {
+			continue
+		}
+		if i == 0 {
+			return r + 1
+		}
+		i--
+	}
+}
+
+// ignoreColumn reports whether a header marks a column that must not be exported.
+func (l *SheetLayout) ignoreColumn(header string) bool {
+	if strings.TrimSpace(header) == "" {
+		return true
+	}
+	for _, p := range l.IgnorePrefixes {
+		if p != "" && strings.HasPrefix(header, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *SheetLayout) separator() string {
+	if l.ArraySeparator != "" {
+		return l.ArraySeparator
+	}
+	return ","
+}
+
+// sheetColumn is an exported column of a sheet together with its declared type.
+type sheetColumn struct {
+	index int
+	name  string
+	typ   string
+}
+
+// columns resolves the exported columns from the header and type rows.
+func (l *SheetLayout) columns(rows [][]string) []sheetColumn {
+	headers := cellRow(rows, l.HeaderRow-1)
+	var types []string
+	if l.TypeRow > 0 {
+		types = cellRow(rows, l.TypeRow-1)
+	}
+
+	var cols []sheetColumn
+	for i, h := range headers {
+		if l.ignoreColumn(h) {
+			continue
+		}
+		col := sheetColumn{index: i, name: strings.TrimSpace(h)}
+		if i < len(types) {
+			col.typ = strings.TrimSpace(types[i])
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func cellRow(rows [][]string, i int) []string {
+	if i < 0 || i >= len(rows) {
+		return nil
+	}
+	return rows[i]
+}
+
+// normalizeColumnType maps the type names used in sheets to the override type set.
+// It returns the element type and whether the column is an array.
+func normalizeColumnType(typ string) (string, bool) {
+	t := strings.ToLower(strings.TrimSpace(typ))
+	isArray := strings.HasSuffix(t, "[]")
+	t = strings.TrimSuffix(t, "[]")
+	switch t {
+	case "int", "int8", "int16", "int32", "int64", "long", "short", "byte", "uint", "uint32", "uint64":
+		return TypeInt, isArray
+	case "float", "float32", "float64", "double", "number":
+		return TypeFloat, isArray
+	case "bool", "boolean":
+		return TypeBool, isArray
+	case "string", "str", "text":
+		return TypeString, isArray
+	case "date", "datetime", "time":
+		return TypeDate, isArray
+	}
+	return "", false
+}
+
+// coerceColumnValue applies a type-row type to a cell value. Unknown types leave the value untouched.
+// Like coerceValue, it reports elements it had to keep unconverted; the first such error is returned.
+func (l *SheetLayout) coerceColumnValue(value interface{}, formatted, typ string, date1904 bool) (interface{}, error) {
+	elem, isArray := normalizeColumnType(typ)
+	if elem == "" {
+		return value, nil
+	}
+	if !isArray {
+		return coerceValue(value, formatted, elem, date1904)
+	}
+	if strings.TrimSpace(formatted) == "" {
+		return []interface{}{}, nil
+	}
+	parts := strings.Split(formatted, l.separator())
+	arr := make([]interface{}, 0, len(parts))
+	var firstErr error
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		v, err := coerceValue(p, p, elem, date1904)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		arr = append(arr, v)
+	}
+	return arr, firstErr
+}
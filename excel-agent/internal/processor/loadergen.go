@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// loaderSheet is a sheet as seen by the loader: the final Go names and its key column.
+type loaderSheet struct {
+	Sheet     string
+	Struct    string
+	Field     string // field of the AllSheets container
+	KeyField  string // "" if the sheet has no primary key
+	KeyType   string
+	KeyColumn string
+}
+
+// zero is the Go literal of an unset key.
+func (ls loaderSheet) zero() string {
+	if ls.KeyType == "string" {
+		return `""`
+	}
+	return "0"
+}
+
+// renderLoader produces the companion loader (Load<File>, per-sheet maps and
+// Get<Sheet>ByID helpers) and its test for the structs in code.
+// Names are read back from code so AI-enhanced field names are honoured.
+func renderLoader(s *goSchema, code []byte, keys *KeyColumns, jsonRelPath string) (loader, test []byte, err error) {
+	tags, err := fieldNamesByTag(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileName := strings.TrimSuffix(s.Source, filepath.Ext(s.Source))
+	prefix := strings.TrimSuffix(s.Container, "AllSheets")
+	tables := prefix + "Tables"
+
+	var sheets []loaderSheet
+	for _, st := range s.Structs {
+		ls := loaderSheet{Sheet: st.Sheet, Struct: st.Name, Field: tags[s.Container][st.Sheet]}
+		if ls.Field == "" {
+			return nil, nil, fmt.Errorf("container field for sheet %s not found", st.Sheet)
+		}
+		columns := make([]string, 0, len(st.Fields))
+		types := make(map[string]string)
+		for _, f := range st.Fields {
+			columns = append(columns, f.JSONKey)
+			types[f.JSONKey] = f.Type
+		}
+		if col, ok := keys.Find(fileName, st.Sheet, columns); ok {
+			if typ := types[col]; typ == "int64" || typ == "string" {
+				ls.KeyColumn, ls.KeyType, ls.KeyField = col, typ, tags[st.Name][col]
+			}
+		}
+		sheets = append(sheets, ls)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by excel-agent from %s. DO NOT EDIT.\n\n", s.Source)
+	b.WriteString("package data\n\n")
+	b.WriteString("import (\n\t\"encoding/json\"\n\t\"fmt\"\n\t\"os\"\n)\n\n")
+
+	fmt.Fprintf(&b, "// %s is %s with every sheet indexed by its primary key. Rows without a key are not indexed.\n", tables, s.Source)
+	fmt.Fprintf(&b, "type %s struct {\n\t%s\n\n", tables, s.Container)
+	for _, ls := range sheets {
+		if ls.KeyField != "" {
+			fmt.Fprintf(&b, "\t// %sByID indexes the %s sheet by %s.\n", ls.Field, ls.Sheet, ls.KeyColumn)
+			fmt.Fprintf(&b, "\t%sByID map[%s]*%s\n", ls.Field, ls.KeyType, ls.Struct)
+		}
+	}
+	b.WriteString("}\n\n")
+
+	fmt.Fprintf(&b, "// Load%s reads %s from path and builds the primary-key indexes.\n", prefix, s.Source)
+	fmt.Fprintf(&b, "func Load%s(path string) (*%s, error) {\n", prefix, tables)
+	b.WriteString("\tdata, err := os.ReadFile(path)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(&b, "\tt := &%s{}\n", tables)
+	fmt.Fprintf(&b, "\tif err := json.Unmarshal(data, &t.%s); err != nil {\n", s.Container)
+	b.WriteString("\t\treturn nil, fmt.Errorf(\"failed to parse %s: %w\", path, err)\n\t}\n")
+	for _, ls := range sheets {
+		if ls.KeyField == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\tt.%sByID = make(map[%s]*%s, len(t.%s))\n", ls.Field, ls.KeyType, ls.Struct, ls.Field)
+		fmt.Fprintf(&b, "\tfor i := range t.%s {\n", ls.Field)
+		fmt.Fprintf(&b, "\t\trow := &t.%s[i]\n", ls.Field)
+		// Blank spreadsheet rows come through as rows without a key; they are not indexed.
+		fmt.Fprintf(&b, "\t\tif row.%s == %s {\n\t\t\tcontinue\n\t\t}\n", ls.KeyField, ls.zero())
+		fmt.Fprintf(&b, "\t\tif _, dup := t.%sByID[row.%s]; dup {\n", ls.Field, ls.KeyField)
+		fmt.Fprintf(&b, "\t\t\treturn nil, fmt.Errorf(\"%%s: duplicate %s %%v in sheet %s\", path, row.%s)\n", ls.KeyColumn, ls.Sheet, ls.KeyField)
+		b.WriteString("\t\t}\n")
+		fmt.Fprintf(&b, "\t\tt.%sByID[row.%s] = row\n\t}\n", ls.Field, ls.KeyField)
+	}
+	b.WriteString("\treturn t, nil\n}\n")
+
+	for _, ls := range sheets {
+		if ls.KeyField == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n// Get%sByID returns the %s row whose %s is id.\n", ls.Field, ls.Sheet, ls.KeyColumn)
+		fmt.Fprintf(&b, "func (t *%s) Get%sByID(id %s) (*%s, bool) {\n", tables, ls.Field, ls.KeyType, ls.Struct)
+		fmt.Fprintf(&b, "\trow, ok := t.%sByID[id]\n\treturn row, ok\n}\n", ls.Field)
+	}
+
+	if loader, err = formatGoSource(fileName+"_loader.go", b.Bytes()); err != nil {
+		return nil, nil, err
+	}
+
+	b.Reset()
+	fmt.Fprintf(&b, "// Code generated by excel-agent from %s. DO NOT EDIT.\n\n", s.Source)
+	b.WriteString("package data\n\nimport \"testing\"\n\n")
+	fmt.Fprintf(&b, "func TestLoad%s(t *testing.T) {\n", prefix)
+	fmt.Fprintf(&b, "\ttables, err := Load%s(%s)\n", prefix, strconv.Quote(filepath.ToSlash(jsonRelPath)))
+	b.WriteString("\tif err != nil {\n\t\tt.Fatal(err)\n\t}\n")
+	for _, ls := range sheets {
+		if ls.KeyField == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\tfor i := range tables.%s {\n", ls.Field)
+		fmt.Fprintf(&b, "\t\trow := &tables.%s[i]\n", ls.Field)
+		fmt.Fprintf(&b, "\t\tif row.%s == %s {\n\t\t\tcontinue\n\t\t}\n", ls.KeyField, ls.zero())
+		fmt.Fprintf(&b, "\t\tif got, ok := tables.Get%sByID(row.%s); !ok || got != row {\n", ls.Field, ls.KeyField)
+		fmt.Fprintf(&b, "\t\t\tt.Errorf(\"Get%sByID(%%v) did not return row %%d\", row.%s, i)\n", ls.Field, ls.KeyField)
+		b.WriteString("\t\t}\n\t}\n")
+	}
+	b.WriteString("}\n")
+
+	if test, err = formatGoSource(fileName+"_loader_test.go", b.Bytes()); err != nil {
+		return nil, nil, err
+	}
+	return loader, test, nil
+}
+
+// fieldNamesByTag maps struct type -> JSON tag name -> Go field name for every struct in src.
+func fieldNamesByTag(src []byte) (map[string]map[string]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		ts, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		fields := make(map[string]string)
+		for _, f := range st.Fields.List {
+			if f.Tag == nil || len(f.Names) != 1 {
+				continue
+			}
+			tag, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				continue
+			}
+			name, _, _ := strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+			if name != "" {
+				fields[name] = f.Names[0].Name
+			}
+		}
+		out[ts.Name.Name] = fields
+		return false
+	})
+	return out, nil
+}
@@ -0,0 +1,654 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/xuri/excelize/v2"
+)
+
+// LocalizeHashSuffix names the column holding the hash of the source text a translation was
+// made from, e.g. "EN_SrcHash" next to "EN". Sheets without it keep the hashes in a sidecar file
+// next to the review file instead.
+const LocalizeHashSuffix = "_SrcHash"
+
+// LocalizeSidecarSuffix replaces the extension of the review file to name its hash sidecar,
+// e.g. review.srchash.json next to review.xlsx.
+const LocalizeSidecarSuffix = ".srchash.json"
+
+// Statuses of a LocalizeItem.
+const (
+	LocalizeMissing = "missing" // the target cell is empty
+	LocalizeStale   = "stale"   // the source text changed since the translation was made
+	LocalizeReview  = "review"  // an existing translation breaks a placeholder or the length limit
+)
+
+// reviewSheetName is the sheet of the xlsx review file.
+const reviewSheetName = "Review"
+
+var (
+	// placeholderPattern matches the tokens a translation must keep: {0}, {name}, printf verbs
+	// such as %s or %1$d, and rich-text tags such as <color=#ff0000>, </color> or <b>.
+	// The space flag is left out, so a percent sign before a word ("5% chance") is not a verb.
+	placeholderPattern = regexp.MustCompile(`\{\d+\}|\{[A-Za-z_][A-Za-z0-9_]*\}|%(?:\d+\$)?[-+#0]*\d*(?:\.\d+)?[sdfiuxXc]|</?[A-Za-z][^<>]*>`)
+	tagPattern         = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9_-]*)`)
+)
+
+// LocalizeOptions selects the sheets to localize and how their columns are named.
+type LocalizeOptions struct {
+	// Sheets are "File" or "File:Sheet" entries, e.g. "LocalSeet,Dialogue".
+	Sheets []string
+	// Source is the source-language column, Targets the target-language columns.
+	Source  string
+	Targets []string
+	// Glossary is the 'File:Sheet' key of a sheet with one column per language.
+	Glossary string
+	// MaxLengths limits translations per "File:Sheet", "Sheet" or "*" scope, in visible characters.
+	MaxLengths map[string]int
+	// Layout maps converted rows back to spreadsheet row numbers.
+	Layout *SheetLayout
+}
+
+// LoadLocalizeOptions builds LocalizeOptions from comma-separated sheets and target columns, and a
+// max-length spec such as "120,LocalSeet:UI=30" in the REDIS_INDEXES format.
+func LoadLocalizeOptions(sheets, source, targets, glossary, maxLengths, layoutFile string) (*LocalizeOptions, error) {
+	opts := &LocalizeOptions{
+		Sheets:     splitList(sheets),
+		Source:     strings.TrimSpace(source),
+		Targets:    splitList(targets),
+		Glossary:   strings.TrimSpace(glossary),
+		MaxLengths: make(map[string]int),
+		Layout:     DefaultSheetLayout(),
+	}
+	if opts.Source == "" {
+		return nil, fmt.Errorf("source-language column is required")
+	}
+	limits, err := ParseIndexColumns(maxLengths)
+	if err != nil {
+		return nil, err
+	}
+	for scope, values := range limits {
+		n, err := strconv.Atoi(values[len(values)-1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid max length %q for %s", values[len(values)-1], scope)
+		}
+		opts.MaxLengths[scope] = n
+	}
+	if layoutFile != "" {
+		if opts.Layout, err = LoadSheetLayout(layoutFile); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// maxLength returns the limit of a sheet, or 0 if it has none.
+func (o *LocalizeOptions) maxLength(file, sheet string) int {
+	for _, scope := range []string{file + ":" + sheet, sheet, "*"} {
+		if n, ok := o.MaxLengths[scope]; ok {
+			return n
+		}
+	}
+	return 0
+}
+
+// includesSheet reports whether the sheet matches one of the entries, comparing case-insensitively.
+func includesSheet(entries []string, s *CatalogSheet) bool {
+	return slices.ContainsFunc(entries, func(e string) bool {
+		return strings.EqualFold(e, s.File) || strings.EqualFold(e, s.Key)
+	})
+}
+
+// LocalizeInput is the input of localizeFlow.
+type LocalizeInput struct {
+	Sheet     string   `json:"sheet,omitempty" description:"Only localize this 'File' or 'File:Sheet'"`
+	Languages []string `json:"languages,omitempty" description:"Target-language columns (default: LOCALIZE_TARGETS)"`
+	Output    string   `json:"output,omitempty" description:"Review file to write, .json or .xlsx"`
+	DryRun    bool     `json:"dryRun,omitempty" description:"Only list the cells that need a translation"`
+}
+
+// LocalizeItem is one target cell that needs a translation or a review. Issues lists the
+// placeholders, length or glossary terms the translation got wrong.
+type LocalizeItem struct {
+	Key         string   `json:"key"`
+	ID          string   `json:"id"`
+	Row         int      `json:"row"`
+	Language    string   `json:"language"`
+	Status      string   `json:"status"`
+	Source      string   `json:"source"`
+	SourceHash  string   `json:"source_hash"`
+	Previous    string   `json:"previous,omitempty"`
+	Translation string   `json:"translation,omitempty"`
+	MaxLength   int      `json:"max_length,omitempty"`
+	Issues      []string `json:"issues,omitempty"`
+}
+
+// LocalizeReport is the result of a localization run; it is also the JSON review file.
+type LocalizeReport struct {
+	Source    string         `json:"source"`
+	Sheets    []string       `json:"sheets"`
+	Languages []string       `json:"languages"`
+	Checked   int            `json:"checked"`
+	Items     []LocalizeItem `json:"items"`
+	Output    string         `json:"output,omitempty"`
+	Written   bool           `json:"written"`
+}
+
+func (r *LocalizeReport) String() string {
+	counts := make(map[string]int)
+	flagged := 0
+	for _, it := range r.Items {
+		counts[it.Status]++
+		if len(it.Issues) > 0 {
+			flagged++
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Checked %d cells of %d sheets (%s): %d missing, %d stale, %d to review",
+		r.Checked, len(r.Sheets), strings.Join(r.Languages, ", "), counts[LocalizeMissing], counts[LocalizeStale], counts[LocalizeReview])
+	if r.Written {
+		fmt.Fprintf(&b, "\nReview file written to %s", r.Output)
+	}
+	if flagged > 0 {
+		fmt.Fprintf(&b, "\n%d translations need attention:", flagged)
+	}
+	listed := 0
+	for _, it := range r.Items {
+		if len(it.Issues) == 0 {
+			continue
+		}
+		if listed == maxListedChanges {
+			fmt.Fprintf(&b, "\n  ... and %d more", flagged-maxListedChanges)
+			break
+		}
+		listed++
+		fmt.Fprintf(&b, "\n  %s row %d (%s, %s): %s", it.Key, it.Row, it.ID, it.Language, strings.Join(it.Issues, "; "))
+	}
+	return b.String()
+}
+
+// glossaryTerm is a source term and its required translation.
+type glossaryTerm struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// translationBatch is the structured output of the model for a batch.
+type translationBatch struct {
+	Translations []translatedText `json:"translations"`
+}
+
+type translatedText struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+const localizeSystemPrompt = `You translate game text (UI strings and dialogue) for a localization team.
+- Translate every text in the input and return it with its id. Do not merge, split or skip texts.
+- Keep placeholders exactly as they are: {0}, {name}, %s, %d and rich-text tags such as <color=#ff0000>...</color> or <b>...</b>.
+  You may move them where the target grammar needs them, but never translate, drop or add them.
+- Where a glossary term occurs in a text, use its glossary translation.
+- If a text has a maxLength, the translation must not be longer (tags are not counted). Shorten the wording if needed.
+- Keep the tone of the original; dialogue should sound natural for a game.`
+
+// Localize finds the cells of the configured sheets whose target-language column is empty, or
+// whose source text changed since the translation was made (the hash in the "<Target>_SrcHash"
+// column, or in the sidecar of the review file, differs), and existing translations that break
+// placeholders or the length limit.
+// Missing and stale cells are translated by the model in batches of batchSize with the glossary;
+// translations that break a placeholder or a limit are fed back for up to maxAttempts attempts.
+// The results are written to input.Output for review, and the source hashes to its sidecar; the
+// sheets themselves are not changed.
+func Localize(ctx context.Context, g *genkit.Genkit, loader *CatalogLoader, opts *LocalizeOptions, input *LocalizeInput, batchSize, maxAttempts int) (*LocalizeReport, error) {
+	languages := input.Languages
+	if len(languages) == 0 {
+		languages = opts.Targets
+	}
+	if len(languages) == 0 {
+		return nil, fmt.Errorf("no target languages (set LOCALIZE_TARGETS)")
+	}
+	if !input.DryRun {
+		if ext := strings.ToLower(filepath.Ext(input.Output)); ext != ".json" && ext != ".xlsx" {
+			return nil, fmt.Errorf("review file %q must end in .json or .xlsx", input.Output)
+		}
+	}
+	sidecar, err := readHashSidecar(input.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	glossary, err := readGlossary(ctx, loader, catalog, opts, languages)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LocalizeReport{Source: catalog.Source, Sheets: []string{}, Languages: languages, Items: []LocalizeItem{}}
+	for _, f := range catalog.Files {
+		for _, cs := range f.Sheets {
+			if !includesSheet(opts.Sheets, cs) || (input.Sheet != "" && !includesSheet([]string{input.Sheet}, cs)) {
+				continue
+			}
+			if strings.EqualFold(cs.Key, opts.Glossary) {
+				continue
+			}
+			sheet, err := loader.ReadSheet(ctx, cs.Key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", cs.Key, err)
+			}
+			items, checked, ok := opts.scan(cs, sheet, languages, sidecar)
+			if !ok {
+				log.Printf("Localize: %s has no %s column, skipping", cs.Key, opts.Source)
+				continue
+			}
+			report.Sheets = append(report.Sheets, cs.Key)
+			report.Checked += checked
+			report.Items = append(report.Items, items...)
+		}
+	}
+	if len(report.Sheets) == 0 {
+		return nil, fmt.Errorf("no sheet with a %s column matches %s", opts.Source, strings.Join(opts.Sheets, ", "))
+	}
+	if input.DryRun {
+		return report, nil
+	}
+
+	batchSize = max(batchSize, 1)
+	for _, lang := range languages {
+		var pending []*LocalizeItem
+		for i := range report.Items {
+			if it := &report.Items[i]; it.Language == lang && it.Status != LocalizeReview {
+				pending = append(pending, it)
+			}
+		}
+		for start := 0; start < len(pending); start += batchSize {
+			batch := pending[start:min(start+batchSize, len(pending))]
+			log.Printf("Translating %d texts into %s (%d/%d)...", len(batch), lang, start+len(batch), len(pending))
+			if err := translateBatch(ctx, g, opts.Source, lang, batch, glossary[lang], maxAttempts); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	report.Output, report.Written = input.Output, true
+	if err := report.write(input.Output); err != nil {
+		report.Written = false
+		return report, err
+	}
+	if err := sidecar.write(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// scan lists the cells of sheet that need work. ok is false if the sheet has no source column.
+// Languages without a "<Target>_SrcHash" column are checked for stale cells against sidecar.
+func (o *LocalizeOptions) scan(cs *CatalogSheet, sheet *Sheet, languages []string, sidecar *hashSidecar) (items []LocalizeItem, checked int, ok bool) {
+	source, ok := findColumn(sheet.Columns, o.Source)
+	if !ok {
+		return nil, 0, false
+	}
+	limit := o.maxLength(cs.File, cs.Name)
+	for i, row := range sheet.Rows {
+		text := cellText(row[source])
+		if text == "" {
+			continue
+		}
+		hash := sourceHash(text)
+		for _, lang := range languages {
+			checked++
+			item := LocalizeItem{Key: cs.Key, ID: rowID(row, cs.PrimaryKey, i), Row: o.Layout.sheetRow(i), Language: lang,
+				Source: text, SourceHash: hash, MaxLength: limit}
+			if col, ok := findColumn(sheet.Columns, lang); ok {
+				item.Previous = cellText(row[col])
+			}
+			hashCol, hasHash := findColumn(sheet.Columns, lang+LocalizeHashSuffix)
+			cell := cs.Key + "|" + item.ID + "|" + lang
+			switch {
+			case item.Previous == "":
+				sidecar.forget(cell)
+				item.Status = LocalizeMissing
+			case hasHash && cellText(row[hashCol]) != hash:
+				item.Status = LocalizeStale
+			case !hasHash && sidecar.stale(cell, hash, item.Previous):
+				item.Status = LocalizeStale
+			default:
+				item.Issues = checkTranslation(text, item.Previous, limit, nil)
+				if len(item.Issues) == 0 {
+					continue
+				}
+				item.Status = LocalizeReview
+			}
+			items = append(items, item)
+		}
+	}
+	return items, checked, true
+}
+
+// readGlossary reads the glossary sheet into the terms of each language.
+func readGlossary(ctx context.Context, loader *CatalogLoader, catalog *Catalog, opts *LocalizeOptions, languages []string) (map[string][]glossaryTerm, error) {
+	terms := make(map[string][]glossaryTerm)
+	if opts.Glossary == "" {
+		return terms, nil
+	}
+	cs, ok := catalog.Sheet(opts.Glossary)
+	if !ok {
+		return nil, fmt.Errorf("glossary sheet %s not found", opts.Glossary)
+	}
+	sheet, err := loader.ReadSheet(ctx, cs.Key)
+	if err != nil {
+		return nil, err
+	}
+	source, ok := findColumn(sheet.Columns, opts.Source)
+	if !ok {
+		return nil, fmt.Errorf("glossary %s has no %s column", cs.Key, opts.Source)
+	}
+	for _, lang := range languages {
+		col, ok := findColumn(sheet.Columns, lang)
+		if !ok {
+			continue
+		}
+		for _, row := range sheet.Rows {
+			if src, dst := cellText(row[source]), cellText(row[col]); src != "" && dst != "" {
+				terms[lang] = append(terms[lang], glossaryTerm{Source: src, Target: dst})
+			}
+		}
+	}
+	return terms, nil
+}
+
+// translateBatch translates items into lang, asking again for the ones whose translation has issues.
+func translateBatch(ctx context.Context, g *genkit.Genkit, source, lang string, items []*LocalizeItem, glossary []glossaryTerm, maxAttempts int) error {
+	type request struct {
+		ID        string `json:"id"`
+		Text      string `json:"text"`
+		MaxLength int    `json:"maxLength,omitempty"`
+	}
+	pending := make(map[string]*LocalizeItem, len(items))
+	var texts []request
+	var used []glossaryTerm
+	for i, it := range items {
+		id := strconv.Itoa(i + 1)
+		pending[id] = it
+		texts = append(texts, request{ID: id, Text: it.Source, MaxLength: it.MaxLength})
+	}
+	for _, t := range glossary {
+		if slices.ContainsFunc(items, func(it *LocalizeItem) bool { return containsFold(it.Source, t.Source) }) {
+			used = append(used, t)
+		}
+	}
+	prompt := fmt.Sprintf("Translate from %s to %s.\n\n", source, lang)
+	if len(used) > 0 {
+		prompt += fmt.Sprintf("Glossary:\n%s\n\n", marshalText(used, ""))
+	}
+	prompt += fmt.Sprintf("Texts:\n%s", marshalText(texts, ""))
+
+	maxAttempts = max(maxAttempts, 1)
+	feedback := ""
+	for i := 1; i <= maxAttempts && len(pending) > 0; i++ {
+		out, _, err := genkit.GenerateData[translationBatch](ctx, g,
+			ai.WithSystem(localizeSystemPrompt),
+			// The texts carry printf verbs of their own, so the prompt must not be a format string.
+			ai.WithPrompt("%s", prompt+feedback),
+		)
+		if err != nil {
+			return fmt.Errorf("AI translation failed: %v", err)
+		}
+		got := make(map[string]string, len(out.Translations))
+		for _, t := range out.Translations {
+			got[t.ID] = strings.TrimSpace(t.Text)
+		}
+
+		var b strings.Builder
+		for _, id := range slices.Sorted(maps.Keys(pending)) {
+			it := pending[id]
+			if text, ok := got[id]; ok && text != "" {
+				it.Translation = text
+				it.Issues = checkTranslation(it.Source, text, it.MaxLength, used)
+			} else if it.Translation == "" {
+				it.Issues = []string{"no translation returned"}
+			}
+			if len(it.Issues) == 0 {
+				delete(pending, id)
+				continue
+			}
+			fmt.Fprintf(&b, "- id %s: %q: %s\n", id, it.Translation, strings.Join(it.Issues, "; "))
+		}
+		if len(pending) > 0 {
+			log.Printf("Translations into %s rejected (attempt %d/%d): %d", lang, i, maxAttempts, len(pending))
+			feedback = fmt.Sprintf("\n\n[Previous Translations]:\n%s\nTranslate these ids again and fix the errors.", b.String())
+		}
+	}
+	return nil
+}
+
+// checkTranslation compares a translation with its source text: placeholders and tags must be
+// kept, tags must stay in order, the visible length must fit limit and glossary terms must be used.
+func checkTranslation(source, translation string, limit int, glossary []glossaryTerm) []string {
+	var issues []string
+	want, have := countTokens(source), countTokens(translation)
+	for _, tok := range sortedTokens(want) {
+		if n := want[tok] - have[tok]; n > 0 {
+			issues = append(issues, fmt.Sprintf("placeholder %s missing", tok))
+		}
+	}
+	for _, tok := range sortedTokens(have) {
+		if n := have[tok] - want[tok]; n > 0 {
+			issues = append(issues, fmt.Sprintf("unexpected placeholder %s", tok))
+		}
+	}
+	if err := checkTagOrder(translation); err != "" {
+		issues = append(issues, err)
+	}
+	if n := visibleLength(translation); limit > 0 && n > limit {
+		issues = append(issues, fmt.Sprintf("%d characters, limit %d", n, limit))
+	}
+	for _, t := range glossary {
+		if containsFold(source, t.Source) && !containsFold(translation, t.Target) {
+			issues = append(issues, fmt.Sprintf("glossary: %s should be %s", t.Source, t.Target))
+		}
+	}
+	return issues
+}
+
+func countTokens(s string) map[string]int {
+	counts := make(map[string]int)
+	for _, tok := range placeholderPattern.FindAllString(s, -1) {
+		counts[tok]++
+	}
+	return counts
+}
+
+func sortedTokens(counts map[string]int) []string {
+	return slices.Sorted(maps.Keys(counts))
+}
+
+// checkTagOrder reports a closing tag without an open tag of the same name before it.
+// Tags that are never closed, such as <br> or <sprite=1>, are allowed.
+func checkTagOrder(s string) string {
+	var open []string
+	for _, tok := range placeholderPattern.FindAllString(s, -1) {
+		m := tagPattern.FindStringSubmatch(tok)
+		if m == nil {
+			continue
+		}
+		name := strings.ToLower(m[2])
+		if m[1] == "" {
+			open = append(open, name)
+			continue
+		}
+		i := len(open) - 1
+		for i >= 0 && open[i] != name {
+			i--
+		}
+		if i < 0 {
+			return fmt.Sprintf("tag %s closes before it opens", tok)
+		}
+		open = open[:i]
+	}
+	return ""
+}
+
+// visibleLength counts the characters of s outside rich-text tags.
+func visibleLength(s string) int {
+	n := utf8.RuneCountInString(s)
+	for _, tok := range placeholderPattern.FindAllString(s, -1) {
+		if tagPattern.MatchString(tok) {
+			n -= utf8.RuneCountInString(tok)
+		}
+	}
+	return n
+}
+
+// sourceHash identifies a source text in the "<Target>_SrcHash" columns.
+func sourceHash(text string) string {
+	return hashBytes([]byte(text))[:16]
+}
+
+// hashSidecar keeps the source hash of each translated cell, keyed by "File:Sheet|ID|Language",
+// for sheets without "<Target>_SrcHash" columns. A translation is stale once its source text
+// changes, and stays stale until the translation itself changes.
+type hashSidecar struct {
+	path  string
+	cells map[string]sidecarEntry
+}
+
+// sidecarEntry is the source hash a translation was recorded with.
+type sidecarEntry struct {
+	Hash        string `json:"hash"`
+	Translation string `json:"translation"`
+}
+
+// readHashSidecar reads the sidecar of the review file output. A missing sidecar is empty, and
+// one without a review file is neither read nor written.
+func readHashSidecar(output string) (*hashSidecar, error) {
+	s := &hashSidecar{cells: make(map[string]sidecarEntry)}
+	if output == "" {
+		return s, nil
+	}
+	s.path = strings.TrimSuffix(output, filepath.Ext(output)) + LocalizeSidecarSuffix
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash sidecar: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.cells); err != nil {
+		return nil, fmt.Errorf("hash sidecar %s is not valid JSON: %w", s.path, err)
+	}
+	return s, nil
+}
+
+// stale reports whether the source of a translated cell changed since its translation was
+// recorded, and records the current hash unless it did.
+func (s *hashSidecar) stale(cell, hash, translation string) bool {
+	if e, ok := s.cells[cell]; ok && e.Translation == translation && e.Hash != hash {
+		return true
+	}
+	s.cells[cell] = sidecarEntry{Hash: hash, Translation: translation}
+	return false
+}
+
+// forget drops a cell whose translation was removed.
+func (s *hashSidecar) forget(cell string) {
+	delete(s.cells, cell)
+}
+
+func (s *hashSidecar) write() error {
+	if s.path == "" {
+		return nil
+	}
+	return os.WriteFile(s.path, marshalText(s.cells, "  "), 0644)
+}
+
+// write saves the report as JSON, or as a Review sheet when path ends in .xlsx.
+func (r *LocalizeReport) write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return os.WriteFile(path, marshalText(r, "  "), 0644)
+	}
+
+	sheet := &Sheet{Name: reviewSheetName, Columns: []string{"Key", "ID", "Row", "Language", "Status", "Source", "Previous", "Translation", "SourceHash", "MaxLength", "Issues"}}
+	for _, it := range r.Items {
+		row := map[string]interface{}{
+			"Key": it.Key, "ID": it.ID, "Row": it.Row, "Language": it.Language, "Status": it.Status,
+			"Source": it.Source, "Previous": it.Previous, "Translation": it.Translation, "SourceHash": it.SourceHash,
+			"Issues": strings.Join(it.Issues, "; "),
+		}
+		if it.MaxLength > 0 {
+			row["MaxLength"] = it.MaxLength
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	changes, err := planSheetUpdate(sheet, nil, DefaultSheetLayout(), "")
+	if err != nil {
+		return err
+	}
+	f := excelize.NewFile()
+	defer f.Close()
+	if err := f.SetSheetName(f.GetSheetList()[0], reviewSheetName); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := f.SetCellValue(reviewSheetName, c.Cell, c.value); err != nil {
+			return fmt.Errorf("cell %s: %w", c.Cell, err)
+		}
+	}
+	return f.SaveAs(path)
+}
+
+// marshalText encodes v as JSON without escaping <, > and &, so tags stay readable.
+func marshalText(v interface{}, indent string) []byte {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(v); err != nil {
+		return []byte(fmt.Sprint(v))
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// findColumn returns the column among columns named want, comparing case-insensitively.
+func findColumn(columns []string, want string) (string, bool) {
+	for _, c := range columns {
+		if strings.EqualFold(c, want) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
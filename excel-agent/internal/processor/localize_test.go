@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func localizeSheet(kr, en string) *Sheet {
+	return &Sheet{Name: "UI", Columns: []string{"ID", "KR", "EN"}, Rows: []map[string]interface{}{
+		{"ID": "start", "KR": kr, "EN": en},
+	}}
+}
+
+func TestScanTracksSourceHashesInSidecar(t *testing.T) {
+	opts := &LocalizeOptions{Source: "KR", Layout: DefaultSheetLayout()}
+	cs := &CatalogSheet{File: "LocalSeet", Name: "UI", Key: "LocalSeet:UI", PrimaryKey: "ID"}
+	output := filepath.Join(t.TempDir(), "review.xlsx")
+	run := func(sheet *Sheet) []LocalizeItem {
+		t.Helper()
+		sidecar, err := readHashSidecar(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, _, ok := opts.scan(cs, sheet, []string{"EN"}, sidecar)
+		if !ok {
+			t.Fatal("sheet has a KR column")
+		}
+		if err := sidecar.write(); err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	// The first run records the translation it finds.
+	if items := run(localizeSheet("시작", "Start")); len(items) != 0 {
+		t.Fatalf("first run = %+v, want nothing to do", items)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(output), "review"+LocalizeSidecarSuffix)); err != nil {
+		t.Fatalf("sidecar not written: %v", err)
+	}
+	// The source changed but the translation did not: stale, also on the next run.
+	for i := 0; i < 2; i++ {
+		items := run(localizeSheet("게임 시작", "Start"))
+		if len(items) != 1 || items[0].Status != LocalizeStale || items[0].ID != "start" {
+			t.Fatalf("run %d = %+v, want the start row stale", i+1, items)
+		}
+	}
+	// Updating the translation accepts the new source.
+	if items := run(localizeSheet("게임 시작", "Start game")); len(items) != 0 {
+		t.Fatalf("after updating the translation = %+v, want nothing to do", items)
+	}
+}
+
+func TestScanPrefersHashColumn(t *testing.T) {
+	opts := &LocalizeOptions{Source: "KR", Layout: DefaultSheetLayout()}
+	cs := &CatalogSheet{File: "LocalSeet", Name: "UI", Key: "LocalSeet:UI", PrimaryKey: "ID"}
+	sheet := &Sheet{Name: "UI", Columns: []string{"ID", "KR", "EN", "EN_SrcHash"}, Rows: []map[string]interface{}{
+		{"ID": "ok", "KR": "시작", "EN": "Start", "EN_SrcHash": sourceHash("시작")},
+		{"ID": "old", "KR": "종료", "EN": "Quit", "EN_SrcHash": sourceHash("나가기")},
+		{"ID": "new", "KR": "설정"},
+	}}
+	sidecar, err := readHashSidecar("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, checked, _ := opts.scan(cs, sheet, []string{"EN"}, sidecar)
+	if checked != 3 || len(items) != 2 {
+		t.Fatalf("checked %d, items %+v; want 3 checked and 2 items", checked, items)
+	}
+	if items[0].ID != "old" || items[0].Status != LocalizeStale || items[1].ID != "new" || items[1].Status != LocalizeMissing {
+		t.Errorf("items = %+v, want old stale and new missing", items)
+	}
+	if len(sidecar.cells) != 0 {
+		t.Errorf("a hash column should not be mirrored in the sidecar: %v", sidecar.cells)
+	}
+}
+
+func TestCheckTranslation(t *testing.T) {
+	glossary := []glossaryTerm{{Source: "골드", Target: "Gold"}}
+	tests := []struct {
+		name        string
+		source      string
+		translation string
+		limit       int
+		want        []string
+	}{
+		{"kept", "{0}님, <b>환영</b>합니다", "Welcome, <b>{0}</b>", 0, nil},
+		{"moved printf verbs", "%s이(가) %d 골드를 얻었습니다", "%s got %d Gold", 0, nil},
+		{"printf flags and width", "%-5d/%05.1f", "%-5d / %05.1f", 0, nil},
+		{"missing printf verb", "%1$s의 %2$d", "%1$s", 0, []string{"placeholder %2$d missing"}},
+		{"percent before a word", "5% 확률", "5% chance", 0, nil},
+		{"percent before d", "50% 할인", "50% discount", 0, nil},
+		{"percent before s", "100% 성공", "100% success", 0, nil},
+		{"missing placeholder", "{name}의 레벨 {0}", "Level {0}", 0, []string{"placeholder {name} missing"}},
+		{"unexpected placeholder", "확인", "OK {0}", 0, []string{"unexpected placeholder {0}"}},
+		{"tag closes first", "<color=#ff0000>위험</color>", "</color>Danger<color=#ff0000>", 0, []string{"tag </color> closes before it opens"}},
+		{"unclosed tags allowed", "줄<br>바꿈", "Line<br>break", 0, nil},
+		{"tags not counted", "<b>시작</b>", "<b>Start</b>", 5, nil},
+		{"too long", "시작", "Start the game", 5, []string{"14 characters, limit 5"}},
+		{"glossary", "100 골드", "100 coins", 0, []string{"glossary: 골드 should be Gold"}},
+		{"glossary case-insensitive", "100 골드", "100 gold", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkTranslation(tt.source, tt.translation, tt.limit, glossary)
+			if len(got) != len(tt.want) {
+				t.Fatalf("issues = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("issues = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestFileName is the manifest kept in the JSON directory. It has no .json
+// extension so it is never mistaken for converted sheet data.
+const ManifestFileName = ".xlsx-manifest"
+
+// Sheet change kinds reported by ConversionReport.
+const (
+	SheetAdded   = "added"
+	SheetChanged = "changed"
+	SheetRemoved = "removed"
+)
+
+// Manifest records the content hash of every converted workbook and of each of its sheets,
+// so unchanged workbooks can be skipped on the next run.
+type Manifest struct {
+	Files map[string]*ManifestFile `json:"files"`
+}
+
+// ManifestFile is the manifest entry of one source workbook.
+type ManifestFile struct {
+	Hash string `json:"hash"`
+	// Options is a hash of the conversion options; a change forces reconversion.
+	Options string `json:"options"`
+	Output  string `json:"output"`
+	// Extra lists the files written in formats other than JSON.
+	Extra  []string          `json:"extra,omitempty"`
+	Sheets map[string]string `json:"sheets"`
+}
+
+// ConversionReport summarizes an incremental conversion run.
+type ConversionReport struct {
+	Converted []string      `json:"converted,omitempty"`
+	Skipped   []string      `json:"skipped,omitempty"`
+	Failed    []string      `json:"failed,omitempty"`
+	Removed   []string      `json:"removed,omitempty"`
+	Changes   []SheetChange `json:"changes,omitempty"`
+}
+
+// SheetChange is a sheet that was added, changed or removed by a conversion.
+type SheetChange struct {
+	File   string `json:"file"`
+	Sheet  string `json:"sheet"`
+	Change string `json:"change"`
+}
+
+func (r *ConversionReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Converted: %d, skipped (unchanged): %d, failed: %d, removed: %d",
+		len(r.Converted), len(r.Skipped), len(r.Failed), len(r.Removed))
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "\n  %-8s %s:%s", c.Change, c.File, c.Sheet)
+	}
+	return b.String()
+}
+
+// LoadManifest reads the manifest from jsonDir. A missing manifest yields an empty one.
+func LoadManifest(jsonDir string) (*Manifest, error) {
+	m := &Manifest{Files: make(map[string]*ManifestFile)}
+	data, err := os.ReadFile(filepath.Join(jsonDir, ManifestFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]*ManifestFile)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to jsonDir.
+func (m *Manifest) Save(jsonDir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(jsonDir, ManifestFileName), data, 0644)
+}
+
+// ConvertWorkbook converts a single workbook and updates the manifest, like one step of ProcessXlsxFiles.
+func ConvertWorkbook(excelPath, jsonDir string, opts *ConvertOptions, force bool) (*ConversionReport, error) {
+	m, err := LoadManifest(jsonDir)
+	if err != nil {
+		return nil, err
+	}
+	report := &ConversionReport{}
+	if err := m.convert(excelPath, jsonDir, opts, force, report); err != nil {
+		report.Failed = append(report.Failed, filepath.Base(excelPath))
+		return report, err
+	}
+	return report, m.Save(jsonDir)
+}
+
+// ForgetWorkbook removes a deleted or renamed workbook from the manifest together with its JSON output.
+func ForgetWorkbook(name, jsonDir string) (*ConversionReport, error) {
+	m, err := LoadManifest(jsonDir)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(m.Files))
+	for other := range m.Files {
+		present[other] = other != name
+	}
+	report := &ConversionReport{}
+	m.prune(jsonDir, present, report)
+	return report, m.Save(jsonDir)
+}
+
+// convert converts one workbook unless its hash and the options are unchanged,
+// recording the result in the manifest and the report.
+func (m *Manifest) convert(excelPath, jsonDir string, opts *ConvertOptions, force bool, report *ConversionReport) error {
+	name := filepath.Base(excelPath)
+	content, err := os.ReadFile(excelPath)
+	if err != nil {
+		return err
+	}
+	hash := hashBytes(content)
+	optsHash := optionsHash(opts)
+
+	prev := m.Files[name]
+	output := excelJSONName(excelPath, opts)
+	if !force && prev != nil && prev.Hash == hash && prev.Options == optsHash {
+		if _, err := os.Stat(filepath.Join(jsonDir, output)); err == nil {
+			report.Skipped = append(report.Skipped, name)
+			return nil
+		}
+	}
+
+	wb, err := ReadWorkbook(excelPath, opts)
+	if err != nil {
+		return err
+	}
+	jsonPath, extra, err := writeOutputs(wb, jsonDir, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Converted %s to %s (Sheets: %d)\n", excelPath, jsonPath, len(wb.Sheets))
+
+	entry := &ManifestFile{Hash: hash, Options: optsHash, Output: output, Extra: extra, Sheets: make(map[string]string)}
+	for _, s := range wb.Sheets {
+		data, err := json.Marshal(s.Rows)
+		if err != nil {
+			return err
+		}
+		entry.Sheets[s.Name] = hashBytes(data)
+	}
+	if prev != nil {
+		removeStaleOutputs(prev.Extra, extra)
+		if prev.Output != output {
+			// The output name changed, e.g. the workbook was given a stable name in the sources manifest.
+			removeStaleOutputs([]string{filepath.Join(jsonDir, prev.Output)}, nil)
+		}
+	}
+
+	var old map[string]string
+	if prev != nil {
+		old = prev.Sheets
+	}
+	report.Changes = append(report.Changes, diffSheetHashes(name, old, entry.Sheets)...)
+	report.Converted = append(report.Converted, name)
+
+	m.Files[name] = entry
+	return nil
+}
+
+// prune drops manifest entries whose workbook no longer exists, removing their JSON output.
+func (m *Manifest) prune(jsonDir string, present map[string]bool, report *ConversionReport) {
+	for name, entry := range m.Files {
+		if present[name] {
+			continue
+		}
+		report.Changes = append(report.Changes, diffSheetHashes(name, entry.Sheets, nil)...)
+		report.Removed = append(report.Removed, name)
+		if err := os.Remove(filepath.Join(jsonDir, entry.Output)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove stale %s: %v", entry.Output, err)
+		}
+		removeStaleOutputs(entry.Extra, nil)
+		delete(m.Files, name)
+	}
+}
+
+// removeStaleOutputs deletes previously written files that are not in cur.
+func removeStaleOutputs(prev, cur []string) {
+	keep := make(map[string]bool, len(cur))
+	for _, p := range cur {
+		keep[p] = true
+	}
+	for _, p := range prev {
+		if keep[p] {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove stale %s: %v", p, err)
+		}
+	}
+}
+
+// diffSheetHashes lists added, changed and removed sheets in sheet-name order.
+func diffSheetHashes(file string, old, cur map[string]string) []SheetChange {
+	var changes []SheetChange
+	for sheet, h := range cur {
+		if prev, ok := old[sheet]; !ok {
+			changes = append(changes, SheetChange{File: file, Sheet: sheet, Change: SheetAdded})
+		} else if prev != h {
+			changes = append(changes, SheetChange{File: file, Sheet: sheet, Change: SheetChanged})
+		}
+	}
+	for sheet := range old {
+		if _, ok := cur[sheet]; !ok {
+			changes = append(changes, SheetChange{File: file, Sheet: sheet, Change: SheetRemoved})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Sheet < changes[j].Sheet })
+	return changes
+}
+
+func optionsHash(opts *ConvertOptions) string {
+	data, _ := json.Marshal(opts)
+	return hashBytes(data)
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
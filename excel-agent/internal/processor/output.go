@@ -0,0 +1,291 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats accepted by ParseOutputFormats.
+const (
+	FormatJSON    = "json"
+	FormatJSONMin = "json-min"
+	FormatCSV     = "csv"
+	FormatYAML    = "yaml"
+	FormatMsgPack = "msgpack"
+	FormatSQLite  = "sqlite"
+)
+
+// DefaultOutputDir is where formats other than JSON are written when no directory is configured.
+const DefaultOutputDir = "out"
+
+// OutputWriter writes a converted workbook in one format.
+type OutputWriter interface {
+	// Format is the name used in OUTPUT_FORMATS and -format.
+	Format() string
+	// Write stores wb under dir and returns the paths it wrote.
+	Write(wb *Workbook, dir string) ([]string, error)
+}
+
+// NewOutputWriter returns the writer of a format.
+func NewOutputWriter(format string) (OutputWriter, error) {
+	switch format {
+	case FormatJSON:
+		return jsonWriter{indent: true}, nil
+	case FormatJSONMin:
+		return jsonWriter{}, nil
+	case FormatCSV:
+		return csvWriter{}, nil
+	case FormatYAML:
+		return yamlWriter{}, nil
+	case FormatMsgPack:
+		return msgpackWriter{}, nil
+	case FormatSQLite:
+		return sqliteWriter{}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, want one of json, json-min, csv, yaml, msgpack, sqlite", format)
+}
+
+// ParseOutputFormats parses a comma-separated format list such as "json,csv,sqlite".
+// json and json-min are mutually exclusive since both write JSON_DIR/<File>.json.
+func ParseOutputFormats(spec string) ([]string, error) {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		if _, err := NewOutputWriter(f); err != nil {
+			return nil, err
+		}
+		seen[f] = true
+		formats = append(formats, f)
+	}
+	if seen[FormatJSON] && seen[FormatJSONMin] {
+		return nil, fmt.Errorf("choose either %s or %s", FormatJSON, FormatJSONMin)
+	}
+	return formats, nil
+}
+
+// writeOutputs writes wb in every configured format. JSON (indented, or minified with
+// json-min) always goes to jsonDir since the rest of the pipeline reads it from there;
+// other formats go to <OutputDir>/<format>. It returns the JSON path and the other paths.
+func writeOutputs(wb *Workbook, jsonDir string, opts *ConvertOptions) (string, []string, error) {
+	primary := FormatJSON
+	var extra []string
+	for _, f := range opts.formats() {
+		if f == FormatJSON || f == FormatJSONMin {
+			primary = f
+		} else {
+			extra = append(extra, f)
+		}
+	}
+
+	w, err := NewOutputWriter(primary)
+	if err != nil {
+		return "", nil, err
+	}
+	paths, err := w.Write(wb, jsonDir)
+	if err != nil {
+		return "", nil, err
+	}
+	jsonPath := paths[0]
+
+	var others []string
+	for _, f := range extra {
+		w, err := NewOutputWriter(f)
+		if err != nil {
+			return "", nil, err
+		}
+		paths, err := w.Write(wb, filepath.Join(opts.outputDir(), f))
+		if err != nil {
+			return "", nil, fmt.Errorf("%s output: %w", f, err)
+		}
+		others = append(others, paths...)
+	}
+	return jsonPath, others, nil
+}
+
+type jsonWriter struct {
+	indent bool
+}
+
+func (w jsonWriter) Format() string {
+	if w.indent {
+		return FormatJSON
+	}
+	return FormatJSONMin
+}
+
+func (w jsonWriter) Write(wb *Workbook, dir string) ([]string, error) {
+	var data []byte
+	var err error
+	if w.indent {
+		data, err = json.MarshalIndent(wb, "", "  ")
+	} else {
+		data, err = json.Marshal(wb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, safeFileName(wb.Name)+".json")
+	return []string{path}, os.WriteFile(path, data, 0644)
+}
+
+// csvWriter writes one CSV file per sheet into <dir>/<File>/, with the header in column order.
+type csvWriter struct{}
+
+func (csvWriter) Format() string { return FormatCSV }
+
+func (csvWriter) Write(wb *Workbook, dir string) ([]string, error) {
+	dir = filepath.Join(dir, safeFileName(wb.Name))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, s := range wb.Sheets {
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		if err := cw.Write(s.Columns); err != nil {
+			return nil, err
+		}
+		record := make([]string, len(s.Columns))
+		for _, row := range s.Rows {
+			for i, col := range s.Columns {
+				record[i] = textValue(row[col])
+			}
+			if err := cw.Write(record); err != nil {
+				return nil, err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, safeFileName(s.Name)+".csv")
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+type yamlWriter struct{}
+
+func (yamlWriter) Format() string { return FormatYAML }
+
+func (yamlWriter) Write(wb *Workbook, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	doc, err := yamlWorkbook(wb)
+	if err != nil {
+		return nil, err
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, safeFileName(wb.Name)+".yaml")
+	return []string{path}, os.WriteFile(path, data, 0644)
+}
+
+// yamlWorkbook builds the YAML document of wb with sheets and columns in workbook order.
+func yamlWorkbook(wb *Workbook) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range wb.Sheets {
+		rows := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, row := range s.Rows {
+			m := &yaml.Node{Kind: yaml.MappingNode}
+			for _, col := range rowKeyOrder(row, s.Columns) {
+				val := &yaml.Node{}
+				if err := val.Encode(row[col]); err != nil {
+					return nil, fmt.Errorf("sheet %s, column %s: %w", s.Name, col, err)
+				}
+				m.Content = append(m.Content, yamlString(col), val)
+			}
+			rows.Content = append(rows.Content, m)
+		}
+		doc.Content = append(doc.Content, yamlString(s.Name), rows)
+	}
+	return doc, nil
+}
+
+func yamlString(s string) *yaml.Node {
+	n := &yaml.Node{}
+	_ = n.Encode(s)
+	return n
+}
+
+type msgpackWriter struct{}
+
+func (msgpackWriter) Format() string { return FormatMsgPack }
+
+func (msgpackWriter) Write(wb *Workbook, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	// Sheets and columns are written in workbook order; sorted keys inside
+	// object cells keep the output byte-identical across runs.
+	enc.SetSortMapKeys(true)
+	if err := encodeMsgpackWorkbook(enc, wb); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, safeFileName(wb.Name)+".msgpack")
+	return []string{path}, os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func encodeMsgpackWorkbook(enc *msgpack.Encoder, wb *Workbook) error {
+	if err := enc.EncodeMapLen(len(wb.Sheets)); err != nil {
+		return err
+	}
+	for _, s := range wb.Sheets {
+		if err := enc.EncodeString(s.Name); err != nil {
+			return err
+		}
+		if err := enc.EncodeArrayLen(len(s.Rows)); err != nil {
+			return err
+		}
+		for _, row := range s.Rows {
+			keys := rowKeyOrder(row, s.Columns)
+			if err := enc.EncodeMapLen(len(keys)); err != nil {
+				return err
+			}
+			for _, col := range keys {
+				if err := enc.EncodeString(col); err != nil {
+					return err
+				}
+				if err := enc.Encode(row[col]); err != nil {
+					return fmt.Errorf("sheet %s, column %s: %w", s.Name, col, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// textValue renders a cell for text formats; arrays and objects become JSON.
+func textValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []interface{}, map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+	return valueKey(v)
+}
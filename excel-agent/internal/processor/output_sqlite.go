@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteWriter writes a workbook to <dir>/<File>.db with one table per sheet.
+// Column affinities are inferred from the values; arrays are stored as JSON text.
+type sqliteWriter struct{}
+
+func (sqliteWriter) Format() string { return FormatSQLite }
+
+func (sqliteWriter) Write(wb *Workbook, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, safeFileName(wb.Name)+".db")
+	// Build into a temporary file so readers never see a half-written database.
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+
+	if err := writeSQLite(wb, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+func writeSQLite(wb *Workbook, path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range wb.Sheets {
+		if len(s.Columns) == 0 {
+			continue
+		}
+		defs := make([]string, len(s.Columns))
+		names := sqliteColumnNames(s.Columns)
+		marks := make([]string, len(s.Columns))
+		for i, col := range s.Columns {
+			names[i] = quoteIdent(names[i])
+			defs[i] = names[i] + " " + sqliteColumnType(s.Rows, col)
+			marks[i] = "?"
+		}
+		table := quoteIdent(s.Name)
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(defs, ", "))); err != nil {
+			return fmt.Errorf("sheet %s: %w", s.Name, err)
+		}
+
+		stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(marks, ", ")))
+		if err != nil {
+			return fmt.Errorf("sheet %s: %w", s.Name, err)
+		}
+		args := make([]interface{}, len(s.Columns))
+		for _, row := range s.Rows {
+			for i, col := range s.Columns {
+				args[i] = sqliteValue(row[col])
+			}
+			if _, err := stmt.Exec(args...); err != nil {
+				stmt.Close()
+				return fmt.Errorf("sheet %s: %w", s.Name, err)
+			}
+		}
+		stmt.Close()
+	}
+	return tx.Commit()
+}
+
+// sqliteColumnType picks INTEGER, REAL or TEXT from the values of a column.
+func sqliteColumnType(rows []map[string]interface{}, col string) string {
+	typ := ""
+	for _, row := range rows {
+		var t string
+		switch v := row[col].(type) {
+		case nil:
+			continue
+		case int64, bool:
+			t = "INTEGER"
+		case float64:
+			if v == math.Trunc(v) {
+				t = "INTEGER"
+			} else {
+				t = "REAL"
+			}
+		default:
+			return "TEXT"
+		}
+		switch {
+		case typ == "":
+			typ = t
+		case typ != t:
+			// Integers and reals mix into REAL.
+			typ = "REAL"
+		}
+	}
+	if typ == "" {
+		return "TEXT"
+	}
+	return typ
+}
+
+func sqliteValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case []interface{}, map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+	return v
+}
+
+// sqliteColumnNames returns the table column names for columns. SQLite compares
+// identifiers case-insensitively, so a later "ID" next to "id" becomes "ID_2".
+func sqliteColumnNames(columns []string) []string {
+	taken := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		taken[strings.ToLower(col)] = true
+	}
+	seen := make(map[string]bool, len(columns))
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		name := col
+		if seen[strings.ToLower(col)] {
+			for n := 2; taken[strings.ToLower(name)]; n++ {
+				name = fmt.Sprintf("%s_%d", col, n)
+			}
+			taken[strings.ToLower(name)] = true
+		}
+		seen[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,377 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Aggregate functions accepted in Aggregate.Func.
+const (
+	AggCount = "count"
+	AggSum   = "sum"
+	AggAvg   = "avg"
+	AggMin   = "min"
+	AggMax   = "max"
+)
+
+// planHintValues bounds the sample values shown to the model when a plan's filters match nothing.
+const planHintValues = 10
+
+// SearchScoreColumn holds the similarity of each row when a plan uses semantic search.
+const SearchScoreColumn = "_score"
+
+// QueryPlan is the query the model writes for a question. Without GroupBy and Aggregates it
+// returns rows like SheetQuery. Otherwise it returns one row per group, with the GroupBy columns
+// followed by the aggregate names (e.g. "max(Atk)"); Sort refers to those and Columns is ignored.
+// With Search, only the rows semantically similar to it are queried, best first.
+type QueryPlan struct {
+	SheetQuery
+	Search     string      `json:"search,omitempty" description:"Text to search the sheet's indexed columns for by meaning; only the most similar rows are queried"`
+	GroupBy    []string    `json:"groupBy,omitempty" description:"Columns to group the filtered rows by; each group becomes one result row"`
+	Aggregates []Aggregate `json:"aggregates,omitempty" description:"Values computed over the filtered rows, or over each group"`
+}
+
+// Aggregate is a value computed over a set of rows. count counts the rows, or the rows with a
+// value in Column; sum and avg need numbers; min and max also compare text and dates.
+type Aggregate struct {
+	Func   string `json:"func" description:"One of count, sum, avg, min, max"`
+	Column string `json:"column,omitempty" description:"Column to aggregate; leave empty to count rows"`
+}
+
+// Name is the result column of a: the function, followed by the column in parentheses if there is one.
+func (a Aggregate) Name() string {
+	if a.Column == "" {
+		return a.Func
+	}
+	return a.Func + "(" + a.Column + ")"
+}
+
+// Run executes p against the rows of sheet.
+func (p *QueryPlan) Run(sheet *Sheet) (*QueryResult, error) {
+	if len(p.GroupBy) == 0 && len(p.Aggregates) == 0 {
+		return p.SheetQuery.Run(sheet)
+	}
+	matched, columns, err := p.filter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range p.GroupBy {
+		if !slices.Contains(columns, col) {
+			return nil, unknownColumn(col, columns)
+		}
+	}
+	aggs := p.Aggregates
+	if len(aggs) == 0 {
+		aggs = []Aggregate{{Func: AggCount}}
+	}
+	out := &Sheet{Name: sheet.Name, Columns: slices.Clone(p.GroupBy)}
+	for _, a := range aggs {
+		switch a.Func {
+		case AggCount:
+		case AggSum, AggAvg, AggMin, AggMax:
+			if a.Column == "" {
+				return nil, fmt.Errorf("aggregate %s needs a column", a.Func)
+			}
+		default:
+			return nil, fmt.Errorf("unknown aggregate %q; use count, sum, avg, min or max", a.Func)
+		}
+		if a.Column != "" && !slices.Contains(columns, a.Column) {
+			return nil, unknownColumn(a.Column, columns)
+		}
+		out.Columns = append(out.Columns, a.Name())
+	}
+
+	// Groups keep the order of their first row.
+	var order []string
+	groups := make(map[string][]map[string]interface{})
+	for _, row := range matched {
+		parts := make([]string, len(p.GroupBy))
+		for i, col := range p.GroupBy {
+			parts[i] = valueKey(row[col])
+		}
+		k := strings.Join(parts, "\x00")
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], row)
+	}
+	// Without GroupBy the aggregates are reported even if no row matched.
+	if len(p.GroupBy) == 0 && len(order) == 0 {
+		order = append(order, "")
+	}
+
+	for _, k := range order {
+		rows := groups[k]
+		row := make(map[string]interface{}, len(out.Columns))
+		if len(rows) > 0 {
+			for _, col := range p.GroupBy {
+				row[col] = rows[0][col]
+			}
+		}
+		for _, a := range aggs {
+			v, err := a.compute(rows)
+			if err != nil {
+				return nil, err
+			}
+			row[a.Name()] = v
+		}
+		out.Rows = append(out.Rows, row)
+	}
+
+	q := &SheetQuery{Key: p.Key, Sort: p.Sort, Limit: p.Limit, Offset: p.Offset}
+	return q.Run(out)
+}
+
+func (a Aggregate) compute(rows []map[string]interface{}) (interface{}, error) {
+	if a.Func == AggCount && a.Column == "" {
+		return len(rows), nil
+	}
+	n, sum := 0, 0.0
+	var best interface{}
+	for _, row := range rows {
+		v := row[a.Column]
+		if isEmptyValue(v) {
+			continue
+		}
+		n++
+		switch a.Func {
+		case AggSum, AggAvg:
+			f, ok := numericValue(v)
+			if !ok {
+				return nil, fmt.Errorf("%s: column %s has the non-numeric value %v", a.Name(), a.Column, v)
+			}
+			sum += f
+		case AggMin:
+			if best == nil || compareCells(v, best) < 0 {
+				best = v
+			}
+		case AggMax:
+			if best == nil || compareCells(v, best) > 0 {
+				best = v
+			}
+		}
+	}
+	switch a.Func {
+	case AggCount:
+		return n, nil
+	case AggSum:
+		return sum, nil
+	case AggAvg:
+		if n == 0 {
+			return nil, nil
+		}
+		return sum / float64(n), nil
+	}
+	return best, nil
+}
+
+// QueryAnswer is the output of queryFlow: the answer, and the plan and computed result it is based on.
+type QueryAnswer struct {
+	Question string        `json:"question"`
+	Answer   string        `json:"answer"`
+	Plan     *QueryPlan    `json:"plan,omitempty"`
+	Result   *QueryResult  `json:"result,omitempty"`
+	Attempts []PlanAttempt `json:"attempts"`
+}
+
+// PlanAttempt is one plan the model wrote, and why it was rejected if it was.
+type PlanAttempt struct {
+	Attempt int        `json:"attempt"`
+	Plan    *QueryPlan `json:"plan,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func (a *QueryAnswer) String() string {
+	var b strings.Builder
+	b.WriteString(a.Answer)
+	if a.Plan != nil {
+		plan, _ := json.Marshal(a.Plan)
+		fmt.Fprintf(&b, "\n\nPlan (attempt %d): %s", len(a.Attempts), plan)
+	}
+	if a.Result != nil {
+		res, _ := json.Marshal(a.Result)
+		fmt.Fprintf(&b, "\nResult: %s", res)
+	}
+	return b.String()
+}
+
+const planSystemPrompt = `You translate questions about game data into a query plan over one sheet.
+The plan is executed exactly by a program, so never compute or guess the answer yourself.
+- key: a 'FileName:SheetName' key from the catalog. Use only the columns of that sheet.
+- where: conditions every row must satisfy (op: eq, ne, lt, lte, gt, gte, contains). Numbers compare numerically.
+- To list rows, choose the 'columns' needed and a 'limit'.
+- For the row with the highest or lowest value, sort by that column (desc for highest) with limit 1.
+- For counts, sums, averages, minima and maxima use 'aggregates' (count, sum, avg, min, max),
+  and 'groupBy' for one value per group. With aggregates, 'sort' refers to the groupBy columns
+  and aggregate names such as count or max(Atk).
+- A follow-up question (e.g. "now only grade SSR") refers to the last plan of the conversation:
+  start from that plan and change only what the question asks. A question about rows of the last
+  result (e.g. "the second one") filters on their values, such as their ID.
+- search: only for questions about what a text means or is about (e.g. "lines about betrayal") and only for
+  sheets in the search index. The most similar rows are kept, best first, with their similarity in _score;
+  'where' and 'sort' still apply to them.`
+
+const answerSystemPrompt = `You answer questions about game data from the result of a query plan.
+The result was computed exactly from the sheet: report its values as they are and do not recount or recompute them.
+'total' is the number of matching rows, or of groups when the plan aggregates. When 'more' is true only the first rows are listed.
+If the result is empty, say that no data matched. Answer concisely, in the language of the question.`
+
+// AnswerQuestion answers a question about the data in the catalog. The model writes a QueryPlan
+// as structured output; the plan is executed in Go, and the model phrases the answer from the
+// computed result. Plans that fail, or whose filters match nothing, are fed back to the model
+// for up to maxAttempts attempts in total. history holds the earlier turns of a chat session,
+// so a follow-up question can refine the previous plan. search enables plans with semantic
+// search; it may be nil.
+func AnswerQuestion(ctx context.Context, g *genkit.Genkit, question string, history []ChatTurn, loader *CatalogLoader, search *RowSearcher, maxAttempts int) (*QueryAnswer, error) {
+	catalog, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prompt := fmt.Sprintf("Catalog:\n%s\n", catalog.summary())
+	if indexed := search.describe(); indexed != "" {
+		prompt += fmt.Sprintf("Search index (sheet: columns):\n%s\n", indexed)
+	}
+	prompt += fmt.Sprintf("%sQuestion: %s", historyPrompt(history, true), question)
+
+	answer := &QueryAnswer{Question: question}
+	maxAttempts = max(maxAttempts, 1)
+	feedback, hinted := "", false
+	for i := 1; i <= maxAttempts && answer.Result == nil; i++ {
+		plan, _, err := genkit.GenerateData[QueryPlan](ctx, g,
+			ai.WithSystem(planSystemPrompt),
+			// The catalog, results and feedback may contain printf verbs, so the prompt must not be a format string.
+			ai.WithPrompt("%s", prompt+feedback),
+		)
+		if err != nil {
+			return answer, fmt.Errorf("AI query planning failed: %v", err)
+		}
+
+		attempt := PlanAttempt{Attempt: i, Plan: plan}
+		sheet, res, err := runPlan(ctx, loader, search, catalog, plan)
+		switch {
+		case err != nil:
+			attempt.Error = err.Error()
+		case len(plan.Where) > 0 && !hinted && i < maxAttempts && !plan.matchesAny(sheet):
+			// An empty result is often a filter value spelled differently from the data; ask once.
+			hinted = true
+			attempt.Error = filterHint(sheet, plan.Where)
+		default:
+			answer.Plan, answer.Result = plan, res
+		}
+		answer.Attempts = append(answer.Attempts, attempt)
+		if attempt.Error != "" {
+			log.Printf("Query plan rejected (attempt %d/%d): %s", i, maxAttempts, attempt.Error)
+			data, _ := json.Marshal(plan)
+			feedback = fmt.Sprintf("\n\n[Previous Plan]:\n%s\n\n[Error]:\n%s\n\nWrite a corrected plan.", data, attempt.Error)
+		}
+	}
+	if answer.Result == nil {
+		return answer, fmt.Errorf("no valid query plan after %d attempts: %s", maxAttempts, answer.Attempts[len(answer.Attempts)-1].Error)
+	}
+
+	plan, _ := json.Marshal(answer.Plan)
+	res, _ := json.Marshal(answer.Result)
+	text, err := genkit.GenerateText(ctx, g,
+		ai.WithSystem(answerSystemPrompt),
+		ai.WithPrompt("%s", fmt.Sprintf("%sQuestion: %s\n\nQuery plan:\n%s\n\nResult:\n%s", historyPrompt(history, false), question, plan, res)),
+	)
+	if err != nil {
+		return answer, fmt.Errorf("AI answer failed: %v", err)
+	}
+	answer.Answer = text
+	return answer, nil
+}
+
+// matchesAny reports whether any row of sheet passes the filters of p.
+func (p *QueryPlan) matchesAny(sheet *Sheet) bool {
+	for _, row := range sheet.Rows {
+		if p.matches(row) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPlan resolves the sheet of plan in the catalog, reads it and executes the plan.
+func runPlan(ctx context.Context, loader *CatalogLoader, search *RowSearcher, catalog *Catalog, plan *QueryPlan) (*Sheet, *QueryResult, error) {
+	s, ok := catalog.Sheet(plan.Key)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sheet %q; use a key from the catalog", plan.Key)
+	}
+	plan.Key = s.Key
+	sheet, err := loader.ReadSheet(ctx, s.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if plan.Search != "" {
+		if sheet, err = rankBySearch(ctx, search, s, sheet, plan.Search); err != nil {
+			return nil, nil, err
+		}
+	}
+	res, err := plan.Run(sheet)
+	return sheet, res, err
+}
+
+// rankBySearch keeps the rows of sheet that semantic search finds for query, best first, with
+// their similarity in SearchScoreColumn. Hits are matched by primary key, or by position if the
+// sheet has none.
+func rankBySearch(ctx context.Context, search *RowSearcher, s *CatalogSheet, sheet *Sheet, query string) (*Sheet, error) {
+	if search == nil {
+		return nil, fmt.Errorf("semantic search is not configured; filter with 'where' instead")
+	}
+	res, err := search.Search(ctx, query, s.Key, MaxSearchLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Hits) == 0 {
+		return nil, fmt.Errorf("sheet %s is not in the search index; filter with 'where' instead", s.Key)
+	}
+	rows := make(map[string]map[string]interface{}, len(sheet.Rows))
+	for i, row := range sheet.Rows {
+		rows[rowID(row, s.PrimaryKey, i)] = row
+	}
+	out := &Sheet{Name: sheet.Name, Columns: append(slices.Clone(sheet.Columns), SearchScoreColumn)}
+	for _, hit := range res.Hits {
+		row, ok := rows[hit.ID]
+		if !ok {
+			continue
+		}
+		ranked := maps.Clone(row)
+		ranked[SearchScoreColumn] = hit.Score
+		out.Rows = append(out.Rows, ranked)
+	}
+	return out, nil
+}
+
+// filterHint explains an empty result with some of the values the filtered columns do hold.
+func filterHint(sheet *Sheet, filters []QueryFilter) string {
+	var b strings.Builder
+	b.WriteString("No rows match the filters.")
+	seen := make(map[string]bool)
+	for _, f := range filters {
+		if seen[f.Column] {
+			continue
+		}
+		seen[f.Column] = true
+		var values []string
+		have := make(map[string]bool)
+		for _, row := range sheet.Rows {
+			for _, v := range valueElements(row[f.Column]) {
+				if k := valueKey(v); !isEmptyValue(v) && !have[k] && len(values) < planHintValues {
+					have[k] = true
+					values = append(values, k)
+				}
+			}
+		}
+		fmt.Fprintf(&b, " Values of %s include: %s.", f.Column, strings.Join(values, ", "))
+	}
+	return b.String()
+}
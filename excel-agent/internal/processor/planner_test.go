@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryPlanGroupsAndAggregates(t *testing.T) {
+	p := &QueryPlan{
+		GroupBy:    []string{"Grade"},
+		Aggregates: []Aggregate{{Func: AggCount}, {Func: AggAvg, Column: "Atk"}, {Func: AggMax, Column: "Atk"}, {Func: AggMin, Column: "Name"}},
+		SheetQuery: SheetQuery{Key: "Character:UnitData", Sort: []QuerySort{{Column: "count", Desc: true}}},
+	}
+	res, err := p.Run(unitSheet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Grade", "count", "avg(Atk)", "max(Atk)", "min(Name)"}; !reflect.DeepEqual(res.Columns, want) {
+		t.Errorf("columns = %v, want %v", res.Columns, want)
+	}
+	// Equal counts keep the order of each group's first row; R has no Atk values at all.
+	want := [][]interface{}{
+		{"SR", 2, 107.5, int64(120), "Knight"},
+		{"SSR", 2, 122.75, int64(150), "Archer"},
+		{"R", 1, nil, nil, "Squire"},
+	}
+	if !reflect.DeepEqual(res.Rows, want) || res.Total != 3 {
+		t.Errorf("rows = %v (total %d), want %v", res.Rows, res.Total, want)
+	}
+
+	// Sorting by an aggregate keeps groups without a value last.
+	p.Sort = []QuerySort{{Column: "max(Atk)", Desc: true}}
+	if res, err = p.Run(unitSheet()); err != nil {
+		t.Fatal(err)
+	}
+	if got := []interface{}{res.Rows[0][0], res.Rows[1][0], res.Rows[2][0]}; !reflect.DeepEqual(got, []interface{}{"SSR", "SR", "R"}) {
+		t.Errorf("groups = %v, want SSR, SR, R", got)
+	}
+}
+
+func TestQueryPlanAggregatesWithoutGroups(t *testing.T) {
+	p := &QueryPlan{Aggregates: []Aggregate{{Func: AggCount, Column: "Atk"}, {Func: AggSum, Column: "Atk"}}}
+	res, err := p.Run(unitSheet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{4, 460.5}}; !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("rows = %v, want %v", res.Rows, want)
+	}
+
+	// Aggregates are reported even when no row matches.
+	p.Where = []QueryFilter{{Column: "Grade", Op: OpEq, Value: "UR"}}
+	p.Aggregates = append(p.Aggregates, Aggregate{Func: AggAvg, Column: "Atk"})
+	if res, err = p.Run(unitSheet()); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{0, 0.0, nil}}; !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("rows = %v, want %v", res.Rows, want)
+	}
+}
+
+func TestQueryPlanRejectsBadAggregates(t *testing.T) {
+	tests := []struct {
+		name string
+		p    QueryPlan
+		want string
+	}{
+		{"unknown function", QueryPlan{Aggregates: []Aggregate{{Func: "median", Column: "Atk"}}}, `unknown aggregate "median"`},
+		{"missing column", QueryPlan{Aggregates: []Aggregate{{Func: AggSum}}}, "aggregate sum needs a column"},
+		{"unknown column", QueryPlan{Aggregates: []Aggregate{{Func: AggMax, Column: "Def"}}}, `unknown column "Def"`},
+		{"unknown group column", QueryPlan{GroupBy: []string{"Class"}}, `unknown column "Class"`},
+		{"non-numeric sum", QueryPlan{Aggregates: []Aggregate{{Func: AggSum, Column: "Name"}}}, "sum(Name): column Name has the non-numeric value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.p.Run(unitSheet())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
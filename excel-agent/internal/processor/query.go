@@ -0,0 +1,290 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Row limits of SheetQuery, so a query cannot flood the model's context.
+const (
+	DefaultQueryLimit = 20
+	MaxQueryLimit     = 200
+)
+
+// Filter operators accepted in QueryFilter.Op.
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpContains = "contains"
+)
+
+// SheetQuery selects rows of one sheet. Filtering, sorting and paging run in Go,
+// so only the requested page of the requested columns is returned.
+type SheetQuery struct {
+	Key     string        `json:"key" description:"Sheet key in the form 'FileName:SheetName' (e.g., 'Character:UnitData')"`
+	Columns []string      `json:"columns,omitempty" description:"Columns to return, in this order; empty returns every column"`
+	Where   []QueryFilter `json:"where,omitempty" description:"Conditions a row must all satisfy"`
+	Sort    []QuerySort   `json:"sort,omitempty" description:"Sort keys, most significant first"`
+	Limit   int           `json:"limit,omitempty" description:"Maximum number of rows to return (default 20, at most 200)"`
+	Offset  int           `json:"offset,omitempty" description:"Number of matching rows to skip, for paging"`
+}
+
+// QueryFilter is one condition of a SheetQuery. Comparisons are numeric when both sides are
+// numbers and textual otherwise, so ISO dates compare correctly. Array cells match if any element does.
+type QueryFilter struct {
+	Column string `json:"column"`
+	Op     string `json:"op" description:"One of eq, ne, lt, lte, gt, gte, contains (case-insensitive substring)"`
+	Value  string `json:"value"`
+}
+
+// QuerySort orders the rows by a column. Rows without a value sort last.
+type QuerySort struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+// QueryResult holds a page of rows as arrays in Columns order, and how many rows matched in total.
+type QueryResult struct {
+	Key     string          `json:"key"`
+	Columns []string        `json:"columns,omitempty"`
+	Rows    [][]interface{} `json:"rows"`
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset,omitempty"`
+	// More reports whether matching rows remain after this page.
+	More  bool   `json:"more,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// QuerySheet runs q against the data the catalog describes: the current Redis version,
+// or the JSON directory when nothing is published.
+func QuerySheet(ctx context.Context, loader *CatalogLoader, q *SheetQuery) (*QueryResult, error) {
+	sheet, err := loader.ReadSheet(ctx, q.Key)
+	if err != nil {
+		return nil, err
+	}
+	return q.Run(sheet)
+}
+
+// QuerySheetTool runs q and reports failures in the result, so the model can correct its query.
+func QuerySheetTool(ctx context.Context, loader *CatalogLoader, q *SheetQuery) (*QueryResult, error) {
+	res, err := QuerySheet(ctx, loader, q)
+	if err != nil {
+		return &QueryResult{Key: q.Key, Rows: [][]interface{}{}, Error: err.Error()}, nil
+	}
+	return res, nil
+}
+
+// Run executes q against the rows of sheet.
+func (q *SheetQuery) Run(sheet *Sheet) (*QueryResult, error) {
+	matched, columns, err := q.filter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		known[col] = true
+	}
+
+	out := columns
+	if len(q.Columns) > 0 {
+		out = q.Columns
+	}
+	for _, col := range out {
+		if !known[col] {
+			return nil, unknownColumn(col, columns)
+		}
+	}
+	for _, s := range q.Sort {
+		if !known[s.Column] {
+			return nil, unknownColumn(s.Column, columns)
+		}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	limit = min(limit, MaxQueryLimit)
+	if q.Offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative")
+	}
+
+	if len(q.Sort) > 0 {
+		sort.SliceStable(matched, func(i, j int) bool {
+			for _, s := range q.Sort {
+				c := compareCells(matched[i][s.Column], matched[j][s.Column])
+				if c == 0 {
+					continue
+				}
+				// Missing values stay last in both directions.
+				if s.Desc && !isEmptyValue(matched[i][s.Column]) && !isEmptyValue(matched[j][s.Column]) {
+					c = -c
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	res := &QueryResult{Key: q.Key, Columns: out, Rows: [][]interface{}{}, Total: len(matched), Offset: q.Offset}
+	if q.Offset < len(matched) {
+		page := matched[q.Offset:min(q.Offset+limit, len(matched))]
+		for _, row := range page {
+			values := make([]interface{}, len(out))
+			for i, col := range out {
+				values[i] = row[col]
+			}
+			res.Rows = append(res.Rows, values)
+		}
+		res.More = q.Offset+len(page) < len(matched)
+	}
+	return res, nil
+}
+
+// filter returns the rows of sheet that satisfy every condition of q, and the sheet's columns.
+func (q *SheetQuery) filter(sheet *Sheet) ([]map[string]interface{}, []string, error) {
+	columns := sheet.Columns
+	if len(columns) == 0 {
+		columns = rowColumns(sheet.Rows)
+	}
+	for _, f := range q.Where {
+		if !slices.Contains(columns, f.Column) {
+			return nil, nil, unknownColumn(f.Column, columns)
+		}
+		switch f.Op {
+		case OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpContains:
+		default:
+			return nil, nil, fmt.Errorf("unknown operator %q for column %s; use eq, ne, lt, lte, gt, gte or contains", f.Op, f.Column)
+		}
+	}
+
+	matched := make([]map[string]interface{}, 0)
+	for _, row := range sheet.Rows {
+		if q.matches(row) {
+			matched = append(matched, row)
+		}
+	}
+	return matched, columns, nil
+}
+
+func unknownColumn(col string, columns []string) error {
+	return fmt.Errorf("unknown column %q; columns are: %s", col, strings.Join(columns, ", "))
+}
+
+func (q *SheetQuery) matches(row map[string]interface{}) bool {
+	for _, f := range q.Where {
+		v, ok := row[f.Column]
+		if !ok || isEmptyValue(v) {
+			if f.Op != OpNe {
+				return false
+			}
+			continue
+		}
+		// ne must hold for every element of an array cell, the other operators for any element.
+		match := f.Op == OpNe
+		for _, elem := range valueElements(v) {
+			if f.matches(elem) != match {
+				match = !match
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *QueryFilter) matches(v interface{}) bool {
+	switch f.Op {
+	case OpEq:
+		return compareCells(v, f.Value) == 0
+	case OpNe:
+		return compareCells(v, f.Value) != 0
+	case OpLt:
+		return compareCells(v, f.Value) < 0
+	case OpLte:
+		return compareCells(v, f.Value) <= 0
+	case OpGt:
+		return compareCells(v, f.Value) > 0
+	case OpGte:
+		return compareCells(v, f.Value) >= 0
+	case OpContains:
+		return strings.Contains(strings.ToLower(valueKey(v)), strings.ToLower(f.Value))
+	}
+	return false
+}
+
+// compareCells orders two cell values: numerically if both are numbers, as text otherwise,
+// with empty values after everything else.
+func compareCells(a, b interface{}) int {
+	ea, eb := isEmptyValue(a), isEmptyValue(b)
+	switch {
+	case ea && eb:
+		return 0
+	case ea:
+		return 1
+	case eb:
+		return -1
+	}
+	if x, ok := numericValue(a); ok {
+		if y, ok := numericValue(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(valueKey(a), valueKey(b))
+}
+
+// readCurrentSheet reads every row of a sheet in the current version, with its column order.
+func readCurrentSheet(ctx context.Context, rdb *redis.Client, sheetKey string) (*Sheet, error) {
+	key, err := currentKey(ctx, rdb, sheetKey)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if typ == "string" {
+		val, err := rdb.Get(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		sheet, err := decodeJSONSheet([]byte(val))
+		if err != nil {
+			return nil, fmt.Errorf("key '%s' does not hold sheet rows: %w", sheetKey, err)
+		}
+		return sheet, nil
+	}
+
+	rows, ok, err := readRedisSheet(ctx, rdb, key)
+	if err != nil {
+		return nil, fmt.Errorf("key '%s': %w", sheetKey, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("key '%s' not found", sheetKey)
+	}
+	meta, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	sheet := &Sheet{Columns: metaColumns(meta), Rows: rows}
+	if len(sheet.Columns) == 0 {
+		sheet.Columns = rowColumns(rows)
+	}
+	return sheet, nil
+}
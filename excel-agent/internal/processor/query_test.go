@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func unitSheet() *Sheet {
+	return &Sheet{Name: "UnitData", Columns: []string{"ID", "Name", "Grade", "Atk", "Tags"}, Rows: []map[string]interface{}{
+		{"ID": int64(1), "Name": "Knight", "Grade": "SR", "Atk": int64(120), "Tags": []interface{}{"melee", "tank"}},
+		{"ID": int64(2), "Name": "Archer", "Grade": "SSR", "Atk": 95.5, "Tags": []interface{}{"ranged"}},
+		{"ID": int64(3), "Name": "Mage", "Grade": "SSR", "Atk": int64(150)},
+		{"ID": int64(4), "Name": "Squire", "Grade": "R", "Tags": []interface{}{"melee"}},
+		{"ID": int64(5), "Name": "Ranger", "Grade": "SR", "Atk": int64(95), "Tags": []interface{}{"ranged", "scout"}},
+	}}
+}
+
+// ids lists the ID column of a result, which must be its first column.
+func ids(res *QueryResult) string {
+	var out []string
+	for _, row := range res.Rows {
+		out = append(out, fmt.Sprint(row[0]))
+	}
+	return strings.Join(out, ",")
+}
+
+func TestSheetQueryFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		where []QueryFilter
+		want  string
+	}{
+		{"eq text", []QueryFilter{{Column: "Grade", Op: OpEq, Value: "SSR"}}, "2,3"},
+		{"numbers compare numerically", []QueryFilter{{Column: "Atk", Op: OpGte, Value: "100"}}, "1,3"},
+		{"int and float", []QueryFilter{{Column: "Atk", Op: OpLt, Value: "96"}}, "2,5"},
+		{"empty cells never match a comparison", []QueryFilter{{Column: "Atk", Op: OpLte, Value: "1000"}}, "1,2,3,5"},
+		{"ne matches empty cells", []QueryFilter{{Column: "Atk", Op: OpNe, Value: "150"}}, "1,2,4,5"},
+		{"contains ignores case", []QueryFilter{{Column: "Name", Op: OpContains, Value: "AR"}}, "2"},
+		{"any array element", []QueryFilter{{Column: "Tags", Op: OpEq, Value: "melee"}}, "1,4"},
+		{"ne holds for every element", []QueryFilter{{Column: "Tags", Op: OpNe, Value: "scout"}}, "1,2,3,4"},
+		{"all conditions", []QueryFilter{{Column: "Grade", Op: OpEq, Value: "SR"}, {Column: "Tags", Op: OpEq, Value: "ranged"}}, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &SheetQuery{Key: "Character:UnitData", Columns: []string{"ID"}, Where: tt.where}
+			res, err := q.Run(unitSheet())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(res); got != tt.want {
+				t.Errorf("IDs = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSheetQuerySortsAndPages(t *testing.T) {
+	q := &SheetQuery{Key: "Character:UnitData", Columns: []string{"ID", "Atk"}, Sort: []QuerySort{{Column: "Atk", Desc: true}}, Limit: 2}
+	res, err := q.Run(unitSheet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(res); got != "3,1" || res.Total != 5 || !res.More {
+		t.Errorf("page 1 = %s (total %d, more %v), want 3,1 of 5 with more", got, res.Total, res.More)
+	}
+
+	// Rows without a value stay last, also in descending order.
+	q.Offset, q.Limit = 2, 10
+	if res, err = q.Run(unitSheet()); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(res); got != "2,5,4" || res.More {
+		t.Errorf("page 2 = %s (more %v), want 2,5,4 without more", got, res.More)
+	}
+
+	// Ties keep the sheet order, then fall through to the next sort key.
+	q = &SheetQuery{Columns: []string{"ID"}, Sort: []QuerySort{{Column: "Grade"}, {Column: "ID", Desc: true}}}
+	if res, err = q.Run(unitSheet()); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(res); got != "4,5,1,3,2" {
+		t.Errorf("IDs = %s, want 4,5,1,3,2", got)
+	}
+}
+
+func TestSheetQueryLimits(t *testing.T) {
+	sheet := &Sheet{Name: "Big", Columns: []string{"ID"}}
+	for i := 0; i < MaxQueryLimit+50; i++ {
+		sheet.Rows = append(sheet.Rows, map[string]interface{}{"ID": int64(i)})
+	}
+	res, err := (&SheetQuery{}).Run(sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != DefaultQueryLimit {
+		t.Errorf("default page = %d rows, want %d", len(res.Rows), DefaultQueryLimit)
+	}
+	if res, err = (&SheetQuery{Limit: 10000}).Run(sheet); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != MaxQueryLimit || !res.More {
+		t.Errorf("capped page = %d rows (more %v), want %d with more", len(res.Rows), res.More, MaxQueryLimit)
+	}
+	if res, err = (&SheetQuery{Offset: 1000}).Run(sheet); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 0 || res.Total != MaxQueryLimit+50 {
+		t.Errorf("offset past the end = %d rows of %d, want none", len(res.Rows), res.Total)
+	}
+}
+
+func TestSheetQueryRejectsBadQueries(t *testing.T) {
+	tests := []struct {
+		name string
+		q    SheetQuery
+		want string
+	}{
+		{"unknown filter column", SheetQuery{Where: []QueryFilter{{Column: "Hp", Op: OpGt, Value: "1"}}}, `unknown column "Hp"`},
+		{"unknown operator", SheetQuery{Where: []QueryFilter{{Column: "Atk", Op: "between", Value: "1"}}}, `unknown operator "between"`},
+		{"unknown output column", SheetQuery{Columns: []string{"ID", "Def"}}, `unknown column "Def"`},
+		{"unknown sort column", SheetQuery{Sort: []QuerySort{{Column: "Speed"}}}, `unknown column "Speed"`},
+		{"negative offset", SheetQuery{Offset: -1}, "offset must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.q.Run(unitSheet())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestQuerySheetReadsTheCatalogSource checks that queries see the JSON directory until a version
+// is published, and the current version afterwards, like the catalog does.
+func TestQuerySheetReadsTheCatalogSource(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+	edited := unitWorkbook()
+	edited.Sheets[0].Rows[0]["Grade"] = "SR"
+	loader := &CatalogLoader{JsonDir: dir, RedisAddr: mr.Addr()}
+	q := &SheetQuery{Key: "Character:UnitData", Columns: []string{"ID"}, Where: []QueryFilter{{Column: "Grade", Op: OpEq, Value: "SSR"}}}
+
+	writeWorkbooks(t, dir, edited)
+	res, err := QuerySheet(ctx, loader, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(res); got != "1003" {
+		t.Errorf("unpublished ids = %s, want 1003 from the JSON directory", got)
+	}
+
+	// Once published, later edits of the JSON are not seen until they are cached.
+	writeWorkbooks(t, dir, unitWorkbook())
+	if _, err := CacheJSONToRedis(ctx, dir, mr.Addr(), 0, &RedisOptions{Layout: RedisLayoutJSON}); err != nil {
+		t.Fatal(err)
+	}
+	writeWorkbooks(t, dir, edited)
+	if res, err = QuerySheet(ctx, loader, q); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(res); got != "1002,1003" {
+		t.Errorf("published ids = %s, want 1002,1003 from Redis", got)
+	}
+
+	res, err = QuerySheetTool(ctx, loader, &SheetQuery{Key: "Character:Missing"})
+	if err != nil || res.Error == "" {
+		t.Errorf("unknown key: result %+v (%v), want the error in the result", res, err)
+	}
+}
@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,15 +14,71 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Redis storage layouts.
+const (
+	// RedisLayoutJSON stores each sheet as one JSON string under File:Sheet.
+	RedisLayoutJSON = "json"
+	// RedisLayoutHash stores each row as a hash under File:Sheet:<id>; see cacheSheetRows.
+	RedisLayoutHash = "hash"
+)
+
+// RedisOptions controls how sheets are stored in and read from Redis.
+type RedisOptions struct {
+	Layout string
+	// Keys picks the row ID column of the hash layout and of ID lookups.
+	Keys *KeyColumns
+	// Indexes are the columns given a secondary index in the hash layout.
+	Indexes IndexColumns
+	// Keep is how many published versions are retained; the current one is always kept.
+	Keep int
+}
+
+// LoadRedisOptions builds RedisOptions from the layout name, the primary-key
+// settings, an index spec such as "Grade,Item:ItemList=Type" and the number of versions to keep.
+func LoadRedisOptions(layout, primaryKey, primaryKeys, indexSpec string, keep int) (*RedisOptions, error) {
+	switch layout {
+	case "":
+		layout = RedisLayoutJSON
+	case RedisLayoutJSON, RedisLayoutHash:
+	default:
+		return nil, fmt.Errorf("unknown redis layout %q, want %s or %s", layout, RedisLayoutJSON, RedisLayoutHash)
+	}
+	keys, err := ParseKeyColumns(primaryKey, primaryKeys)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := ParseIndexColumns(indexSpec)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 1 {
+		return nil, fmt.Errorf("redis versions to keep must be at least 1, got %d", keep)
+	}
+	return &RedisOptions{Layout: layout, Keys: keys, Indexes: indexes, Keep: keep}, nil
+}
+
+func (o *RedisOptions) layout() string {
+	if o == nil || o.Layout == "" {
+		return RedisLayoutJSON
+	}
+	return o.Layout
+}
+
 type RedisQueryInput struct {
 	Key string `json:"key" description:"The Redis key to query (e.g., 'Arena:ArenaRankingBot')"`
+	// ID, Filter and Limit narrow the result down to matching rows.
+	ID     string            `json:"id,omitempty" description:"Primary key of a single row to return (e.g., '1001')"`
+	Filter map[string]string `json:"filter,omitempty" description:"Only return rows whose columns equal these values (e.g., {\"Grade\": \"SSR\"})"`
+	Limit  int               `json:"limit,omitempty" description:"Maximum number of rows to return; 0 means all"`
 }
 
 type RedisQueryOutput struct {
 	Data string `json:"data"`
 }
 
-func CacheJSONToRedis(ctx context.Context, jsonDir, redisAddr string, redisDB int) error {
+// CacheJSONToRedis publishes every JSON file in jsonDir as a new Redis version and
+// returns its number. Nothing becomes visible unless all files are written.
+func CacheJSONToRedis(ctx context.Context, jsonDir, redisAddr string, redisDB int, opts *RedisOptions) (int64, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: redisAddr,
 		DB:   redisDB,
@@ -30,82 +87,259 @@ func CacheJSONToRedis(ctx context.Context, jsonDir, redisAddr string, redisDB in
 
 	// Check connection
 	if err := rdb.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("failed to connect to redis: %w", err)
+		return 0, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
 	files, err := os.ReadDir(jsonDir)
 	if err != nil {
-		return fmt.Errorf("failed to read json directory: %w", err)
+		return 0, fmt.Errorf("failed to read json directory: %w", err)
 	}
 
-	for _, file := range files {
-		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
-			continue
+	return publishVersion(ctx, rdb, opts, jsonDir, func(pipe redis.Pipeliner, prefix string) error {
+		for _, file := range files {
+			if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+				continue
+			}
+			if err := cacheJSONFile(ctx, pipe, filepath.Join(jsonDir, file.Name()), prefix, opts); err != nil {
+				return fmt.Errorf("failed to cache %s: %w", file.Name(), err)
+			}
 		}
+		return nil
+	})
+}
 
-		filePath := filepath.Join(jsonDir, file.Name())
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			log.Printf("Failed to read %s: %v", file.Name(), err)
-			continue
-		}
+// CacheJSONFileToRedis publishes a new version in which the sheets of a single
+// converted JSON file replace their previous keys; other files are copied from the current version.
+func CacheJSONFileToRedis(ctx context.Context, jsonPath, redisAddr string, redisDB int, opts *RedisOptions) (int64, error) {
+	return CacheJSONFilesToRedis(ctx, []string{jsonPath}, redisAddr, redisDB, opts)
+}
 
-		var jsonRaw map[string]interface{}
-		if err := json.Unmarshal(data, &jsonRaw); err != nil {
-			log.Printf("Failed to parse %s: %v", file.Name(), err)
-			continue
-		}
+// CacheJSONFilesToRedis is CacheJSONFileToRedis for several files, e.g. a subset of the sources manifest.
+func CacheJSONFilesToRedis(ctx context.Context, jsonPaths []string, redisAddr string, redisDB int, opts *RedisOptions) (int64, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer rdb.Close()
 
-		baseName := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return 0, fmt.Errorf("failed to connect to redis: %w", err)
+	}
 
-		for sheetName, content := range jsonRaw {
-			rows, ok := content.([]interface{})
-			if !ok {
-				continue
+	skip := make([]string, len(jsonPaths))
+	names := make([]string, len(jsonPaths))
+	for i, p := range jsonPaths {
+		names[i] = filepath.Base(p)
+		skip[i] = strings.TrimSuffix(names[i], filepath.Ext(names[i])) + ":"
+	}
+	return publishVersion(ctx, rdb, opts, strings.Join(names, ","), func(pipe redis.Pipeliner, prefix string) error {
+		if err := copyCurrentVersion(ctx, rdb, pipe, prefix, skip); err != nil {
+			return err
+		}
+		for _, p := range jsonPaths {
+			if err := cacheJSONFile(ctx, pipe, p, prefix, opts); err != nil {
+				return fmt.Errorf("failed to cache %s: %w", filepath.Base(p), err)
 			}
+		}
+		return nil
+	})
+}
+
+// RemoveJSONFileFromRedis publishes a new version without the sheets of a removed JSON file;
+// every other file is copied from the current version.
+func RemoveJSONFileFromRedis(ctx context.Context, jsonName, redisAddr string, redisDB int, opts *RedisOptions) (int64, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer rdb.Close()
 
-			// Key format: FileName:SheetName
-			key := fmt.Sprintf("%s:%s", baseName, sheetName)
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return 0, fmt.Errorf("failed to connect to redis: %w", err)
+	}
 
-			// Store as JSON string in Redis
-			jsonData, err := json.Marshal(rows[1:])
-			if err != nil {
-				log.Printf("Failed to marshal data for key %s: %v", key, err)
-				continue
-			}
+	skip := []string{strings.TrimSuffix(jsonName, filepath.Ext(jsonName)) + ":"}
+	return publishVersion(ctx, rdb, opts, "-"+jsonName, func(pipe redis.Pipeliner, prefix string) error {
+		return copyCurrentVersion(ctx, rdb, pipe, prefix, skip)
+	})
+}
 
-			if err := rdb.Set(ctx, key, jsonData, 0).Err(); err != nil {
-				log.Printf("Failed to set key %s in redis: %v", key, err)
-				continue
+// cacheJSONFile queues the sheets of one JSON file into pipe, under keys starting with prefix.
+func cacheJSONFile(ctx context.Context, pipe redis.Pipeliner, filePath, prefix string, opts *RedisOptions) error {
+	wb, err := ReadJSONWorkbook(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+
+	for _, sheet := range wb.Sheets {
+		if sheet.Rows == nil {
+			continue
+		}
+
+		// Key format: FileName:SheetName
+		key := fmt.Sprintf("%s:%s", wb.Name, sheet.Name)
+
+		if opts.layout() == RedisLayoutHash {
+			if err := cacheSheetRows(ctx, pipe, prefix+key, wb.Name, sheet, opts); err != nil {
+				return fmt.Errorf("sheet %s: %w", sheet.Name, err)
 			}
-			log.Printf("Cached key to Redis: %s", key)
+			log.Printf("Queued %d rows for Redis: %s", len(sheet.Rows), key)
+			continue
 		}
-	}
 
+		// Store as JSON string in Redis, columns in sheet order
+		jsonData, err := json.Marshal(sheet)
+		if err != nil {
+			return fmt.Errorf("failed to marshal data for key %s: %w", key, err)
+		}
+		pipe.Set(ctx, prefix+key, jsonData, 0)
+		log.Printf("Queued key for Redis: %s", key)
+	}
 	return nil
 }
 
-func GetDataFromRedis(ctx context.Context, key, redisAddr string, redisDB int) (string, error) {
+// GetDataFromRedis returns the sheet, row or filtered rows described by input as JSON,
+// read from the current version. It understands both storage layouts.
+func GetDataFromRedis(ctx context.Context, input *RedisQueryInput, redisAddr string, redisDB int, opts *RedisOptions) (string, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: redisAddr,
 		DB:   redisDB,
 	})
 	defer rdb.Close()
 
+	key, err := currentKey(ctx, rdb, input.Key)
+	if err != nil {
+		return "", err
+	}
+	typ, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	switch typ {
+	case "none":
+		return "", fmt.Errorf("key '%s' not found", input.Key)
+	case "hash":
+		return getRowsFromHash(ctx, rdb, key, input)
+	case "string":
+	default:
+		return "", fmt.Errorf("key '%s' holds an unsupported %s value", input.Key, typ)
+	}
+
 	val, err := rdb.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("key '%s' not found", key)
+		return "", fmt.Errorf("key '%s' not found", input.Key)
 	} else if err != nil {
 		return "", err
 	}
+	if input.ID == "" && len(input.Filter) == 0 && input.Limit <= 0 {
+		return val, nil
+	}
+
+	sheet, err := decodeJSONSheet([]byte(val))
+	if err != nil {
+		return "", fmt.Errorf("key '%s' does not hold sheet rows: %w", input.Key, err)
+	}
+	filter := input.Filter
+	if input.ID != "" {
+		file, sheetName, _ := strings.Cut(input.Key, ":")
+		col, ok := opts.keys().Find(file, sheetName, sheet.Columns)
+		if !ok {
+			return "", fmt.Errorf("sheet '%s' has no primary key column", input.Key)
+		}
+		filter = withFilter(filter, col, input.ID)
+	}
 
-	return val, nil
+	matched := make([]map[string]interface{}, 0)
+	for _, row := range sheet.Rows {
+		if input.Limit > 0 && len(matched) >= input.Limit {
+			break
+		}
+		if rowMatches(row, filter) {
+			matched = append(matched, row)
+		}
+	}
+	if input.ID != "" {
+		if len(matched) == 0 {
+			return "", fmt.Errorf("row '%s' not found in '%s'", input.ID, input.Key)
+		}
+		return marshalRow(matched[0], sheet.Columns)
+	}
+	return marshalString(Sheet{Columns: sheet.Columns, Rows: matched})
 }
 
-func QueryRedisTool(ctx *ai.ToolContext, input *RedisQueryInput, redisAddr string, redisDB int) (*RedisQueryOutput, error) {
-	val, err := GetDataFromRedis(ctx, input.Key, redisAddr, redisDB)
+func QueryRedisTool(ctx *ai.ToolContext, input *RedisQueryInput, redisAddr string, redisDB int, opts *RedisOptions) (*RedisQueryOutput, error) {
+	val, err := GetDataFromRedis(ctx, input, redisAddr, redisDB, opts)
 	if err != nil {
 		return &RedisQueryOutput{Data: err.Error()}, nil
 	}
 	return &RedisQueryOutput{Data: val}, nil
 }
+
+func (o *RedisOptions) keys() *KeyColumns {
+	if o == nil {
+		return nil
+	}
+	return o.Keys
+}
+
+// rowColumns returns every column used by rows.
+func rowColumns(rows []map[string]interface{}) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for col := range row {
+			if !seen[col] {
+				seen[col] = true
+				columns = append(columns, col)
+			}
+		}
+	}
+	return columns
+}
+
+// rowMatches reports whether every filter column equals its value; array cells match on any element.
+func rowMatches(row map[string]interface{}, filter map[string]string) bool {
+	for col, want := range filter {
+		value, ok := row[col]
+		if !ok {
+			return false
+		}
+		match := false
+		for _, elem := range valueElements(value) {
+			if valueKey(elem) == want {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func withFilter(filter map[string]string, col, value string) map[string]string {
+	out := make(map[string]string, len(filter)+1)
+	for k, v := range filter {
+		out[k] = v
+	}
+	out[col] = value
+	return out
+}
+
+func marshalString(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// marshalRow encodes a single row with its keys in columns order.
+func marshalRow(row map[string]interface{}, columns []string) (string, error) {
+	var b bytes.Buffer
+	if err := writeOrderedRow(&b, row, columns); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
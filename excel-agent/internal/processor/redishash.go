@@ -0,0 +1,220 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys of a sheet cached with the hash layout, for File:Sheet:
+//
+//	File:Sheet                  hash of sheet metadata (layout, key, indexes, rows, columns)
+//	File:Sheet:<id>             hash of one row, column -> JSON-encoded value
+//	File:Sheet:ids              sorted set of row IDs, scored by row order
+//	File:Sheet:idx:<Col>:<val>  set of row IDs whose Col equals val
+//
+// All of them live inside a version namespace (see publishVersion).
+// Row IDs come from the primary-key column, or the 1-based row position if the sheet has none.
+
+func rowIDsKey(base string) string  { return base + ":ids" }
+func rowKey(base, id string) string { return base + ":" + id }
+func indexKey(base, col, val string) string {
+	return base + ":idx:" + col + ":" + val
+}
+
+// cacheSheetRows queues the keys of one sheet, stored under base, into pipe.
+func cacheSheetRows(ctx context.Context, pipe redis.Pipeliner, base, file string, sheet *Sheet, opts *RedisOptions) error {
+	records := sheet.Rows
+	keyCol, hasKey := opts.keys().Find(file, sheet.Name, sheet.Columns)
+	var indexed []string
+	if opts != nil {
+		indexed = opts.Indexes.Find(file, sheet.Name, sheet.Columns)
+	}
+	// Hash fields are unordered, so the column order is kept in the metadata.
+	columns, err := json.Marshal(sheet.Columns)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(records))
+	seen := make(map[string]int, len(records))
+	for i, row := range records {
+		id := strconv.Itoa(i + 1)
+		if hasKey {
+			v, ok := row[keyCol]
+			if !ok || isEmptyValue(v) {
+				return fmt.Errorf("row %d has no %s", i+1, keyCol)
+			}
+			id = valueKey(v)
+		}
+		// "ids" and "idx:..." would collide with the ID list and the index keys.
+		if id == "ids" || id == "idx" || strings.HasPrefix(id, "idx:") {
+			return fmt.Errorf("row %d: ID %q is reserved", i+1, id)
+		}
+		if first, dup := seen[id]; dup {
+			return fmt.Errorf("duplicate %s %s in rows %d and %d", keyCol, id, first+1, i+1)
+		}
+		seen[id] = i
+		ids[i] = id
+	}
+
+	pipe.HSet(ctx, base, "layout", RedisLayoutHash, "key", keyCol, "indexes", strings.Join(indexed, ","), "rows", len(records), "columns", string(columns))
+
+	for i, row := range records {
+		id := ids[i]
+		fields := make([]interface{}, 0, 2*len(row))
+		for col, v := range row {
+			enc, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("row %s, column %s: %w", id, col, err)
+			}
+			fields = append(fields, col, string(enc))
+		}
+		if len(fields) > 0 {
+			pipe.HSet(ctx, rowKey(base, id), fields...)
+		}
+		pipe.ZAdd(ctx, rowIDsKey(base), redis.Z{Score: float64(i), Member: id})
+
+		for _, col := range indexed {
+			v, ok := row[col]
+			if !ok {
+				continue
+			}
+			for _, elem := range valueElements(v) {
+				if isEmptyValue(elem) {
+					continue
+				}
+				pipe.SAdd(ctx, indexKey(base, col, valueKey(elem)), id)
+			}
+		}
+	}
+	return nil
+}
+
+// getRowsFromHash answers input from a sheet, or a single row key, stored with the hash layout under key.
+func getRowsFromHash(ctx context.Context, rdb *redis.Client, key string, input *RedisQueryInput) (string, error) {
+	meta, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	if meta["layout"] != RedisLayoutHash {
+		// A row key such as File:Sheet:1001.
+		return marshalString(decodeRow(meta))
+	}
+
+	if input.ID != "" {
+		fields, err := rdb.HGetAll(ctx, rowKey(key, input.ID)).Result()
+		if err != nil {
+			return "", err
+		}
+		if len(fields) == 0 {
+			return "", fmt.Errorf("row '%s' not found in '%s'", input.ID, input.Key)
+		}
+		return marshalRow(decodeRow(fields), metaColumns(meta))
+	}
+
+	ids, err := rdb.ZRange(ctx, rowIDsKey(key), 0, -1).Result()
+	if err != nil {
+		return "", err
+	}
+
+	// Indexed columns narrow the candidates in Redis; the rest are checked row by row.
+	indexed := make(map[string]bool)
+	for _, col := range strings.Split(meta["indexes"], ",") {
+		if col != "" {
+			indexed[col] = true
+		}
+	}
+	var idxKeys []string
+	rest := make(map[string]string)
+	for col, want := range input.Filter {
+		if indexed[col] {
+			idxKeys = append(idxKeys, indexKey(key, col, want))
+		} else {
+			rest[col] = want
+		}
+	}
+	if len(idxKeys) > 0 {
+		members, err := rdb.SInter(ctx, idxKeys...).Result()
+		if err != nil {
+			return "", err
+		}
+		keep := make(map[string]bool, len(members))
+		for _, m := range members {
+			keep[m] = true
+		}
+		filtered := ids[:0]
+		for _, id := range ids {
+			if keep[id] {
+				filtered = append(filtered, id)
+			}
+		}
+		ids = filtered
+	}
+	if len(rest) == 0 && input.Limit > 0 && len(ids) > input.Limit {
+		ids = ids[:input.Limit]
+	}
+
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, rowKey(key, id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	rows := make([]map[string]interface{}, 0, len(ids))
+	for _, cmd := range cmds {
+		if input.Limit > 0 && len(rows) >= input.Limit {
+			break
+		}
+		row := decodeRow(cmd.Val())
+		if rowMatches(row, rest) {
+			rows = append(rows, row)
+		}
+	}
+	return marshalString(Sheet{Columns: metaColumns(meta), Rows: rows})
+}
+
+// metaColumns returns the column order recorded in a sheet's metadata; versions cached
+// before it was recorded have none, and their rows fall back to sorted keys.
+func metaColumns(meta map[string]string) []string {
+	var columns []string
+	if enc := meta["columns"]; enc != "" {
+		_ = json.Unmarshal([]byte(enc), &columns)
+	}
+	return columns
+}
+
+// decodeRow turns a row hash back into column values; fields that are not JSON are kept as strings.
+func decodeRow(fields map[string]string) map[string]interface{} {
+	row := make(map[string]interface{}, len(fields))
+	for col, enc := range fields {
+		var v interface{}
+		if err := json.Unmarshal([]byte(enc), &v); err != nil {
+			v = enc
+		}
+		row[col] = v
+	}
+	return row
+}
+
+// decodeTypedRow is decodeRow keeping integral numbers as int64, like ReadJSONWorkbook.
+func decodeTypedRow(fields map[string]string) map[string]interface{} {
+	row := make(map[string]interface{}, len(fields))
+	for col, enc := range fields {
+		dec := json.NewDecoder(strings.NewReader(enc))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			v = enc
+		}
+		row[col] = fromJSONNumber(v)
+	}
+	return row
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// writeWorkbooks writes each workbook as <dir>/<Name>.json, the way the converter does.
+func writeWorkbooks(t *testing.T, dir string, wbs ...*Workbook) {
+	t.Helper()
+	for _, wb := range wbs {
+		data, err := json.Marshal(wb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, wb.Name+".json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func unitWorkbook() *Workbook {
+	return &Workbook{Name: "Character", Sheets: []*Sheet{{
+		Name:    "UnitData",
+		Columns: []string{"ID", "Name", "Grade", "Tags"},
+		Rows: []map[string]interface{}{
+			{"ID": int64(1002), "Name": "Archer", "Grade": "SSR", "Tags": []interface{}{"ranged"}},
+			{"ID": int64(1001), "Name": "Knight", "Grade": "SR", "Tags": []interface{}{"melee", "tank"}},
+			{"ID": int64(1003), "Name": "Mage", "Grade": "SSR"},
+		},
+	}}}
+}
+
+func hashOptions(t *testing.T, indexes string) *RedisOptions {
+	t.Helper()
+	opts, err := LoadRedisOptions(RedisLayoutHash, "ID", "", indexes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestCacheHashLayoutKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+	writeWorkbooks(t, dir, unitWorkbook())
+
+	n, err := CacheJSONToRedis(context.Background(), dir, mr.Addr(), 0, hashOptions(t, "Grade,Tags"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := versionPrefix(n) + "Character:UnitData"
+
+	meta := map[string]string{"layout": RedisLayoutHash, "key": "ID", "indexes": "Grade,Tags", "rows": "3", "columns": `["ID","Name","Grade","Tags"]`}
+	for field, want := range meta {
+		if got := mr.HGet(base, field); got != want {
+			t.Errorf("metadata %s = %q, want %q", field, got, want)
+		}
+	}
+	if got := mr.HGet(base+":1001", "Tags"); got != `["melee","tank"]` {
+		t.Errorf("row field = %q, want JSON-encoded array", got)
+	}
+	ids, err := mr.ZMembers(base + ":ids")
+	if err != nil || !reflect.DeepEqual(ids, []string{"1002", "1001", "1003"}) {
+		t.Errorf("ids = %v (%v), want sheet order", ids, err)
+	}
+	for key, want := range map[string][]string{
+		base + ":idx:Grade:SSR": {"1002", "1003"},
+		base + ":idx:Grade:SR":  {"1001"},
+		base + ":idx:Tags:tank": {"1001"},
+	} {
+		if got, err := mr.Members(key); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v (%v), want %v", key, got, err, want)
+		}
+	}
+}
+
+func TestGetDataFromHashLayout(t *testing.T) {
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+	writeWorkbooks(t, dir, unitWorkbook())
+	opts := hashOptions(t, "Grade")
+	if _, err := CacheJSONToRedis(context.Background(), dir, mr.Addr(), 0, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input RedisQueryInput
+		want  string
+	}{
+		{"row by ID", RedisQueryInput{Key: "Character:UnitData", ID: "1001"}, `{"ID":1001,"Name":"Knight","Grade":"SR","Tags":["melee","tank"]}`},
+		{"indexed filter", RedisQueryInput{Key: "Character:UnitData", Filter: map[string]string{"Grade": "SSR"}}, `"Name":"Archer"`},
+		{"indexed and plain filter", RedisQueryInput{Key: "Character:UnitData", Filter: map[string]string{"Grade": "SSR", "Name": "Mage"}}, `[{"ID":1003,"Name":"Mage","Grade":"SSR"}]`},
+		{"limit", RedisQueryInput{Key: "Character:UnitData", Limit: 1}, `[{"ID":1002,`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetDataFromRedis(context.Background(), &tt.input, mr.Addr(), 0, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("got %s, want it to contain %s", got, tt.want)
+			}
+		})
+	}
+	if _, err := GetDataFromRedis(context.Background(), &RedisQueryInput{Key: "Character:UnitData", ID: "9999"}, mr.Addr(), 0, opts); err == nil {
+		t.Error("expected an error for a missing row")
+	}
+}
+
+func TestCacheHashLayoutRejectsBadIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []interface{}
+		want string
+	}{
+		{"ID list key", []interface{}{"ids"}, `ID "ids" is reserved`},
+		{"index prefix", []interface{}{"idx"}, `ID "idx" is reserved`},
+		{"index key", []interface{}{"idx:Grade:SSR"}, `ID "idx:Grade:SSR" is reserved`},
+		{"duplicate", []interface{}{int64(1), int64(1)}, "duplicate ID 1 in rows 1 and 2"},
+		{"missing", []interface{}{int64(1), nil}, "row 2 has no ID"},
+		{"allowed", []interface{}{"index", "idx_1", "a:ids"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			dir := t.TempDir()
+			sheet := &Sheet{Name: "UnitData", Columns: []string{"ID", "Grade"}}
+			for _, id := range tt.ids {
+				row := map[string]interface{}{"Grade": "SR"}
+				if id != nil {
+					row["ID"] = id
+				}
+				sheet.Rows = append(sheet.Rows, row)
+			}
+			writeWorkbooks(t, dir, &Workbook{Name: "Character", Sheets: []*Sheet{sheet}})
+
+			_, err := CacheJSONToRedis(context.Background(), dir, mr.Addr(), 0, hashOptions(t, "Grade"))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
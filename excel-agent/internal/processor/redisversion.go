@@ -0,0 +1,446 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every cache run writes its sheets under a fresh "excel-agent:v<N>:" namespace and
+// then points the current key at N, so readers switch from one complete version to
+// the next in a single step. All keys live under redisKeyPrefix, so they neither clash
+// with other applications sharing the database nor with a source named "versions".
+const (
+	redisKeyPrefix = "excel-agent:"
+	// redisCurrentKey holds the number of the version readers should use.
+	redisCurrentKey = redisKeyPrefix + "current"
+	// redisVersionsKey is a sorted set of the published version numbers.
+	redisVersionsKey = redisKeyPrefix + "versions"
+	// redisVersionSeqKey is the counter new version numbers are taken from.
+	redisVersionSeqKey = redisKeyPrefix + "versions:seq"
+)
+
+func versionPrefix(n int64) string  { return fmt.Sprintf("%sv%d:", redisKeyPrefix, n) }
+func versionMetaKey(n int64) string { return fmt.Sprintf("%sversions:%d", redisKeyPrefix, n) }
+
+// RedisVersion describes one published version.
+type RedisVersion struct {
+	Version int64  `json:"version"`
+	Created string `json:"created"`
+	Source  string `json:"source"`
+	Layout  string `json:"layout"`
+	Current bool   `json:"current"`
+}
+
+// VersionDiff lists the keys that differ between two versions, without their version prefix.
+type VersionDiff struct {
+	From    int64    `json:"from"`
+	To      int64    `json:"to"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+func (d *VersionDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Version %d -> %d: %d added, %d removed, %d changed",
+		d.From, d.To, len(d.Added), len(d.Removed), len(d.Changed))
+	for _, k := range d.Added {
+		fmt.Fprintf(&b, "\n  + %s", k)
+	}
+	for _, k := range d.Removed {
+		fmt.Fprintf(&b, "\n  - %s", k)
+	}
+	for _, k := range d.Changed {
+		fmt.Fprintf(&b, "\n  ~ %s", k)
+	}
+	return b.String()
+}
+
+// publishVersion lets write queue a complete data set under a new version prefix,
+// sends it in one pipeline and then switches the current pointer to it.
+// A failed write leaves the current version untouched and removes the partial one.
+func publishVersion(ctx context.Context, rdb *redis.Client, opts *RedisOptions, source string, write func(pipe redis.Pipeliner, prefix string) error) (int64, error) {
+	n, err := rdb.Incr(ctx, redisVersionSeqKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate version: %w", err)
+	}
+
+	pipe := rdb.Pipeline()
+	if err := write(pipe, versionPrefix(n)); err != nil {
+		return 0, err
+	}
+	pipe.HSet(ctx, versionMetaKey(n),
+		"created", time.Now().UTC().Format(time.RFC3339),
+		"source", source,
+		"layout", opts.layout(),
+	)
+	pipe.ZAdd(ctx, redisVersionsKey, redis.Z{Score: float64(n), Member: n})
+	if _, err := pipe.Exec(ctx); err != nil {
+		if derr := deleteVersion(ctx, rdb, n); derr != nil {
+			log.Printf("Failed to remove partial version %d: %v", n, derr)
+		}
+		return 0, fmt.Errorf("failed to write version %d: %w", n, err)
+	}
+
+	if err := rdb.Set(ctx, redisCurrentKey, n, 0).Err(); err != nil {
+		if derr := deleteVersion(ctx, rdb, n); derr != nil {
+			log.Printf("Failed to remove unpublished version %d: %v", n, derr)
+		}
+		return 0, fmt.Errorf("failed to publish version %d: %w", n, err)
+	}
+	log.Printf("Published Redis version %d", n)
+
+	keep := 1
+	if opts != nil && opts.Keep > 0 {
+		keep = opts.Keep
+	}
+	if err := pruneVersions(ctx, rdb, keep, n); err != nil {
+		log.Printf("Failed to prune old versions: %v", err)
+	}
+	return n, nil
+}
+
+// copyCurrentVersion queues copies of the current version's keys into prefix,
+// except those starting with skip.
+func copyCurrentVersion(ctx context.Context, rdb *redis.Client, pipe redis.Pipeliner, prefix string, skip []string) error {
+	cur, ok, err := currentVersion(ctx, rdb)
+	if err != nil || !ok {
+		return err
+	}
+	from := versionPrefix(cur)
+	keys, err := scanKeys(ctx, rdb, from)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, from)
+		if hasAnyPrefix(name, skip) {
+			continue
+		}
+		pipe.Copy(ctx, key, prefix+name, rdb.Options().DB, true)
+	}
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentVersion returns the version the current key points at.
+func currentVersion(ctx context.Context, rdb *redis.Client) (int64, bool, error) {
+	val, err := rdb.Get(ctx, redisCurrentKey).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid current version %q", val)
+	}
+	return n, true, nil
+}
+
+// currentKey maps a data key to its name in the current version.
+// Data cached before versioning was introduced is read as is.
+func currentKey(ctx context.Context, rdb *redis.Client, key string) (string, error) {
+	cur, ok, err := currentVersion(ctx, rdb)
+	if err != nil || !ok {
+		return key, err
+	}
+	return versionPrefix(cur) + key, nil
+}
+
+func scanKeys(ctx context.Context, rdb *redis.Client, prefix string) ([]string, error) {
+	var keys []string
+	iter := rdb.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// deleteVersion removes every key of version n together with its metadata.
+func deleteVersion(ctx context.Context, rdb *redis.Client, n int64) error {
+	keys, err := scanKeys(ctx, rdb, versionPrefix(n))
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += 500 {
+		end := min(start+500, len(keys))
+		if err := rdb.Unlink(ctx, keys[start:end]...).Err(); err != nil {
+			return err
+		}
+	}
+	if err := rdb.Del(ctx, versionMetaKey(n)).Err(); err != nil {
+		return err
+	}
+	return rdb.ZRem(ctx, redisVersionsKey, n).Err()
+}
+
+// pruneVersions deletes all but the newest keep versions, never touching current.
+func pruneVersions(ctx context.Context, rdb *redis.Client, keep int, current int64) error {
+	versions, err := versionNumbers(ctx, rdb)
+	if err != nil {
+		return err
+	}
+	for i := len(versions) - keep - 1; i >= 0; i-- {
+		if versions[i] == current {
+			continue
+		}
+		if err := deleteVersion(ctx, rdb, versions[i]); err != nil {
+			return err
+		}
+		log.Printf("Deleted Redis version %d", versions[i])
+	}
+	return nil
+}
+
+// versionNumbers returns the published versions in ascending order.
+func versionNumbers(ctx context.Context, rdb *redis.Client) ([]int64, error) {
+	members, err := rdb.ZRange(ctx, redisVersionsKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(members))
+	for _, m := range members {
+		n, err := strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, n)
+	}
+	return versions, nil
+}
+
+// ListRedisVersions returns the retained versions, oldest first.
+func ListRedisVersions(ctx context.Context, redisAddr string, redisDB int) ([]RedisVersion, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer rdb.Close()
+
+	versions, err := versionNumbers(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+	cur, _, err := currentVersion(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(versions))
+	for i, n := range versions {
+		cmds[i] = pipe.HGetAll(ctx, versionMetaKey(n))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	out := make([]RedisVersion, len(versions))
+	for i, n := range versions {
+		meta := cmds[i].Val()
+		out[i] = RedisVersion{
+			Version: n,
+			Created: meta["created"],
+			Source:  meta["source"],
+			Layout:  meta["layout"],
+			Current: n == cur,
+		}
+	}
+	return out, nil
+}
+
+// RollbackRedis points the current key at version, or at the version before
+// the current one if version is 0. It returns the version now current.
+func RollbackRedis(ctx context.Context, redisAddr string, redisDB int, version int64) (int64, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer rdb.Close()
+
+	versions, err := versionNumbers(ctx, rdb)
+	if err != nil {
+		return 0, err
+	}
+	cur, ok, err := currentVersion(ctx, rdb)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		if !ok {
+			return 0, fmt.Errorf("no version has been published")
+		}
+		prev, found := previousVersion(versions, cur)
+		if !found {
+			return 0, fmt.Errorf("no version older than %d is retained", cur)
+		}
+		version = prev
+	} else if !containsVersion(versions, version) {
+		return 0, fmt.Errorf("version %d is not retained", version)
+	}
+
+	if err := rdb.Set(ctx, redisCurrentKey, version, 0).Err(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// DiffRedisVersions compares two versions key by key. A zero to means the
+// current version and a zero from the version before to.
+func DiffRedisVersions(ctx context.Context, redisAddr string, redisDB int, from, to int64) (*VersionDiff, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer rdb.Close()
+
+	versions, err := versionNumbers(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		cur, ok, err := currentVersion(ctx, rdb)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("no version has been published")
+		}
+		to = cur
+	}
+	if from == 0 {
+		prev, found := previousVersion(versions, to)
+		if !found {
+			return nil, fmt.Errorf("no version older than %d is retained", to)
+		}
+		from = prev
+	}
+	for _, n := range []int64{from, to} {
+		if !containsVersion(versions, n) {
+			return nil, fmt.Errorf("version %d is not retained", n)
+		}
+	}
+
+	old, err := versionValues(ctx, rdb, from)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := versionValues(ctx, rdb, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &VersionDiff{From: from, To: to}
+	for key, v := range cur {
+		if prev, ok := old[key]; !ok {
+			diff.Added = append(diff.Added, key)
+		} else if prev != v {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := cur[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff, nil
+}
+
+// versionValues reads every key of version n into a comparable string, keyed by its unprefixed name.
+func versionValues(ctx context.Context, rdb *redis.Client, n int64) (map[string]string, error) {
+	prefix := versionPrefix(n)
+	keys, err := scanKeys(ctx, rdb, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := rdb.Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	for i, key := range keys {
+		types[i] = pipe.Type(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	pipe = rdb.Pipeline()
+	reads := make([]redis.Cmder, len(keys))
+	for i, key := range keys {
+		switch types[i].Val() {
+		case "string":
+			reads[i] = pipe.Get(ctx, key)
+		case "hash":
+			reads[i] = pipe.HGetAll(ctx, key)
+		case "set":
+			reads[i] = pipe.SMembers(ctx, key)
+		case "zset":
+			reads[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+		default:
+			return nil, fmt.Errorf("key %s holds an unsupported %s value", key, types[i].Val())
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(keys))
+	for i, key := range keys {
+		var v interface{}
+		switch cmd := reads[i].(type) {
+		case *redis.StringCmd:
+			v = cmd.Val()
+		case *redis.MapStringStringCmd:
+			v = cmd.Val() // maps marshal with sorted keys
+		case *redis.StringSliceCmd:
+			members := cmd.Val()
+			sort.Strings(members)
+			v = members
+		case *redis.ZSliceCmd:
+			v = cmd.Val()
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		values[strings.TrimPrefix(key, prefix)] = string(data)
+	}
+	return values, nil
+}
+
+func previousVersion(versions []int64, n int64) (int64, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i] < n {
+			return versions[i], true
+		}
+	}
+	return 0, false
+}
+
+func containsVersion(versions []int64, n int64) bool {
+	for _, v := range versions {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func itemWorkbook(price int64) *Workbook {
+	return &Workbook{Name: "Item", Sheets: []*Sheet{{
+		Name:    "ItemList",
+		Columns: []string{"ID", "Price"},
+		Rows:    []map[string]interface{}{{"ID": int64(1), "Price": price}},
+	}}}
+}
+
+func jsonOptions(keep int) *RedisOptions {
+	return &RedisOptions{Layout: RedisLayoutJSON, Keep: keep}
+}
+
+func versionList(t *testing.T, addr string) []int64 {
+	t.Helper()
+	versions, err := ListRedisVersions(context.Background(), addr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []int64
+	for _, v := range versions {
+		out = append(out, v.Version)
+	}
+	return out
+}
+
+func TestPublishPrunesOldVersions(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+
+	for price := int64(100); price <= 300; price += 100 {
+		writeWorkbooks(t, dir, itemWorkbook(price))
+		if _, err := CacheJSONToRedis(ctx, dir, mr.Addr(), 0, jsonOptions(2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := versionList(t, mr.Addr()); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("versions = %v, want [2 3]", got)
+	}
+	if mr.Exists(versionPrefix(1)+"Item:ItemList") || mr.Exists(versionMetaKey(1)) {
+		t.Error("version 1 should be deleted")
+	}
+	if got, _ := mr.Get(redisCurrentKey); got != "3" {
+		t.Errorf("current = %q, want 3", got)
+	}
+	for _, key := range mr.Keys() {
+		if !strings.HasPrefix(key, redisKeyPrefix) {
+			t.Errorf("key %s is outside %s", key, redisKeyPrefix)
+		}
+	}
+	out, err := GetDataFromRedis(ctx, &RedisQueryInput{Key: "Item:ItemList"}, mr.Addr(), 0, jsonOptions(2))
+	if err != nil || out != `[{"ID":1,"Price":300}]` {
+		t.Errorf("current data = %s (%v), want price 300", out, err)
+	}
+}
+
+func TestCacheSingleFileCopiesTheOthers(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+	writeWorkbooks(t, dir, itemWorkbook(100), unitWorkbook())
+	if _, err := CacheJSONToRedis(ctx, dir, mr.Addr(), 0, jsonOptions(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	writeWorkbooks(t, dir, itemWorkbook(150))
+	n, err := CacheJSONFileToRedis(ctx, filepath.Join(dir, "Item.json"), mr.Addr(), 0, jsonOptions(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := DiffRedisVersions(ctx, mr.Addr(), 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.From != n-1 || diff.To != n || !reflect.DeepEqual(diff.Changed, []string{"Item:ItemList"}) || len(diff.Added)+len(diff.Removed) != 0 {
+		t.Errorf("diff = %s, want only Item:ItemList changed", diff)
+	}
+
+	// Forgetting a workbook publishes a version without its keys.
+	if err := os.Remove(filepath.Join(dir, "Item.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveJSONFileFromRedis(ctx, "Item.json", mr.Addr(), 0, jsonOptions(5)); err != nil {
+		t.Fatal(err)
+	}
+	if diff, err = DiffRedisVersions(ctx, mr.Addr(), 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diff.Removed, []string{"Item:ItemList"}) || len(diff.Added)+len(diff.Changed) != 0 {
+		t.Errorf("diff = %s, want only Item:ItemList removed", diff)
+	}
+}
+
+func TestRollbackRedis(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	if _, err := RollbackRedis(ctx, mr.Addr(), 0, 0); err == nil || !strings.Contains(err.Error(), "no version has been published") {
+		t.Errorf("rollback before publishing: err = %v", err)
+	}
+
+	dir := t.TempDir()
+	for price := int64(100); price <= 300; price += 100 {
+		writeWorkbooks(t, dir, itemWorkbook(price))
+		if _, err := CacheJSONToRedis(ctx, dir, mr.Addr(), 0, jsonOptions(3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		version int64
+		want    int64
+		wantErr string
+	}{
+		{"previous", 0, 2, ""},
+		{"previous again", 0, 1, ""},
+		{"nothing older", 0, 0, "no version older than 1 is retained"},
+		{"explicit", 3, 3, ""},
+		{"not retained", 7, 0, "version 7 is not retained"},
+	}
+	for _, tt := range tests {
+		got, err := RollbackRedis(ctx, mr.Addr(), 0, tt.version)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: rollback = %d (%v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+
+	// A rollback only moves the current pointer, so every version stays listed.
+	if _, err := RollbackRedis(ctx, mr.Addr(), 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	out, err := GetDataFromRedis(ctx, &RedisQueryInput{Key: "Item:ItemList"}, mr.Addr(), 0, jsonOptions(3))
+	if err != nil || out != `[{"ID":1,"Price":100}]` {
+		t.Errorf("data after rollback = %s (%v), want price 100", out, err)
+	}
+	versions, err := ListRedisVersions(ctx, mr.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range versions {
+		if v.Current != (v.Version == 1) || v.Source != dir || v.Layout != RedisLayoutJSON {
+			t.Errorf("version %+v, want v1 current with source %s", v, dir)
+		}
+	}
+}
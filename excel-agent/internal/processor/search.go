@@ -0,0 +1,448 @@
+package processor
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Result limits of searchRows.
+const (
+	DefaultSearchLimit = 10
+	MaxSearchLimit     = 50
+)
+
+const (
+	// searchEmbedBatch is the number of texts sent per embedding request.
+	searchEmbedBatch = 100
+	// searchTextRunes bounds the cell text returned with a hit.
+	searchTextRunes = 200
+)
+
+// SearchOptions selects the text columns embedded for semantic search and where the index is kept.
+type SearchOptions struct {
+	// Columns are the embedded columns per sheet, e.g. "Dialogue:Lines=Text,Quest=Description".
+	Columns IndexColumns
+	// IndexFile holds the vectors. It is rewritten by every indexing run.
+	IndexFile string
+	// Layout maps converted rows back to spreadsheet row numbers.
+	Layout *SheetLayout
+}
+
+// LoadSearchOptions builds SearchOptions from a column spec in the REDIS_INDEXES format and an
+// optional sheet layout file. An empty spec disables semantic search.
+func LoadSearchOptions(columnsSpec, indexFile, layoutFile string) (*SearchOptions, error) {
+	columns, err := ParseIndexColumns(columnsSpec)
+	if err != nil {
+		return nil, err
+	}
+	opts := &SearchOptions{Columns: columns, IndexFile: indexFile, Layout: DefaultSheetLayout()}
+	if layoutFile != "" {
+		if opts.Layout, err = LoadSheetLayout(layoutFile); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// Enabled reports whether any column is configured for semantic search.
+func (o *SearchOptions) Enabled() bool {
+	return o != nil && len(o.Columns) > 0
+}
+
+// SearchIndex is the content of the index file: one vector per non-empty indexed cell.
+type SearchIndex struct {
+	// Embedder names the embedder the vectors were made with; they are not comparable across embedders.
+	Embedder string       `json:"embedder"`
+	Source   string       `json:"source"`
+	Cells    []SearchCell `json:"cells"`
+}
+
+// SearchCell is one embedded cell and where it comes from.
+type SearchCell struct {
+	Key string `json:"key"`
+	// ID is the row's primary key, or its 1-based position if the sheet has none.
+	ID string `json:"id"`
+	// Index is the 0-based position of the row in the converted sheet.
+	Index int `json:"index"`
+	// Row is the 1-based row number in the spreadsheet.
+	Row    int       `json:"row"`
+	Column string    `json:"column"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+// SearchIndexReport summarizes an indexing run.
+type SearchIndexReport struct {
+	File   string   `json:"file"`
+	Source string   `json:"source"`
+	Sheets []string `json:"sheets"`
+	Cells  int      `json:"cells"`
+	// Embedded counts the texts sent to the embedder; Reused the cells whose text already had a vector.
+	Embedded int `json:"embedded"`
+	Reused   int `json:"reused"`
+}
+
+func (r *SearchIndexReport) String() string {
+	return fmt.Sprintf("Indexed %d cells of %d sheets from %s into %s (embedded: %d, reused: %d)",
+		r.Cells, len(r.Sheets), r.Source, r.File, r.Embedded, r.Reused)
+}
+
+// SearchRowsInput is the input of the searchRows tool.
+type SearchRowsInput struct {
+	Query string `json:"query" description:"What to look for, in natural language (e.g., 'lines about the dragon boss')"`
+	Key   string `json:"key,omitempty" description:"Only search the sheet with this 'FileName:SheetName' key"`
+	Limit int    `json:"limit,omitempty" description:"Maximum number of rows to return (default 10, at most 50)"`
+}
+
+// SearchResult lists the rows most similar to a query, best first. Error explains a failed search.
+type SearchResult struct {
+	Query string      `json:"query"`
+	Hits  []SearchHit `json:"hits"`
+	Error string      `json:"error,omitempty"`
+}
+
+// SearchHit is a matching row: its sheet key, row ID, the spreadsheet row and column of the
+// matching cell, the cosine similarity and the (shortened) cell text.
+type SearchHit struct {
+	Key    string  `json:"key"`
+	ID     string  `json:"id"`
+	Row    int     `json:"row"`
+	Column string  `json:"column"`
+	Score  float64 `json:"score"`
+	Text   string  `json:"text"`
+	index  int
+}
+
+// RowSearcher builds the search index and answers queries against it. EmbedderName identifies
+// Embedder in the index file (e.g. "ollama/nomic-embed-text"), so an index made with another
+// embedder is rejected instead of returning meaningless scores.
+type RowSearcher struct {
+	Embedder     ai.Embedder
+	EmbedderName string
+	Options      *SearchOptions
+	Catalog      *CatalogLoader
+
+	mu      sync.Mutex
+	index   *SearchIndex
+	modTime time.Time
+}
+
+// BuildIndex embeds the configured text columns of every sheet the catalog sees and writes
+// the index file. Vectors of texts that are already indexed with the same embedder are reused.
+func (s *RowSearcher) BuildIndex(ctx context.Context) (*SearchIndexReport, error) {
+	if !s.Options.Enabled() {
+		return nil, fmt.Errorf("no columns configured for semantic search (set SEARCH_COLUMNS)")
+	}
+	c, err := s.Catalog.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reuse := make(map[string][]float32)
+	if old, err := readSearchIndex(s.Options.IndexFile); err == nil && old.Embedder == s.EmbedderName {
+		for _, cell := range old.Cells {
+			reuse[cell.Text] = cell.Vector
+		}
+	}
+
+	idx := &SearchIndex{Embedder: s.EmbedderName, Source: c.Source, Cells: []SearchCell{}}
+	report := &SearchIndexReport{File: s.Options.IndexFile, Source: c.Source, Sheets: []string{}}
+	waiting := make(map[string][]int)
+	var pending []string
+	for _, f := range c.Files {
+		for _, cs := range f.Sheets {
+			names := make([]string, len(cs.Columns))
+			for i, col := range cs.Columns {
+				names[i] = col.Name
+			}
+			cols := s.Options.Columns.Find(cs.File, cs.Name, names)
+			if len(cols) == 0 {
+				continue
+			}
+			sheet, err := s.Catalog.ReadSheet(ctx, cs.Key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", cs.Key, err)
+			}
+			report.Sheets = append(report.Sheets, cs.Key)
+			for i, row := range sheet.Rows {
+				id := rowID(row, cs.PrimaryKey, i)
+				for _, col := range cols {
+					text := cellText(row[col])
+					if text == "" {
+						continue
+					}
+					cell := SearchCell{Key: cs.Key, ID: id, Index: i, Row: s.Options.Layout.sheetRow(i), Column: col, Text: text}
+					if v, ok := reuse[text]; ok {
+						cell.Vector = v
+						report.Reused++
+					} else {
+						if _, ok := waiting[text]; !ok {
+							pending = append(pending, text)
+						}
+						waiting[text] = append(waiting[text], len(idx.Cells))
+					}
+					idx.Cells = append(idx.Cells, cell)
+				}
+			}
+		}
+	}
+
+	for start := 0; start < len(pending); start += searchEmbedBatch {
+		batch := pending[start:min(start+searchEmbedBatch, len(pending))]
+		vectors, err := s.embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range batch {
+			for _, n := range waiting[text] {
+				idx.Cells[n].Vector = vectors[i]
+			}
+		}
+		report.Embedded += len(batch)
+	}
+	report.Cells = len(idx.Cells)
+
+	if err := writeSearchIndex(s.Options.IndexFile, idx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.index, s.modTime = nil, time.Time{}
+	s.mu.Unlock()
+	return report, nil
+}
+
+// Search returns the rows whose indexed cells are most similar to query, optionally within one sheet.
+// A row matching in several columns is reported once, with its best cell.
+func (s *RowSearcher) Search(ctx context.Context, query, key string, limit int) (*SearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("query must not be empty")
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	limit = min(limit, MaxSearchLimit)
+
+	idx, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if idx.Embedder != s.EmbedderName {
+		return nil, fmt.Errorf("search index was built with %s, but the embedder is %s; rebuild it with -cmd index", idx.Embedder, s.EmbedderName)
+	}
+	vectors, err := s.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	best := make(map[string]*SearchHit)
+	for _, cell := range idx.Cells {
+		if key != "" && !strings.EqualFold(cell.Key, key) {
+			continue
+		}
+		score := cosineSimilarity(vectors[0], cell.Vector)
+		id := cell.Key + "\x00" + strconv.Itoa(cell.Index)
+		if hit, ok := best[id]; ok && hit.Score >= score {
+			continue
+		}
+		best[id] = &SearchHit{Key: cell.Key, ID: cell.ID, Row: cell.Row, Column: cell.Column, Score: score, Text: cell.Text, index: cell.Index}
+	}
+	hits := make([]SearchHit, 0, len(best))
+	for _, hit := range best {
+		hits = append(hits, *hit)
+	}
+	slices.SortFunc(hits, func(a, b SearchHit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(a.Key, b.Key), cmp.Compare(a.index, b.index))
+	})
+
+	res := &SearchResult{Query: query, Hits: hits[:min(limit, len(hits))]}
+	for i := range res.Hits {
+		res.Hits[i].Score = math.Round(res.Hits[i].Score*1e4) / 1e4
+		if r := []rune(res.Hits[i].Text); len(r) > searchTextRunes {
+			res.Hits[i].Text = string(r[:searchTextRunes]) + "…"
+		}
+	}
+	return res, nil
+}
+
+// SearchTool runs a searchRows call and reports failures in the result, so the model can react.
+func (s *RowSearcher) SearchTool(ctx context.Context, input *SearchRowsInput) (*SearchResult, error) {
+	res, err := s.Search(ctx, input.Query, input.Key, input.Limit)
+	if err != nil {
+		return &SearchResult{Query: input.Query, Hits: []SearchHit{}, Error: err.Error()}, nil
+	}
+	return res, nil
+}
+
+// Retrieve implements ai.RetrieverFunc. Each document is the text of a matching cell, with the
+// key, id, row, column and score of the hit as metadata. Options may be a *SearchRowsInput.
+func (s *RowSearcher) Retrieve(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
+	var key string
+	var limit int
+	if opts, ok := req.Options.(*SearchRowsInput); ok && opts != nil {
+		key, limit = opts.Key, opts.Limit
+	}
+	var query strings.Builder
+	for _, part := range req.Query.Content {
+		if part.IsText() {
+			query.WriteString(part.Text)
+		}
+	}
+	res, err := s.Search(ctx, query.String(), key, limit)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ai.RetrieverResponse{}
+	for _, hit := range res.Hits {
+		resp.Documents = append(resp.Documents, ai.DocumentFromText(hit.Text, map[string]any{
+			"key": hit.Key, "id": hit.ID, "row": hit.Row, "column": hit.Column, "score": hit.Score,
+		}))
+	}
+	return resp, nil
+}
+
+// describe lists the indexed columns of each sheet for prompts, or returns "" if nothing is indexed.
+func (s *RowSearcher) describe() string {
+	if s == nil {
+		return ""
+	}
+	idx, err := s.load()
+	if err != nil || idx.Embedder != s.EmbedderName {
+		return ""
+	}
+	var keys []string
+	columns := make(map[string][]string)
+	for _, cell := range idx.Cells {
+		if _, ok := columns[cell.Key]; !ok {
+			keys = append(keys, cell.Key)
+		}
+		if !slices.Contains(columns[cell.Key], cell.Column) {
+			columns[cell.Key] = append(columns[cell.Key], cell.Column)
+		}
+	}
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "- %s: %s\n", key, strings.Join(columns[key], ", "))
+	}
+	return b.String()
+}
+
+// load returns the index file, reading it again only when it has changed.
+func (s *RowSearcher) load() (*SearchIndex, error) {
+	info, err := os.Stat(s.Options.IndexFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no search index at %s; build it with -cmd index", s.Options.IndexFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index != nil && info.ModTime().Equal(s.modTime) {
+		return s.index, nil
+	}
+	idx, err := readSearchIndex(s.Options.IndexFile)
+	if err != nil {
+		return nil, err
+	}
+	s.index, s.modTime = idx, info.ModTime()
+	return idx, nil
+}
+
+func (s *RowSearcher) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	docs := make([]*ai.Document, len(texts))
+	for i, text := range texts {
+		docs[i] = ai.DocumentFromText(text, nil)
+	}
+	resp, err := s.Embedder.Embed(ctx, &ai.EmbedRequest{Input: docs})
+	if err != nil {
+		return nil, fmt.Errorf("embedding with %s failed: %w", s.EmbedderName, err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder %s returned %d vectors for %d texts", s.EmbedderName, len(resp.Embeddings), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, e := range resp.Embeddings {
+		vectors[i] = e.Embedding
+	}
+	return vectors, nil
+}
+
+func readSearchIndex(path string) (*SearchIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx SearchIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse search index %s: %w", path, err)
+	}
+	return &idx, nil
+}
+
+// writeSearchIndex replaces the index file through a temporary file, so readers never see half of it.
+func writeSearchIndex(path string, idx *SearchIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// rowID identifies the i-th row of a sheet: its primary key, or its 1-based position.
+func rowID(row map[string]interface{}, primaryKey string, i int) string {
+	if primaryKey != "" && !isEmptyValue(row[primaryKey]) {
+		return valueKey(row[primaryKey])
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// cellText is the text embedded for a cell; array elements are joined with commas.
+func cellText(v interface{}) string {
+	if isEmptyValue(v) {
+		return ""
+	}
+	parts := make([]string, 0, 1)
+	for _, elem := range valueElements(v) {
+		if !isEmptyValue(elem) {
+			parts = append(parts, valueKey(elem))
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, ", "))
+}
+
+// cosineSimilarity compares two vectors; vectors of different length score 0.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		na += x * x
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
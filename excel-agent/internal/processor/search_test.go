@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/firebase/genkit/go/ai"
+)
+
+// searchWords are the dimensions of wordEmbedder's vectors.
+var searchWords = []string{"dragon", "boss", "gold", "shop", "village"}
+
+// wordEmbedder embeds a text as the counts of searchWords in it, so similarities are predictable.
+func wordEmbedder(calls *int) ai.Embedder {
+	return ai.NewEmbedder("test/words", nil, func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		*calls += len(req.Input)
+		resp := &ai.EmbedResponse{}
+		for _, doc := range req.Input {
+			text := strings.ToLower(doc.Content[0].Text)
+			v := make([]float32, len(searchWords))
+			for i, w := range searchWords {
+				v[i] = float32(strings.Count(text, w))
+			}
+			resp.Embeddings = append(resp.Embeddings, &ai.Embedding{Embedding: v})
+		}
+		return resp, nil
+	})
+}
+
+// dialogueSearcher indexes Dialogue:Lines (keyed by ID) and Dialogue:Notes (no key) from a JSON directory.
+func dialogueSearcher(t *testing.T, calls *int) *RowSearcher {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	dir := t.TempDir()
+	writeWorkbooks(t, dir, &Workbook{Name: "Dialogue", Sheets: []*Sheet{
+		{Name: "Lines", Columns: []string{"LineID", "Text", "Hint"}, Rows: []map[string]interface{}{
+			{"LineID": int64(501), "Text": "Welcome to the village shop", "Hint": "gold"},
+			{"LineID": int64(502), "Text": "The dragon boss awakens", "Hint": "dragon"},
+			{"LineID": int64(503), "Text": "Bring gold to the shop"},
+			{"LineID": int64(504), "Hint": "gold"},
+		}},
+		{Name: "Notes", Columns: []string{"Text"}, Rows: []map[string]interface{}{
+			{"Text": "A dragon was seen near the village"},
+			{"Text": "Shop prices"},
+		}},
+	}})
+	opts, err := LoadSearchOptions("Dialogue:Lines=Text,Dialogue:Lines=Hint,Notes=Text", filepath.Join(t.TempDir(), "index.json"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseKeyColumns("", "Dialogue:Lines=LineID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RowSearcher{
+		Embedder:     wordEmbedder(calls),
+		EmbedderName: "test/words",
+		Options:      opts,
+		Catalog:      &CatalogLoader{JsonDir: dir, RedisAddr: mr.Addr(), Redis: &RedisOptions{Keys: keys}},
+	}
+}
+
+func TestRowSearcherBuildIndex(t *testing.T) {
+	var calls int
+	s := dialogueSearcher(t, &calls)
+	report, err := s.BuildIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows 501 and 504 share the hint "gold", which is embedded once.
+	if report.Cells != 8 || report.Embedded != 7 || report.Reused != 0 || calls != 7 {
+		t.Errorf("report = %s, embedder calls = %d; want 8 cells, 7 embedded", report, calls)
+	}
+	if report, err = s.BuildIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report.Embedded != 0 || report.Reused != 8 || calls != 7 {
+		t.Errorf("rebuild = %s, embedder calls = %d; want every vector reused", report, calls)
+	}
+}
+
+func TestRowSearcherSearch(t *testing.T) {
+	var calls int
+	s := dialogueSearcher(t, &calls)
+	if _, err := s.BuildIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, query, key string
+		limit            int
+		want             []string
+	}{
+		// Row 502 matches in Text and Hint but is reported once, with its best cell.
+		{"ranked", "dragon boss", "", 3, []string{"Dialogue:Lines 502 Text 3", "Dialogue:Notes 1 Text 2"}},
+		{"one sheet", "dragon", "dialogue:notes", 0, []string{"Dialogue:Notes 1 Text 2"}},
+		{"ties by key and row", "shop", "", 3, []string{"Dialogue:Notes 2 Text 3", "Dialogue:Lines 501 Text 2", "Dialogue:Lines 503 Text 4"}},
+		{"limit", "shop", "", 1, []string{"Dialogue:Notes 2 Text 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.Search(context.Background(), tt.query, tt.key, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range res.Hits {
+				if h.Score <= 0 {
+					break
+				}
+				got = append(got, strings.Join([]string{h.Key, h.ID, h.Column, strconv.Itoa(h.Row)}, " "))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("hits:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+
+	s.EmbedderName = "other/model"
+	if _, err := s.Search(context.Background(), "dragon", "", 0); err == nil || !strings.Contains(err.Error(), "rebuild it") {
+		t.Errorf("err = %v, want an embedder mismatch", err)
+	}
+}
+
+func TestRankBySearchMatchesRowIDs(t *testing.T) {
+	var calls int
+	s := dialogueSearcher(t, &calls)
+	ctx := context.Background()
+	if _, err := s.BuildIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := s.Catalog.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key, query, column string
+		want               []string
+	}{
+		// Rows scoring 0 follow in sheet order.
+		{"Dialogue:Lines", "dragon boss", "LineID", []string{"502", "501", "503", "504"}},
+		// Without a primary key, hits are matched by position.
+		{"Dialogue:Notes", "village shop", "Text", []string{"Shop prices", "A dragon was seen near the village"}},
+	}
+	for _, tt := range tests {
+		cs, ok := catalog.Sheet(tt.key)
+		if !ok {
+			t.Fatalf("%s is not in the catalog", tt.key)
+		}
+		sheet, err := s.Catalog.ReadSheet(ctx, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranked, err := rankBySearch(ctx, s, cs, sheet, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, row := range ranked.Rows {
+			got = append(got, valueKey(row[tt.column]))
+			if _, ok := row[SearchScoreColumn].(float64); !ok {
+				t.Errorf("%s: row without %s", tt.key, SearchScoreColumn)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: rows %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRowID(t *testing.T) {
+	tests := []struct {
+		name string
+		row  map[string]interface{}
+		key  string
+		want string
+	}{
+		{"int key", map[string]interface{}{"ID": int64(1001)}, "ID", "1001"},
+		{"float key from JSON", map[string]interface{}{"ID": float64(1001)}, "ID", "1001"},
+		{"text key", map[string]interface{}{"ID": "U-01"}, "ID", "U-01"},
+		{"empty key falls back to position", map[string]interface{}{"ID": ""}, "ID", "3"},
+		{"missing key falls back to position", map[string]interface{}{"Name": "x"}, "ID", "3"},
+		{"no primary key", map[string]interface{}{"ID": int64(7)}, "", "3"},
+	}
+	for _, tt := range tests {
+		if got := rowID(tt.row, tt.key, 2); got != tt.want {
+			t.Errorf("%s: rowID = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCellTextAndSimilarity(t *testing.T) {
+	texts := map[string]interface{}{
+		"":      nil,
+		"hello": "  hello ",
+		"a, 2":  []interface{}{"a", "", int64(2), nil},
+		"1.5":   1.5,
+	}
+	for want, v := range texts {
+		if got := cellText(v); got != want {
+			t.Errorf("cellText(%#v) = %q, want %q", v, got, want)
+		}
+	}
+
+	sims := []struct {
+		a, b []float32
+		want float64
+	}{
+		{[]float32{1, 0}, []float32{2, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 1}, 0},
+		{[]float32{1, 1}, []float32{-1, -1}, -1},
+		{[]float32{1, 0}, []float32{1, 0, 0}, 0},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, tt := range sims {
+		if got := cosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("cosineSimilarity(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// SheetsFetchOptions controls how the tabs of a spreadsheet are read. The zero value uses the defaults.
+type SheetsFetchOptions struct {
+	// BatchSize is the number of tabs read per Values.BatchGet call. Defaults to 10.
+	BatchSize int
+	// Concurrency is the number of BatchGet calls in flight. Defaults to 4.
+	Concurrency int
+	// MaxRetries bounds the retries of a call failing with 429, 5xx or a transport error.
+	// Defaults to 5; a negative value disables retries.
+	MaxRetries int
+	// BaseDelay is the first backoff delay; it doubles with every retry up to MaxDelay.
+	// Defaults to 500ms and 30s.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// AllowPartial writes the tabs that were read even if others failed.
+	// Otherwise nothing is written unless every tab was read.
+	AllowPartial bool
+	// Endpoint replaces the Sheets API base URL, e.g. with a local fake server.
+	// Requests to it are sent without credentials.
+	Endpoint string
+}
+
+func (o *SheetsFetchOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return 10
+	}
+	return o.BatchSize
+}
+
+func (o *SheetsFetchOptions) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return 4
+	}
+	return o.Concurrency
+}
+
+func (o *SheetsFetchOptions) maxRetries() int {
+	if o == nil || o.MaxRetries < 0 {
+		return 0
+	}
+	if o.MaxRetries == 0 {
+		return 5
+	}
+	return o.MaxRetries
+}
+
+func (o *SheetsFetchOptions) delays() (base, ceiling time.Duration) {
+	base, ceiling = 500*time.Millisecond, 30*time.Second
+	if o != nil && o.BaseDelay > 0 {
+		base = o.BaseDelay
+	}
+	if o != nil && o.MaxDelay > 0 {
+		ceiling = o.MaxDelay
+	}
+	return base, ceiling
+}
+
+func (o *SheetsFetchOptions) allowPartial() bool {
+	return o != nil && o.AllowPartial
+}
+
+// service opens a read-only Sheets client, pointed at Endpoint if one is set.
+func (o *SheetsFetchOptions) service(ctx context.Context, auth *GoogleAuthOptions) (*sheets.Service, error) {
+	if o != nil && o.Endpoint != "" {
+		srv, err := sheets.NewService(ctx, option.WithEndpoint(o.Endpoint), option.WithoutAuthentication())
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve Sheets client: %v", err)
+		}
+		return srv, nil
+	}
+	return newSheetsService(ctx, auth, false)
+}
+
+// SheetsReport describes a spreadsheet conversion, including the tabs that could not be read.
+type SheetsReport struct {
+	SpreadsheetID string `json:"spreadsheet_id"`
+	Title         string `json:"title"`
+	// JSONPath is empty when nothing was written.
+	JSONPath string         `json:"json_path,omitempty"`
+	Sheets   []string       `json:"sheets"`
+	Failed   []SheetFailure `json:"failed,omitempty"`
+	Skipped  []SheetFailure `json:"skipped,omitempty"`
+	Requests int64          `json:"requests"`
+	Retries  int64          `json:"retries"`
+}
+
+// SheetFailure names a tab and why it was not converted.
+type SheetFailure struct {
+	Sheet string `json:"sheet"`
+	Error string `json:"error"`
+}
+
+// Partial reports whether some tabs could not be read.
+func (r *SheetsReport) Partial() bool {
+	return len(r.Failed) > 0
+}
+
+func (r *SheetsReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Spreadsheet '%s' (%s): %d sheets ok, %d failed, %d skipped (%d requests, %d retries)",
+		r.Title, r.SpreadsheetID, len(r.Sheets), len(r.Failed), len(r.Skipped), r.Requests, r.Retries)
+	if r.JSONPath != "" {
+		fmt.Fprintf(&b, "\n  written to %s", r.JSONPath)
+	} else {
+		b.WriteString("\n  nothing written")
+	}
+	for _, f := range r.Failed {
+		fmt.Fprintf(&b, "\n  [failed] %s: %s", f.Sheet, f.Error)
+	}
+	for _, f := range r.Skipped {
+		fmt.Fprintf(&b, "\n  [skipped] %s: %s", f.Sheet, f.Error)
+	}
+	return b.String()
+}
+
+// sheetTab is one tab of a spreadsheet and the cells read from it.
+type sheetTab struct {
+	title string
+	rows  [][]string
+	err   error
+}
+
+// sheetsFetcher reads the tabs of one spreadsheet with batching, bounded concurrency and retries.
+type sheetsFetcher struct {
+	srv           *sheets.Service
+	spreadsheetID string
+	opts          *SheetsFetchOptions
+
+	requests atomic.Int64
+	retries  atomic.Int64
+}
+
+// fetchSpreadsheet returns the tabs passing include in spreadsheet order, and a report holding the title.
+// Tabs that could not be read carry their error; only a failure to read the tab list is returned as an error.
+func fetchSpreadsheet(ctx context.Context, srv *sheets.Service, spreadsheetID string, include func(string) bool, opts *SheetsFetchOptions) ([]*sheetTab, *SheetsReport, error) {
+	f := &sheetsFetcher{srv: srv, spreadsheetID: spreadsheetID, opts: opts}
+	report := &SheetsReport{SpreadsheetID: spreadsheetID}
+	defer func() {
+		report.Requests = f.requests.Load()
+		report.Retries = f.retries.Load()
+	}()
+
+	var resp *sheets.Spreadsheet
+	err := f.call(ctx, "spreadsheet metadata", func() error {
+		var err error
+		resp, err = srv.Spreadsheets.Get(spreadsheetID).Fields("properties.title", "sheets.properties.title").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, report, fmt.Errorf("unable to retrieve spreadsheet: %v", err)
+	}
+	report.Title = resp.Properties.Title
+
+	var tabs []*sheetTab
+	for _, s := range resp.Sheets {
+		if include(s.Properties.Title) {
+			tabs = append(tabs, &sheetTab{title: s.Properties.Title})
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.concurrency())
+	for start := 0; start < len(tabs); start += opts.batchSize() {
+		chunk := tabs[start:min(start+opts.batchSize(), len(tabs))]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			f.fetchTabs(ctx, chunk)
+		}()
+	}
+	wg.Wait()
+
+	for _, tab := range tabs {
+		if tab.err != nil {
+			report.Failed = append(report.Failed, SheetFailure{Sheet: tab.title, Error: tab.err.Error()})
+		}
+	}
+	return tabs, report, nil
+}
+
+// fetchTabs reads a chunk of tabs with one BatchGet call. If the call fails for a reason
+// retrying cannot fix, such as one malformed tab, the tabs are read one by one so the rest still succeed.
+func (f *sheetsFetcher) fetchTabs(ctx context.Context, tabs []*sheetTab) {
+	ranges := make([]string, len(tabs))
+	for i, tab := range tabs {
+		ranges[i] = sheetRange(tab.title, "")
+	}
+
+	var resp *sheets.BatchGetValuesResponse
+	err := f.call(ctx, strings.Join(ranges, ", "), func() error {
+		var err error
+		resp, err = f.srv.Spreadsheets.Values.BatchGet(f.spreadsheetID).Ranges(ranges...).Context(ctx).Do()
+		return err
+	})
+	if err == nil && len(resp.ValueRanges) != len(tabs) {
+		err = fmt.Errorf("expected %d value ranges, got %d", len(tabs), len(resp.ValueRanges))
+	}
+	if err != nil {
+		if len(tabs) > 1 && !retryableSheetsError(err) && ctx.Err() == nil {
+			for _, tab := range tabs {
+				f.fetchTabs(ctx, []*sheetTab{tab})
+			}
+			return
+		}
+		for _, tab := range tabs {
+			tab.err = err
+		}
+		return
+	}
+
+	for i, vr := range resp.ValueRanges {
+		rows := make([][]string, len(vr.Values))
+		for r, row := range vr.Values {
+			rows[r] = make([]string, len(row))
+			for c, cell := range row {
+				rows[r][c] = fmt.Sprintf("%v", cell)
+			}
+		}
+		tabs[i].rows = rows
+	}
+}
+
+// call runs do, retrying with exponential backoff and jitter while it fails with a retryable error.
+func (f *sheetsFetcher) call(ctx context.Context, what string, do func() error) error {
+	base, ceiling := f.opts.delays()
+	for attempt := 0; ; attempt++ {
+		f.requests.Add(1)
+		err := do()
+		if err == nil || !retryableSheetsError(err) || attempt >= f.opts.maxRetries() {
+			return err
+		}
+		f.retries.Add(1)
+
+		delay := backoffDelay(base, ceiling, attempt)
+		// Jitter spreads out the retries of concurrent calls hitting the same quota.
+		delay = delay/2 + rand.N(delay/2+1)
+		if after := retryAfter(err); after > delay {
+			delay = min(after, ceiling)
+		}
+		log.Printf("Sheets request for %s failed (attempt %d/%d): %v; retrying in %s", what, attempt+1, f.opts.maxRetries()+1, err, delay)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// backoffDelay returns base doubled attempt times, capped at ceiling. It doubles step by step
+// so a large attempt cannot overflow the shift.
+func backoffDelay(base, ceiling time.Duration, attempt int) time.Duration {
+	delay := min(base, ceiling)
+	for i := 0; i < attempt && delay < ceiling; i++ {
+		if delay > ceiling/2 {
+			delay = ceiling
+		} else {
+			delay *= 2
+		}
+	}
+	return delay
+}
+
+// retryableSheetsError reports whether err is a rate limit, a server error or a transport failure.
+func retryableSheetsError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500
+	}
+	return true
+}
+
+// retryAfter returns the delay requested by a Retry-After header in seconds, if any.
+func retryAfter(err error) time.Duration {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Header == nil {
+		return 0
+	}
+	secs, convErr := strconv.Atoi(gerr.Header.Get("Retry-After"))
+	if convErr != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
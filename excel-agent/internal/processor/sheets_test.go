@@ -0,0 +1,233 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSheets is a local stand-in for the Sheets API serving spreadsheet metadata and
+// Values.BatchGet. fail, if set, can answer a BatchGet call with an error status instead.
+type fakeSheets struct {
+	title string
+	tabs  []string
+	fail  func(call int, ranges []string) int
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/values:batchGet"):
+		ranges := r.URL.Query()["ranges"]
+		f.mu.Lock()
+		f.batches = append(f.batches, ranges)
+		call := len(f.batches)
+		f.mu.Unlock()
+
+		if f.fail != nil {
+			if code := f.fail(call, ranges); code != 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(code)
+				fmt.Fprintf(w, `{"error":{"code":%d,"message":"fake error %d"}}`, code, code)
+				return
+			}
+		}
+		var valueRanges []map[string]interface{}
+		for _, rng := range ranges {
+			title := strings.ReplaceAll(strings.Trim(rng, "'"), "''", "'")
+			valueRanges = append(valueRanges, map[string]interface{}{
+				"range":  rng,
+				"values": [][]interface{}{{"ID", "Name"}, {1, title + "-1"}, {2, title + "-2"}},
+			})
+		}
+		writeJSON(w, map[string]interface{}{"valueRanges": valueRanges})
+
+	case strings.HasPrefix(r.URL.Path, "/v4/spreadsheets/"):
+		var sheets []map[string]interface{}
+		for _, tab := range f.tabs {
+			sheets = append(sheets, map[string]interface{}{"properties": map[string]interface{}{"title": tab}})
+		}
+		writeJSON(w, map[string]interface{}{"properties": map[string]interface{}{"title": f.title}, "sheets": sheets})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// start serves f and returns fetch options pointed at it with short retry delays.
+func (f *fakeSheets) start(t *testing.T) *SheetsFetchOptions {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return &SheetsFetchOptions{Endpoint: srv.URL + "/", BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+}
+
+func TestConvertGoogleSheetBatchesTabs(t *testing.T) {
+	fake := &fakeSheets{title: "Items", tabs: []string{"A", "B", "C", "D", "E"}}
+	fetch := fake.start(t)
+	fetch.BatchSize = 2
+	dir := t.TempDir()
+
+	report, err := ConvertGoogleSheetToJSON(context.Background(), "sheet-id", dir, nil, nil, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.batches) != 3 {
+		t.Fatalf("got %d BatchGet calls, want 3: %v", len(fake.batches), fake.batches)
+	}
+	for _, b := range fake.batches {
+		if len(b) > 2 {
+			t.Errorf("BatchGet call with %d ranges exceeds the batch size: %v", len(b), b)
+		}
+	}
+	if got := strings.Join(report.Sheets, ","); got != "A,B,C,D,E" {
+		t.Errorf("converted sheets = %s, want A,B,C,D,E in spreadsheet order", got)
+	}
+	if report.Requests != 4 || report.Retries != 0 {
+		t.Errorf("requests/retries = %d/%d, want 4/0", report.Requests, report.Retries)
+	}
+
+	wb, err := ReadJSONWorkbook(filepath.Join(dir, "Items.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wb.Sheets) != 5 || wb.Sheets[4].Name != "E" || wb.Sheets[4].Rows[1]["Name"] != "E-2" {
+		t.Errorf("unexpected workbook: %+v", wb.Sheets)
+	}
+}
+
+func TestConvertGoogleSheetRetriesRateLimits(t *testing.T) {
+	fake := &fakeSheets{title: "Items", tabs: []string{"A", "B"}}
+	fake.fail = func(call int, _ []string) int {
+		switch call {
+		case 1:
+			return http.StatusTooManyRequests
+		case 2:
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	fetch := fake.start(t)
+
+	report, err := ConvertGoogleSheetToJSON(context.Background(), "sheet-id", t.TempDir(), nil, nil, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Retries != 2 || report.Requests != 4 {
+		t.Errorf("requests/retries = %d/%d, want 4/2", report.Requests, report.Retries)
+	}
+	if report.Partial() || len(report.Sheets) != 2 {
+		t.Errorf("expected both sheets after retrying, got %s", report)
+	}
+}
+
+func TestConvertGoogleSheetGivesUpAfterMaxRetries(t *testing.T) {
+	fake := &fakeSheets{title: "Items", tabs: []string{"A", "B"}}
+	fake.fail = func(int, []string) int { return http.StatusInternalServerError }
+	fetch := fake.start(t)
+	fetch.MaxRetries = 2
+
+	report, err := ConvertGoogleSheetToJSON(context.Background(), "sheet-id", t.TempDir(), nil, nil, fetch)
+	if err == nil {
+		t.Fatal("expected an error when every call fails")
+	}
+	// A retryable failure is not split into per-tab calls.
+	if len(fake.batches) != 3 || report.Retries != 2 {
+		t.Errorf("got %d calls and %d retries, want 3 and 2", len(fake.batches), report.Retries)
+	}
+	if len(report.Failed) != 2 {
+		t.Errorf("failed tabs = %v, want A and B", report.Failed)
+	}
+}
+
+func TestConvertGoogleSheetFallsBackPerTab(t *testing.T) {
+	newFake := func() *fakeSheets {
+		fake := &fakeSheets{title: "Items", tabs: []string{"A", "Broken", "C"}}
+		fake.fail = func(_ int, ranges []string) int {
+			for _, r := range ranges {
+				if r == "'Broken'" {
+					return http.StatusBadRequest
+				}
+			}
+			return 0
+		}
+		return fake
+	}
+
+	fake := newFake()
+	dir := t.TempDir()
+	report, err := ConvertGoogleSheetToJSON(context.Background(), "sheet-id", dir, nil, nil, fake.start(t))
+	if err == nil {
+		t.Fatal("expected an error without AllowPartial")
+	}
+	// One batch of three, then each tab on its own.
+	if len(fake.batches) != 4 {
+		t.Errorf("got %d BatchGet calls, want 4: %v", len(fake.batches), fake.batches)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Sheet != "Broken" {
+		t.Errorf("failed tabs = %v, want only Broken", report.Failed)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "Items.json")); statErr == nil {
+		t.Error("nothing should be written when a tab failed")
+	}
+
+	fake = newFake()
+	fetch := fake.start(t)
+	fetch.AllowPartial = true
+	report, err = ConvertGoogleSheetToJSON(context.Background(), "sheet-id", dir, nil, nil, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Partial() || strings.Join(report.Sheets, ",") != "A,C" || report.JSONPath == "" {
+		t.Errorf("expected a partial report with A and C written, got %s", report)
+	}
+	wb, err := ReadJSONWorkbook(report.JSONPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wb.Sheets) != 2 {
+		t.Errorf("written sheets = %d, want 2", len(wb.Sheets))
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	const base, ceiling = 500 * time.Millisecond, 30 * time.Second
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, base},
+		{1, time.Second},
+		{5, 16 * time.Second},
+		{6, ceiling},
+		{40, ceiling},
+		{1000, ceiling},
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(base, ceiling, tt.attempt); got != tt.want {
+			t.Errorf("backoffDelay(attempt %d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+	if got := backoffDelay(time.Minute, ceiling, 0); got != ceiling {
+		t.Errorf("base above the ceiling = %s, want %s", got, ceiling)
+	}
+	if got := backoffDelay(time.Second, math.MaxInt64, 100); got <= 0 {
+		t.Errorf("unbounded ceiling = %s, want a positive delay", got)
+	}
+}
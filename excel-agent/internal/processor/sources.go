@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceManifest lists the spreadsheets and workbooks to convert, each under a stable output name.
+//
+//	sources:
+//	  - name: Character
+//	    spreadsheet: 1AbC...xyz
+//	    exclude: ["Draft*"]
+//	    owner: planner-a
+//	  - name: Item
+//	    workbook: ItemTable_v3.xlsx
+//	    include: [ItemList, Shop]
+//	    header_row: 2
+type SourceManifest struct {
+	Sources []*Source `yaml:"sources" json:"sources"`
+}
+
+// Source is one entry of a SourceManifest. Exactly one of Spreadsheet and Workbook is set.
+type Source struct {
+	// Name is the output name: JSON_DIR/<Name>.json and the File part of Redis keys.
+	Name string `yaml:"name" json:"name"`
+	// Spreadsheet is a Google Spreadsheet ID.
+	Spreadsheet string `yaml:"spreadsheet,omitempty" json:"spreadsheet,omitempty"`
+	// Workbook is an .xlsx file, relative to XLSX_DIR unless absolute.
+	Workbook string `yaml:"workbook,omitempty" json:"workbook,omitempty"`
+	// Include and Exclude select tabs by name or glob pattern.
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	// HeaderRow overrides the header row of the sheet layout (1-based).
+	HeaderRow int `yaml:"header_row,omitempty" json:"header_row,omitempty"`
+	// Owner is who maintains the source; it is shown when the source fails.
+	Owner string `yaml:"owner,omitempty" json:"owner,omitempty"`
+}
+
+// LoadSourceManifest reads a YAML or JSON sources manifest.
+func LoadSourceManifest(path string) (*SourceManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sources manifest: %w", err)
+	}
+	var m SourceManifest
+	// YAML is a superset of JSON, so one decoder handles both formats.
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse sources manifest %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	workbooks := make(map[string]string)
+	for i, s := range m.Sources {
+		if s == nil {
+			return nil, fmt.Errorf("sources manifest %s: entry %d is empty", path, i+1)
+		}
+		switch {
+		case s.Name == "":
+			return nil, fmt.Errorf("sources manifest %s: entry %d has no name", path, i+1)
+		case s.Name != safeFileName(s.Name) || strings.ContainsAny(s.Name, ":,"):
+			return nil, fmt.Errorf("sources manifest %s: name %q must not contain '/', '\\', ':' or ','", path, s.Name)
+		case names[s.Name]:
+			return nil, fmt.Errorf("sources manifest %s: duplicate name %q", path, s.Name)
+		case (s.Spreadsheet == "") == (s.Workbook == ""):
+			return nil, fmt.Errorf("sources manifest %s: %s must set exactly one of spreadsheet and workbook", path, s.Name)
+		case s.HeaderRow < 0:
+			return nil, fmt.Errorf("sources manifest %s: %s has a negative header_row", path, s.Name)
+		}
+		names[s.Name] = true
+		if s.Workbook != "" {
+			// The conversion manifest tracks workbooks by file name, so each may appear once.
+			base := filepath.Base(s.Workbook)
+			if other, dup := workbooks[base]; dup {
+				return nil, fmt.Errorf("sources manifest %s: %s and %s use the same workbook %s", path, other, s.Name, base)
+			}
+			workbooks[base] = s.Name
+		}
+	}
+	return &m, nil
+}
+
+// Select returns the sources with the given names in manifest order, or all of them if names is empty.
+func (m *SourceManifest) Select(names []string) ([]*Source, error) {
+	if len(names) == 0 {
+		return m.Sources, nil
+	}
+	want := make(map[string]bool, len(names))
+	for _, n := range names {
+		want[n] = true
+	}
+	var selected []*Source
+	for _, s := range m.Sources {
+		if want[s.Name] {
+			selected = append(selected, s)
+			delete(want, s.Name)
+		}
+	}
+	if len(want) > 0 {
+		var unknown []string
+		for _, n := range names {
+			if want[n] {
+				unknown = append(unknown, n)
+			}
+		}
+		return nil, fmt.Errorf("unknown sources: %s", strings.Join(unknown, ", "))
+	}
+	return selected, nil
+}
+
+// ParseSourceNames splits a comma-separated list of source names.
+func ParseSourceNames(spec string) []string {
+	var names []string
+	for _, n := range strings.Split(spec, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// JSONFile is the file name the source is converted to.
+func (s *Source) JSONFile() string {
+	return s.Name + ".json"
+}
+
+// WorkbookPath resolves Workbook against xlsxDir.
+func (s *Source) WorkbookPath(xlsxDir string) string {
+	if filepath.IsAbs(s.Workbook) {
+		return s.Workbook
+	}
+	return filepath.Join(xlsxDir, s.Workbook)
+}
+
+func (s *Source) String() string {
+	if s.Owner == "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (owner: %s)", s.Name, s.Owner)
+}
+
+// ConvertOptions returns a copy of base carrying the output name, tab selection and header row of the source.
+func (s *Source) ConvertOptions(base *ConvertOptions) (*ConvertOptions, error) {
+	opts := ConvertOptions{}
+	if base != nil {
+		opts = *base
+	}
+	opts.Name = s.Name
+	opts.IncludeSheets = s.Include
+	opts.ExcludeSheets = s.Exclude
+	if s.HeaderRow > 0 {
+		layout := *opts.layout()
+		layout.HeaderRow = s.HeaderRow
+		if err := layout.validate(); err != nil {
+			return nil, fmt.Errorf("source %s: %w", s.Name, err)
+		}
+		opts.Layout = &layout
+	}
+	return &opts, nil
+}
+
+// SourcesOfKind filters sources down to spreadsheets or to workbooks.
+func SourcesOfKind(sources []*Source, spreadsheets bool) []*Source {
+	var out []*Source
+	for _, s := range sources {
+		if (s.Spreadsheet != "") == spreadsheets {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ProcessXlsxSources converts the workbook sources incrementally, like ProcessXlsxFiles,
+// but under their stable names. Workbooks that are not listed are left alone.
+func ProcessXlsxSources(xlsxDir, jsonDir string, sources []*Source, base *ConvertOptions, force bool) (*ConversionReport, error) {
+	m, err := LoadManifest(jsonDir)
+	if err != nil {
+		return nil, err
+	}
+	report := &ConversionReport{}
+	for _, s := range SourcesOfKind(sources, false) {
+		opts, err := s.ConvertOptions(base)
+		if err == nil {
+			err = m.convert(s.WorkbookPath(xlsxDir), jsonDir, opts, force, report)
+		}
+		if err != nil {
+			log.Printf("Failed to convert source %s: %v", s, err)
+			report.Failed = append(report.Failed, filepath.Base(s.Workbook))
+		}
+	}
+	if err := m.Save(jsonDir); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// ProcessSheetSources converts the spreadsheet sources one after another. A failing
+// source does not stop the others; the returned error names every source that failed.
+func ProcessSheetSources(ctx context.Context, jsonDir string, auth *GoogleAuthOptions, sources []*Source, base *ConvertOptions, fetch *SheetsFetchOptions) ([]*SheetsReport, error) {
+	var reports []*SheetsReport
+	var errs []error
+	for _, s := range SourcesOfKind(sources, true) {
+		opts, err := s.ConvertOptions(base)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report, err := ConvertGoogleSheetToJSON(ctx, s.Spreadsheet, jsonDir, auth, opts, fetch)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", s, err))
+		}
+	}
+	return reports, errors.Join(errs...)
+}
+
+// sourceForWorkbook returns the source converting the workbook at path, if any.
+func sourceForWorkbook(sources []*Source, xlsxDir, path string) *Source {
+	for _, s := range SourcesOfKind(sources, false) {
+		if filepath.Clean(s.WorkbookPath(xlsxDir)) == filepath.Clean(path) {
+			return s
+		}
+	}
+	return nil
+}
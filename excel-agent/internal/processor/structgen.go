@@ -0,0 +1,548 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// goField is a struct field inferred from a sheet column.
+type goField struct {
+	Name    string
+	JSONKey string
+	Type    string
+}
+
+// goStruct is a row struct inferred from a sheet.
+type goStruct struct {
+	Name   string
+	Sheet  string
+	Fields []goField
+}
+
+// goSchema is everything needed to render the Go file for one JSON source.
+type goSchema struct {
+	Source    string
+	Container string
+	Structs   []goStruct
+}
+
+// GenerateStructsFromSchema writes Go structs for a JSON file without asking a model.
+// Field types are inferred from every row of every sheet. With enhance set, the
+// generated code is handed to the model to improve field names and comments; the
+// result is only kept if it parses and leaves the types and JSON tags unchanged.
+// A companion <file>_loader.go with Load<File> and Get<Sheet>ByID helpers keyed by
+// the keys columns is written next to it, together with a test loading the JSON file.
+func GenerateStructsFromSchema(ctx context.Context, g *genkit.Genkit, fileName, jsonDir, dataDir string, keys *KeyColumns, enhance bool) (string, error) {
+	fileName, err := resolveJSONFile(fileName, jsonDir)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(jsonDir, fileName))
+	if err != nil {
+		return "", fmt.Errorf("failed to read JSON file: %v", err)
+	}
+
+	schema, err := inferSchema(fileName, data)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := renderSchema(schema)
+	if err != nil {
+		return "", err
+	}
+
+	goFileName := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".go"
+	// Date columns use ExcelDate, which lives in a file shared by every generated source.
+	support := map[string][]byte{}
+	if schema.usesDate() {
+		support[dateFileName] = []byte(dateSource)
+	}
+	note := ""
+	if enhance {
+		enhanced, err := enhanceStructs(ctx, g, code)
+		if err == nil {
+			if diags := checkDataFiles(dataDir, withFile(support, goFileName, enhanced)); len(diags) > 0 {
+				err = fmt.Errorf("enhanced code does not compile: %s", diags[0])
+			}
+		}
+		if err != nil {
+			log.Printf("AI enhancement of %s rejected: %v", fileName, err)
+			note = fmt.Sprintf(" (AI enhancement rejected: %v)", err)
+		} else {
+			code = enhanced
+			note = " (enhanced by AI)"
+		}
+	}
+
+	jsonRelPath, err := relativeJSONPath(dataDir, jsonDir, fileName)
+	if err != nil {
+		return "", err
+	}
+	loader, test, err := renderLoader(schema, code, keys, jsonRelPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate loader for %s: %v", fileName, err)
+	}
+
+	baseName := strings.TrimSuffix(goFileName, ".go")
+	files := withFile(support, goFileName, code)
+	files[baseName+"_loader.go"] = loader
+	files[baseName+"_loader_test.go"] = test
+	if diags := checkDataFiles(dataDir, files); len(diags) > 0 {
+		return "", fmt.Errorf("generated %s does not compile:\n  %s", goFileName, strings.Join(diags, "\n  "))
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(dataDir, name), files[name], 0644); err != nil {
+			return "", fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+
+	return fmt.Sprintf("Successfully generated %s from schema (%d sheets)%s", goFileName, len(schema.Structs), note), nil
+}
+
+// withFile returns a copy of files with name added.
+func withFile(files map[string][]byte, name string, src []byte) map[string][]byte {
+	out := maps.Clone(files)
+	out[name] = src
+	return out
+}
+
+// relativeJSONPath is the path of the JSON file as seen from dataDir, used by the generated test.
+func relativeJSONPath(dataDir, jsonDir, fileName string) (string, error) {
+	absData, err := filepath.Abs(dataDir)
+	if err != nil {
+		return "", err
+	}
+	absJSON, err := filepath.Abs(filepath.Join(jsonDir, fileName))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absData, absJSON)
+}
+
+// resolveJSONFile returns fileName, or the first JSON file in jsonDir when it is empty.
+func resolveJSONFile(fileName, jsonDir string) (string, error) {
+	if fileName != "" {
+		return fileName, nil
+	}
+	files, err := os.ReadDir(jsonDir)
+	if err != nil || len(files) == 0 {
+		return "", fmt.Errorf("no JSON files found in %s", jsonDir)
+	}
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+			return f.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("could not find a JSON file to process")
+}
+
+// inferSchema derives row structs from the JSON written by the converters.
+// Sheet names and keys containing Hangul are skipped, like in the AI prompt.
+func inferSchema(fileName string, data []byte) (*goSchema, error) {
+	wb, err := decodeJSONWorkbook(bytes.NewReader(data), filepath.Base(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	schema := &goSchema{
+		Source:    filepath.Base(fileName),
+		Container: goName(baseName) + "AllSheets",
+	}
+	if schema.Container == "AllSheets" {
+		return nil, fmt.Errorf("cannot derive a Go name from %s", fileName)
+	}
+
+	// Structs and fields follow the workbook's sheet and column order.
+	typeNames := names{schema.Container: true, dateType: true}
+	for _, sheet := range wb.Sheets {
+		if containsHangul(sheet.Name) || goName(sheet.Name) == "" {
+			continue
+		}
+		schema.Structs = append(schema.Structs, inferStruct(typeNames.unique(goName(sheet.Name)), sheet))
+	}
+	if len(schema.Structs) == 0 {
+		return nil, fmt.Errorf("no usable sheets found in %s", fileName)
+	}
+	return schema, nil
+}
+
+func inferStruct(name string, sheet *Sheet) goStruct {
+	kinds := make(map[string]*kindSet, len(sheet.Columns))
+	for _, col := range sheet.Columns {
+		kinds[col] = &kindSet{}
+	}
+	for _, row := range sheet.Rows {
+		for key, v := range row {
+			kinds[key].add(v)
+		}
+	}
+
+	st := goStruct{Name: name, Sheet: sheet.Name}
+	fieldNames := names{}
+	for _, key := range sheet.Columns {
+		if containsHangul(key) || goName(key) == "" {
+			continue
+		}
+		if strings.ContainsAny(key, ",\"`") {
+			log.Printf("Skipping column %q in sheet %s: not representable as a JSON tag", key, sheet.Name)
+			continue
+		}
+		st.Fields = append(st.Fields, goField{
+			Name:    fieldNames.unique(goName(key)),
+			JSONKey: key,
+			Type:    kinds[key].goType(),
+		})
+	}
+	return st
+}
+
+// dateType is the Go type of date columns. The converters write dates as "2006-01-02" or
+// "2006-01-02T15:04:05", which time.Time cannot decode, so the generated code gets its own type.
+const dateType = "ExcelDate"
+
+// dateFileName is the file in DataDir that declares dateType.
+const dateFileName = "excel_date.go"
+
+// dateLayouts are the date forms accepted in JSON cells, most specific first.
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// isDateText reports whether a cell string is a date in one of dateLayouts.
+func isDateText(s string) bool {
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+const dateSource = `// Code generated by excel-agent. DO NOT EDIT.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ExcelDate is a date cell: "2006-01-02", "2006-01-02T15:04:05" or an RFC 3339 timestamp.
+type ExcelDate struct {
+	time.Time
+}
+
+var excelDateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// UnmarshalJSON accepts any of the date forms; null and "" leave the zero time.
+func (d *ExcelDate) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == nil || *s == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+	for _, layout := range excelDateLayouts {
+		if t, err := time.Parse(layout, *s); err == nil {
+			d.Time = t
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid date %q", *s)
+}
+
+// MarshalJSON writes the date in the form the converters produce.
+func (d ExcelDate) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	if d.Hour() == 0 && d.Minute() == 0 && d.Second() == 0 && d.Nanosecond() == 0 {
+		return json.Marshal(d.Format("2006-01-02"))
+	}
+	return json.Marshal(d.Format("2006-01-02T15:04:05"))
+}
+`
+
+// kindSet records which JSON value kinds were seen in a column.
+type kindSet struct {
+	ints, floats, bools, strs, times, objects, arrays bool
+	elem                                              *kindSet
+}
+
+func (k *kindSet) add(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case int64:
+		k.ints = true
+	case float64:
+		k.floats = true
+	case bool:
+		k.bools = true
+	case string:
+		if v == "" {
+			return
+		}
+		if isDateText(v) {
+			k.times = true
+		} else {
+			k.strs = true
+		}
+	case []interface{}:
+		k.arrays = true
+		if k.elem == nil {
+			k.elem = &kindSet{}
+		}
+		for _, e := range v {
+			k.elem.add(e)
+		}
+	case map[string]interface{}:
+		k.objects = true
+	}
+}
+
+func (k *kindSet) goType() string {
+	scalar := k.ints || k.floats || k.bools || k.strs || k.times
+	switch {
+	case k.arrays && !scalar && !k.objects:
+		return "[]" + k.elem.goType()
+	case k.objects && !scalar && !k.arrays:
+		return "map[string]interface{}"
+	case k.arrays || k.objects:
+		return "interface{}"
+	case k.bools && !(k.ints || k.floats || k.strs || k.times):
+		return "bool"
+	case k.ints && !(k.floats || k.bools || k.strs || k.times):
+		return "int64"
+	case (k.ints || k.floats) && !(k.bools || k.strs || k.times):
+		return "float64"
+	case k.times && !(k.ints || k.floats || k.bools || k.strs):
+		return dateType
+	case (k.strs || k.times) && !(k.ints || k.floats || k.bools):
+		return "string"
+	case !scalar:
+		// Only empty cells were seen.
+		return "string"
+	}
+	return "interface{}"
+}
+
+// renderSchema produces gofmt'ed Go source and verifies that it parses.
+func renderSchema(s *goSchema) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by excel-agent from %s. DO NOT EDIT.\n\n", s.Source)
+	b.WriteString("package data\n\n")
+	for _, st := range s.Structs {
+		fmt.Fprintf(&b, "// %s is a row of the %s sheet.\n", st.Name, st.Sheet)
+		fmt.Fprintf(&b, "type %s struct {\n", st.Name)
+		for _, f := range st.Fields {
+			fmt.Fprintf(&b, "\t%s %s `json:%s`\n", f.Name, f.Type, strconv.Quote(f.JSONKey))
+		}
+		b.WriteString("}\n\n")
+	}
+	fmt.Fprintf(&b, "// %s holds every sheet of %s.\n", s.Container, s.Source)
+	fmt.Fprintf(&b, "type %s struct {\n", s.Container)
+	for _, st := range s.Structs {
+		fmt.Fprintf(&b, "\t%s []%s `json:%s`\n", st.Name, st.Name, strconv.Quote(st.Sheet))
+	}
+	b.WriteString("}\n")
+
+	return formatGoSource(s.Source, b.Bytes())
+}
+
+func (s *goSchema) usesDate() bool {
+	for _, st := range s.Structs {
+		for _, f := range st.Fields {
+			if strings.Contains(f.Type, dateType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatGoSource runs go/format and go/parser so invalid code is never written.
+func formatGoSource(name string, src []byte) ([]byte, error) {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("generated code for %s does not format: %v", name, err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), name, formatted, parser.AllErrors); err != nil {
+		return nil, fmt.Errorf("generated code for %s does not parse: %v", name, err)
+	}
+	return formatted, nil
+}
+
+// enhanceStructs asks the model to improve field names and doc comments.
+// Type names, field types and JSON tags must come back unchanged.
+func enhanceStructs(ctx context.Context, g *genkit.Genkit, code []byte) ([]byte, error) {
+	if g == nil {
+		return nil, fmt.Errorf("no model available")
+	}
+	prompt := fmt.Sprintf(`Improve the following generated Go code for readability.
+You may rename struct fields to clearer idiomatic Go names and add doc comments to types and fields.
+Do NOT rename types, change field types, change JSON tags, or add, remove or reorder fields.
+Output ONLY the Go code, starting with 'package data'.
+
+%s`, code)
+
+	resp, err := genkit.GenerateText(ctx, g, ai.WithPrompt(prompt))
+	if err != nil {
+		return nil, fmt.Errorf("AI generation failed: %v", err)
+	}
+
+	enhanced, err := formatGoSource("enhanced.go", []byte(extractGoCode(resp)))
+	if err != nil {
+		return nil, err
+	}
+
+	want, err := structSignature(code)
+	if err != nil {
+		return nil, err
+	}
+	got, err := structSignature(enhanced)
+	if err != nil {
+		return nil, err
+	}
+	for name, fields := range want {
+		if strings.Join(got[name], ";") != strings.Join(fields, ";") {
+			return nil, fmt.Errorf("struct %s changed shape", name)
+		}
+	}
+	if len(got) != len(want) {
+		return nil, fmt.Errorf("set of types changed")
+	}
+	return enhanced, nil
+}
+
+// structSignature lists "type tag" for every field of every struct type in src.
+func structSignature(src []byte) (map[string][]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	sig := make(map[string][]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		ts, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		var fields []string
+		for _, f := range st.Fields.List {
+			tag := ""
+			if f.Tag != nil {
+				tag = f.Tag.Value
+			}
+			for range max(len(f.Names), 1) {
+				fields = append(fields, types.ExprString(f.Type)+" "+tag)
+			}
+		}
+		sig[ts.Name.Name] = fields
+		return false
+	})
+	return sig, nil
+}
+
+// names hands out unique Go identifiers.
+type names map[string]bool
+
+func (n names) unique(name string) string {
+	candidate := name
+	for i := 2; n[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	n[candidate] = true
+	return candidate
+}
+
+// commonInitialisms are kept upper-case in generated names, following Go conventions.
+var commonInitialisms = map[string]bool{
+	"API": true, "HP": true, "HTTP": true, "ID": true, "JSON": true,
+	"MP": true, "UI": true, "UID": true, "URL": true, "XML": true,
+}
+
+// goName converts a sheet or column name to an exported PascalCase identifier.
+// It returns "" if the name has no letters or digits.
+func goName(s string) string {
+	var b strings.Builder
+	for _, w := range splitWords(s) {
+		if up := strings.ToUpper(w); commonInitialisms[up] {
+			b.WriteString(up)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(w)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(w[size:])
+	}
+	name := b.String()
+	if name == "" {
+		return ""
+	}
+	if r, _ := utf8.DecodeRuneInString(name); !unicode.IsUpper(r) {
+		name = "X" + name
+	}
+	return name
+}
+
+// splitWords splits on separators and camelCase boundaries ("unit_name", "UnitName", "HTTPCode").
+func splitWords(s string) []string {
+	var words []string
+	var cur []rune
+	runes := []rune(s)
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(cur) > 0 {
+			prev := cur[len(cur)-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return words
+}
+
+func containsHangul(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Hangul, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dataPackage is the package name of the generated files in DataDir.
+const dataPackage = "data"
+
+// checkDataFile parses and type-checks src as goFileName together with the other
+// Go files already in dataDir. It returns compiler-style diagnostics that concern
+// the new file, including declarations clashing with other files; nil means it compiles.
+func checkDataFile(dataDir, goFileName string, src []byte) []string {
+	return checkDataFiles(dataDir, map[string][]byte{goFileName: src})
+}
+
+// checkDataFiles is checkDataFile for a set of new files that belong together.
+func checkDataFiles(dataDir string, srcs map[string][]byte) []string {
+	fset := token.NewFileSet()
+	var files []*ast.File
+	var diags []string
+	for name, src := range srcs {
+		file, err := parser.ParseFile(fset, name, src, parser.AllErrors)
+		if err != nil {
+			diags = append(diags, parseDiagnostics(err)...)
+			continue
+		}
+		if file.Name.Name != dataPackage {
+			diags = append(diags, fmt.Sprintf("%s: package clause must be 'package %s', got 'package %s'", name, dataPackage, file.Name.Name))
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+
+	entries, _ := os.ReadDir(dataDir)
+	for _, e := range entries {
+		name := e.Name()
+		if _, replaced := srcs[name]; e.IsDir() || filepath.Ext(name) != ".go" || replaced || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		other, err := parser.ParseFile(fset, filepath.Join(dataDir, name), nil, 0)
+		if err != nil || other.Name.Name != dataPackage {
+			// Broken neighbours are reported when they are regenerated themselves.
+			continue
+		}
+		files = append(files, other)
+	}
+
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			terr, ok := err.(types.Error)
+			if !ok {
+				diags = append(diags, err.Error())
+				return
+			}
+			pos := terr.Fset.Position(terr.Pos)
+			if _, isNew := srcs[pos.Filename]; isNew || strings.Contains(terr.Msg, "redeclared") || strings.Contains(terr.Msg, "other declaration") {
+				diags = append(diags, fmt.Sprintf("%s: %s", pos, terr.Msg))
+			}
+		},
+	}
+	_, _ = conf.Check(dataPackage, fset, files, nil)
+	return diags
+}
+
+func parseDiagnostics(err error) []string {
+	if list, ok := err.(scanner.ErrorList); ok {
+		diags := make([]string, 0, len(list))
+		for _, e := range list {
+			diags = append(diags, e.Error())
+		}
+		return diags
+	}
+	return []string{err.Error()}
+}
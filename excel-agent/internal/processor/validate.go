@@ -0,0 +1,429 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validation severities.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationRules is the content of a rules file. YAML and JSON are both accepted.
+//
+//	rules:
+//	  - file: Character
+//	    sheet: UnitData
+//	    column: ID
+//	    required: true
+//	    unique: true
+//	  - file: Character
+//	    sheet: UnitData
+//	    column: Attack
+//	    range: {min: 0, max: 9999}
+//	  - file: Reward
+//	    sheet: RewardList
+//	    column: ItemID
+//	    ref: {file: Item, sheet: ItemList, column: ID}
+type ValidationRules struct {
+	Rules []*ValidationRule `yaml:"rules" json:"rules"`
+}
+
+// ValidationRule applies one or more checks to a column. Empty File or Sheet, or "*", match everything.
+type ValidationRule struct {
+	File     string     `yaml:"file" json:"file"`
+	Sheet    string     `yaml:"sheet" json:"sheet"`
+	Column   string     `yaml:"column" json:"column"`
+	Severity string     `yaml:"severity" json:"severity"`
+	Required bool       `yaml:"required" json:"required"`
+	Unique   bool       `yaml:"unique" json:"unique"`
+	Range    *RangeRule `yaml:"range" json:"range"`
+	Regex    string     `yaml:"regex" json:"regex"`
+	Enum     []string   `yaml:"enum" json:"enum"`
+	// Ref is a foreign key into another sheet, in the same file unless Ref.File is set.
+	Ref *RefRule `yaml:"ref" json:"ref"`
+
+	regex *regexp.Regexp
+}
+
+// RangeRule bounds numeric values; either end may be omitted.
+type RangeRule struct {
+	Min *float64 `yaml:"min" json:"min"`
+	Max *float64 `yaml:"max" json:"max"`
+}
+
+// RefRule names the column a foreign key points at.
+type RefRule struct {
+	File   string `yaml:"file" json:"file"`
+	Sheet  string `yaml:"sheet" json:"sheet"`
+	Column string `yaml:"column" json:"column"`
+}
+
+// ValidationIssue is a single failed check with its coordinates.
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Sheet    string `json:"sheet"`
+	// Row is the spreadsheet row number, derived from the sheet layout.
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationReport collects the issues of a validation run.
+type ValidationReport struct {
+	Files  []string          `json:"files"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// HasErrors reports whether any issue has error severity.
+func (r *ValidationReport) HasErrors() bool {
+	for _, is := range r.Issues {
+		if is.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ValidationReport) String() string {
+	errs := 0
+	for _, is := range r.Issues {
+		if is.Severity == SeverityError {
+			errs++
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Validated %d files: %d errors, %d warnings", len(r.Files), errs, len(r.Issues)-errs)
+	for _, is := range r.Issues {
+		fmt.Fprintf(&b, "\n  [%s] %s:%s row %d, column %q (%s): %s", is.Severity, is.File, is.Sheet, is.Row, is.Column, is.Rule, is.Message)
+	}
+	return b.String()
+}
+
+// LoadValidationRules reads a YAML or JSON rules file.
+func LoadValidationRules(path string) (*ValidationRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validation rules: %w", err)
+	}
+	var rules ValidationRules
+	// YAML is a superset of JSON, so one decoder handles both formats.
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse validation rules %s: %w", path, err)
+	}
+	for i, r := range rules.Rules {
+		if r.Column == "" {
+			return nil, fmt.Errorf("validation rule %d: column is required", i+1)
+		}
+		switch r.Severity {
+		case "":
+			r.Severity = SeverityError
+		case SeverityError, SeverityWarning:
+		default:
+			return nil, fmt.Errorf("validation rule %d: unknown severity %q", i+1, r.Severity)
+		}
+		if r.Regex != "" {
+			if r.regex, err = regexp.Compile(r.Regex); err != nil {
+				return nil, fmt.Errorf("validation rule %d: %w", i+1, err)
+			}
+		}
+		if r.Ref != nil && (r.Ref.Sheet == "" || r.Ref.Column == "") {
+			return nil, fmt.Errorf("validation rule %d: ref needs sheet and column", i+1)
+		}
+	}
+	return &rules, nil
+}
+
+// ValidateConverted checks JSON files in jsonDir against the rules file at rulesPath.
+// Files are given with or without their .json extension; none means every file.
+// It returns a nil report when no rules file is configured.
+func ValidateConverted(jsonDir, rulesPath string, layout *SheetLayout, files ...string) (*ValidationReport, error) {
+	if rulesPath == "" {
+		return nil, nil
+	}
+	rules, err := LoadValidationRules(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	v := NewValidator(jsonDir, rules, layout)
+	if len(files) == 0 {
+		return v.ValidateAll()
+	}
+	report := &ValidationReport{}
+	for _, f := range files {
+		if err := v.validate(strings.TrimSuffix(filepath.Base(f), ".json"), report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func (r *ValidationRule) matches(file, sheet string) bool {
+	return (r.File == "" || r.File == "*" || r.File == file) && (r.Sheet == "" || r.Sheet == "*" || r.Sheet == sheet)
+}
+
+// sheetsData is a converted JSON file: sheet name -> rows.
+type sheetsData map[string][]map[string]interface{}
+
+// Validator checks converted JSON files in a directory against a rule set.
+type Validator struct {
+	jsonDir string
+	rules   *ValidationRules
+	layout  *SheetLayout
+	files   map[string]sheetsData
+}
+
+// NewValidator creates a validator; layout is used to report spreadsheet row numbers.
+func NewValidator(jsonDir string, rules *ValidationRules, layout *SheetLayout) *Validator {
+	if layout == nil {
+		layout = DefaultSheetLayout()
+	}
+	return &Validator{jsonDir: jsonDir, rules: rules, layout: layout, files: make(map[string]sheetsData)}
+}
+
+// ValidateAll checks every JSON file in the directory.
+func (v *Validator) ValidateAll() (*ValidationReport, error) {
+	entries, err := os.ReadDir(v.jsonDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read json directory: %w", err)
+	}
+	report := &ValidationReport{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		if err := v.validate(strings.TrimSuffix(e.Name(), ".json"), report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// ValidateFile checks a single JSON file, given with or without its .json extension.
+func (v *Validator) ValidateFile(fileName string) (*ValidationReport, error) {
+	report := &ValidationReport{}
+	if err := v.validate(strings.TrimSuffix(filepath.Base(fileName), ".json"), report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (v *Validator) validate(file string, report *ValidationReport) error {
+	data, err := v.load(file)
+	if err != nil {
+		return err
+	}
+	report.Files = append(report.Files, file)
+
+	sheets := make([]string, 0, len(data))
+	for sheet := range data {
+		sheets = append(sheets, sheet)
+	}
+	sort.Strings(sheets)
+
+	for _, sheet := range sheets {
+		for _, rule := range v.rules.Rules {
+			if !rule.matches(file, sheet) {
+				continue
+			}
+			// Wildcard rules only apply to sheets that actually have the column.
+			if (rule.Sheet == "" || rule.Sheet == "*") && !hasColumn(data[sheet], rule.Column) {
+				continue
+			}
+			issues, err := v.check(file, sheet, data[sheet], rule)
+			if err != nil {
+				return err
+			}
+			report.Issues = append(report.Issues, issues...)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) load(file string) (sheetsData, error) {
+	if data, ok := v.files[file]; ok {
+		return data, nil
+	}
+	raw, err := os.ReadFile(filepath.Join(v.jsonDir, file+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s.json: %w", file, err)
+	}
+	var data sheetsData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s.json: %w", file, err)
+	}
+	v.files[file] = data
+	return data, nil
+}
+
+// check runs every check of rule over one sheet.
+func (v *Validator) check(file, sheet string, rows []map[string]interface{}, rule *ValidationRule) ([]ValidationIssue, error) {
+	var issues []ValidationIssue
+	report := func(i int, name, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{
+			Severity: rule.Severity,
+			File:     file,
+			Sheet:    sheet,
+			Row:      v.layout.sheetRow(i),
+			Column:   rule.Column,
+			Rule:     name,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	var refs map[string]bool
+	if rule.Ref != nil {
+		var err error
+		if refs, err = v.refValues(file, rule.Ref); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]int)
+	for i, row := range rows {
+		value, present := row[rule.Column]
+		if !present || isEmptyValue(value) {
+			if rule.Required {
+				report(i, "required", "value is empty")
+			}
+			continue
+		}
+		key := valueKey(value)
+
+		if rule.Unique {
+			if first, dup := seen[key]; dup {
+				report(i, "unique", "duplicate value %s (first seen in row %d)", key, v.layout.sheetRow(first))
+			} else {
+				seen[key] = i
+			}
+		}
+		if rule.Range != nil {
+			n, ok := numericValue(value)
+			switch {
+			case !ok:
+				report(i, "range", "value %s is not a number", key)
+			case rule.Range.Min != nil && n < *rule.Range.Min:
+				report(i, "range", "value %s is below min %v", key, *rule.Range.Min)
+			case rule.Range.Max != nil && n > *rule.Range.Max:
+				report(i, "range", "value %s is above max %v", key, *rule.Range.Max)
+			}
+		}
+		if rule.regex != nil && !rule.regex.MatchString(key) {
+			report(i, "regex", "value %s does not match %s", key, rule.Regex)
+		}
+		for _, elem := range valueElements(value) {
+			ek := valueKey(elem)
+			if len(rule.Enum) > 0 && !containsString(rule.Enum, ek) {
+				report(i, "enum", "value %s is not one of %s", ek, strings.Join(rule.Enum, ", "))
+			}
+			if refs != nil && !refs[ek] {
+				report(i, "ref", "value %s not found in %s", ek, rule.Ref)
+			}
+		}
+	}
+	return issues, nil
+}
+
+func (r *RefRule) String() string {
+	if r.File == "" {
+		return fmt.Sprintf("%s.%s", r.Sheet, r.Column)
+	}
+	return fmt.Sprintf("%s:%s.%s", r.File, r.Sheet, r.Column)
+}
+
+// refValues collects the values of the column a foreign key points at.
+func (v *Validator) refValues(file string, ref *RefRule) (map[string]bool, error) {
+	target := ref.File
+	if target == "" {
+		target = file
+	}
+	data, err := v.load(target)
+	if err != nil {
+		return nil, fmt.Errorf("ref %s: %w", ref, err)
+	}
+	rows, ok := data[ref.Sheet]
+	if !ok {
+		return nil, fmt.Errorf("ref %s: sheet %s not found in %s", ref, ref.Sheet, target)
+	}
+	values := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if v, ok := row[ref.Column]; ok && !isEmptyValue(v) {
+			values[valueKey(v)] = true
+		}
+	}
+	return values, nil
+}
+
+func hasColumn(rows []map[string]interface{}, column string) bool {
+	for _, row := range rows {
+		if _, ok := row[column]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// valueKey renders a value for comparison, so 1 and "1" compare equal.
+func valueKey(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// valueElements returns the elements of an array value, or the value itself.
+func valueElements(v interface{}) []interface{} {
+	if arr, ok := v.([]interface{}); ok {
+		return arr
+	}
+	return []interface{}{v}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeValidationFixture writes converted JSON files and a rules file, returning the json
+// directory and the rules path.
+func writeValidationFixture(t *testing.T, files map[string]sheetsData, rules string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, rulesPath
+}
+
+func TestValidateConvertedRules(t *testing.T) {
+	files := map[string]sheetsData{
+		"Character": {"UnitData": {
+			{"ID": 1.0, "Attack": 100.0, "Grade": "SR", "Tags": []interface{}{"melee"}, "Code": "U-001", "ItemID": 10.0},
+			{"ID": 2.0, "Attack": -5.0, "Grade": "UR", "Tags": []interface{}{"melee", "fly"}, "Code": "U-2", "ItemID": 99.0},
+			{"Attack": "high", "Grade": "R"},
+			{"ID": 1.0, "Attack": 10000.0, "Grade": "SSR", "ItemID": "10"},
+		}},
+		"Item": {"ItemList": {{"ID": 10.0}, {"ID": 11.0}}},
+	}
+	rules := `rules:
+  - {file: Character, sheet: UnitData, column: ID, required: true, unique: true}
+  - {file: Character, sheet: UnitData, column: Attack, range: {min: 0, max: 9999}}
+  - {file: Character, sheet: UnitData, column: Grade, enum: [R, SR, SSR], severity: warning}
+  - {file: Character, sheet: UnitData, column: Tags, enum: [melee, ranged]}
+  - {file: Character, sheet: UnitData, column: Code, regex: '^U-\d{3}$'}
+  - {file: Character, sheet: UnitData, column: ItemID, ref: {file: Item, sheet: ItemList, column: ID}}
+  - {column: Missing, required: true}
+`
+	dir, rulesPath := writeValidationFixture(t, files, rules)
+	// Row 4 is a comment row between data rows, so the third and fourth rows are sheet rows 5 and 6.
+	layout := &SheetLayout{HeaderRow: 1, CommentRows: []int{4}, DataStartRow: 2}
+
+	report, err := ValidateConverted(dir, rulesPath, layout, "Character")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, is := range report.Issues {
+		got = append(got, strings.Join([]string{is.Severity, is.Column, is.Rule, strconv.Itoa(is.Row), is.Message}, " | "))
+	}
+	want := []string{
+		"error | ID | required | 5 | value is empty",
+		"error | ID | unique | 6 | duplicate value 1 (first seen in row 2)",
+		"error | Attack | range | 3 | value -5 is below min 0",
+		"error | Attack | range | 5 | value high is not a number",
+		"error | Attack | range | 6 | value 10000 is above max 9999",
+		"warning | Grade | enum | 3 | value UR is not one of R, SR, SSR",
+		"error | Tags | enum | 3 | value fly is not one of melee, ranged",
+		"error | Code | regex | 3 | value U-2 does not match ^U-\\d{3}$",
+		"error | ItemID | ref | 3 | value 99 not found in Item:ItemList.ID",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("issues:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !report.HasErrors() {
+		t.Error("HasErrors = false")
+	}
+}
+
+func TestLoadValidationRulesRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name, rules, want string
+	}{
+		{"no column", "rules:\n  - {sheet: UnitData, required: true}\n", "column is required"},
+		{"severity", "rules:\n  - {column: ID, severity: fatal}\n", `unknown severity "fatal"`},
+		{"regex", "rules:\n  - {column: ID, regex: '('}\n", "missing closing )"},
+		{"ref", "rules:\n  - {column: ItemID, ref: {file: Item}}\n", "ref needs sheet and column"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(path, []byte(tt.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadValidationRules(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSheetRowSkipsDescriptorRows(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout *SheetLayout
+		want   []int
+	}{
+		{"default", DefaultSheetLayout(), []int{2, 3, 4}},
+		{"type and comment rows", &SheetLayout{HeaderRow: 1, TypeRow: 2, CommentRows: []int{3}}, []int{4, 5, 6}},
+		{"comment row inside the data", &SheetLayout{HeaderRow: 1, CommentRows: []int{3}, DataStartRow: 2}, []int{2, 4, 5}},
+	}
+	for _, tt := range tests {
+		for i, want := range tt.want {
+			if got := tt.layout.sheetRow(i); got != want {
+				t.Errorf("%s: sheetRow(%d) = %d, want %d", tt.name, i, got, want)
+			}
+		}
+	}
+}
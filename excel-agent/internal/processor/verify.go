@@ -0,0 +1,460 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxListedRows bounds how many differing rows a verification diff names.
+const maxListedRows = 10
+
+// VerifyOptions configures VerifyPipeline.
+type VerifyOptions struct {
+	XlsxDir string
+	JsonDir string
+	// Convert must match the options the JSON was converted with.
+	Convert   *ConvertOptions
+	RedisAddr string
+	RedisDB   int
+	// Files restricts the check to these file names, with or without extension.
+	Files []string
+	// Sources, if set, gives listed workbooks their stable name, tab selection and header row.
+	Sources []*Source
+}
+
+// verifyWorkbook is a source workbook and the options its JSON was converted with.
+type verifyWorkbook struct {
+	path    string
+	convert *ConvertOptions
+}
+
+// SheetVerification is the round-trip result of one sheet.
+// A row count of -1 means the sheet is absent at that stage.
+type SheetVerification struct {
+	File      string   `json:"file"`
+	Sheet     string   `json:"sheet"`
+	XlsxRows  int      `json:"xlsx_rows"`
+	JSONRows  int      `json:"json_rows"`
+	RedisRows int      `json:"redis_rows"`
+	Diffs     []string `json:"diffs,omitempty"`
+}
+
+// VerifyReport collects the per-sheet results of VerifyPipeline.
+type VerifyReport struct {
+	Sheets []SheetVerification `json:"sheets"`
+	Notes  []string            `json:"notes,omitempty"`
+}
+
+// HasMismatches reports whether any sheet differs between stages.
+func (r *VerifyReport) HasMismatches() bool {
+	for _, s := range r.Sheets {
+		if len(s.Diffs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VerifyReport) String() string {
+	mismatched := 0
+	for _, s := range r.Sheets {
+		if len(s.Diffs) > 0 {
+			mismatched++
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Verified %d sheets: %d mismatched", len(r.Sheets), mismatched)
+	for _, n := range r.Notes {
+		fmt.Fprintf(&b, "\n  note: %s", n)
+	}
+	for _, s := range r.Sheets {
+		status := "ok"
+		if len(s.Diffs) > 0 {
+			status = "mismatch"
+		}
+		fmt.Fprintf(&b, "\n  [%s] %s:%s xlsx=%s json=%s redis=%s", status, s.File, s.Sheet,
+			rowCount(s.XlsxRows), rowCount(s.JSONRows), rowCount(s.RedisRows))
+		for _, d := range s.Diffs {
+			fmt.Fprintf(&b, "\n      %s", d)
+		}
+	}
+	return b.String()
+}
+
+func rowCount(n int) string {
+	if n < 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+// VerifyPipeline checks that every sheet survived xlsx -> JSON -> Redis unchanged:
+// the same row count, the same column set and the same content hash for each row.
+// JSON files without a source workbook (e.g. from Google Sheets) are checked against Redis only.
+func VerifyPipeline(ctx context.Context, opts VerifyOptions) (*VerifyReport, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: opts.RedisAddr,
+		DB:   opts.RedisDB,
+	})
+	defer rdb.Close()
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	prefix, err := currentKey(ctx, rdb, "")
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := LoadManifest(opts.JsonDir)
+	if err != nil {
+		return nil, err
+	}
+	names, workbooks, err := verifyTargets(opts, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{}
+	for _, name := range names {
+		var xlsx map[string][]map[string]interface{}
+		layout := opts.Convert.layout()
+		if wb, ok := workbooks[name]; ok {
+			base := filepath.Base(wb.path)
+			if entry := manifest.Files[base]; entry != nil && entry.Options != optionsHash(wb.convert) {
+				report.Notes = append(report.Notes, fmt.Sprintf("%s was converted with different options; pass the same -typed and layout settings", base))
+			}
+			if xlsx, err = ReadExcelSheets(wb.path, wb.convert); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", wb.path, err)
+			}
+			layout = wb.convert.layout()
+		}
+
+		jsonData, err := readSheetsFile(filepath.Join(opts.JsonDir, name+".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		redisSheets, err := redisSheetNames(ctx, rdb, prefix, name)
+		if err != nil {
+			return nil, err
+		}
+
+		sheets := make(map[string]bool)
+		for s := range xlsx {
+			sheets[s] = true
+		}
+		for s := range jsonData {
+			sheets[s] = true
+		}
+		for _, s := range redisSheets {
+			sheets[s] = true
+		}
+		sorted := make([]string, 0, len(sheets))
+		for s := range sheets {
+			sorted = append(sorted, s)
+		}
+		sort.Strings(sorted)
+
+		for _, sheet := range sorted {
+			redisRows, inRedis, err := readRedisSheet(ctx, rdb, prefix+name+":"+sheet)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s:%s from redis: %w", name, sheet, err)
+			}
+			xlsxRows, inXlsx := xlsx[sheet]
+			jsonRows, inJSON := jsonData[sheet]
+
+			sv := SheetVerification{File: name, Sheet: sheet, XlsxRows: -1, JSONRows: -1, RedisRows: -1}
+			if inXlsx {
+				sv.XlsxRows = len(xlsxRows)
+			}
+			if inJSON {
+				sv.JSONRows = len(jsonRows)
+			}
+			if inRedis {
+				sv.RedisRows = len(redisRows)
+			}
+
+			if xlsx != nil {
+				switch {
+				case inXlsx && !inJSON:
+					sv.Diffs = append(sv.Diffs, "xlsx->json: sheet missing from JSON")
+				case !inXlsx && inJSON:
+					sv.Diffs = append(sv.Diffs, "xlsx->json: sheet not in workbook")
+				case inXlsx && inJSON:
+					sv.Diffs = append(sv.Diffs, compareStage("xlsx->json", xlsxRows, jsonRows, layout)...)
+				}
+			}
+			switch {
+			case inJSON && !inRedis:
+				sv.Diffs = append(sv.Diffs, "json->redis: sheet missing from Redis")
+			case !inJSON && inRedis:
+				sv.Diffs = append(sv.Diffs, "json->redis: sheet not in JSON")
+			case inJSON && inRedis:
+				sv.Diffs = append(sv.Diffs, compareStage("json->redis", jsonRows, redisRows, layout)...)
+			}
+			report.Sheets = append(report.Sheets, sv)
+		}
+	}
+	return report, nil
+}
+
+// verifyTargets returns the file names to verify and the workbook of those that have one.
+// A workbook is verified under its output name: the source's name if it is listed in
+// opts.Sources, else the output recorded in the conversion manifest, else its base name.
+func verifyTargets(opts VerifyOptions, manifest *Manifest) ([]string, map[string]*verifyWorkbook, error) {
+	workbooks := make(map[string]*verifyWorkbook)
+	set := make(map[string]bool)
+	// aliases maps workbook base names to output names, so Files may name either.
+	aliases := make(map[string]string)
+
+	entries, err := os.ReadDir(opts.XlsxDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read xlsx directory: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !isWatchedWorkbook(e.Name()) {
+			continue
+		}
+		path := filepath.Join(opts.XlsxDir, e.Name())
+		wb := &verifyWorkbook{path: path, convert: opts.Convert}
+		base := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		name := base
+		if s := sourceForWorkbook(opts.Sources, opts.XlsxDir, path); s != nil {
+			if wb.convert, err = s.ConvertOptions(opts.Convert); err != nil {
+				return nil, nil, err
+			}
+			name = s.Name
+		} else if entry := manifest.Files[e.Name()]; entry != nil && entry.Output != "" {
+			name = strings.TrimSuffix(entry.Output, ".json")
+		}
+		workbooks[name] = wb
+		aliases[base] = name
+		set[name] = true
+	}
+
+	entries, err = os.ReadDir(opts.JsonDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read json directory: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".json" {
+			set[strings.TrimSuffix(e.Name(), ".json")] = true
+		}
+	}
+
+	if len(opts.Files) > 0 {
+		wanted := make(map[string]bool)
+		for _, f := range opts.Files {
+			base := filepath.Base(f)
+			name := strings.TrimSuffix(base, filepath.Ext(base))
+			if alias, ok := aliases[name]; ok {
+				name = alias
+			}
+			wanted[name] = true
+		}
+		for name := range set {
+			if !wanted[name] {
+				delete(set, name)
+			}
+		}
+		for name := range wanted {
+			if !set[name] {
+				return nil, nil, fmt.Errorf("%s not found in %s or %s", name, opts.XlsxDir, opts.JsonDir)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, workbooks, nil
+}
+
+// readSheetsFile reads a converted JSON file; a missing file yields no sheets.
+func readSheetsFile(path string) (sheetsData, error) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var data sheetsData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// compareStage diffs the rows of a sheet before and after one pipeline stage, by position.
+func compareStage(stage string, before, after []map[string]interface{}, layout *SheetLayout) []string {
+	var diffs []string
+	if len(before) != len(after) {
+		diffs = append(diffs, fmt.Sprintf("%s: row count %d != %d", stage, len(before), len(after)))
+	}
+
+	beforeCols, afterCols := columnSet(before), columnSet(after)
+	if missing := setDifference(beforeCols, afterCols); len(missing) > 0 {
+		diffs = append(diffs, fmt.Sprintf("%s: columns missing: %s", stage, strings.Join(missing, ", ")))
+	}
+	if extra := setDifference(afterCols, beforeCols); len(extra) > 0 {
+		diffs = append(diffs, fmt.Sprintf("%s: unexpected columns: %s", stage, strings.Join(extra, ", ")))
+	}
+
+	var differing []string
+	count := 0
+	for i := 0; i < len(before) && i < len(after); i++ {
+		if rowHash(before[i]) == rowHash(after[i]) {
+			continue
+		}
+		count++
+		if len(differing) < maxListedRows {
+			differing = append(differing, fmt.Sprint(layout.sheetRow(i)))
+		}
+	}
+	if count > 0 {
+		more := ""
+		if count > len(differing) {
+			more = ", ..."
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: %d rows differ (rows %s%s)", stage, count, strings.Join(differing, ", "), more))
+	}
+	return diffs
+}
+
+// rowHash hashes a row's canonical JSON form, so int64 1 and float64 1 hash alike.
+func rowHash(row map[string]interface{}) string {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return ""
+	}
+	var canonical interface{}
+	if err := json.Unmarshal(data, &canonical); err != nil {
+		return ""
+	}
+	data, _ = json.Marshal(canonical)
+	return hashBytes(data)
+}
+
+func columnSet(rows []map[string]interface{}) map[string]bool {
+	cols := make(map[string]bool)
+	for _, c := range rowColumns(rows) {
+		cols[c] = true
+	}
+	return cols
+}
+
+// setDifference returns the members of a that are not in b, sorted.
+func setDifference(a, b map[string]bool) []string {
+	var out []string
+	for k := range a {
+		if !b[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// readRedisSheet reads the rows of a sheet key in either storage layout.
+func readRedisSheet(ctx context.Context, rdb *redis.Client, key string) ([]map[string]interface{}, bool, error) {
+	typ, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	switch typ {
+	case "none":
+		return nil, false, nil
+	case "string":
+		val, err := rdb.Get(ctx, key).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		var rows []map[string]interface{}
+		if err := json.Unmarshal([]byte(val), &rows); err != nil {
+			return nil, false, err
+		}
+		return rows, true, nil
+	case "hash":
+		layout, err := rdb.HGet(ctx, key, "layout").Result()
+		if err != nil && err != redis.Nil {
+			return nil, false, err
+		}
+		if layout != RedisLayoutHash {
+			return nil, false, fmt.Errorf("key holds a row, not a sheet")
+		}
+		ids, err := rdb.ZRange(ctx, rowIDsKey(key), 0, -1).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		pipe := rdb.Pipeline()
+		cmds := make([]*redis.MapStringStringCmd, len(ids))
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, rowKey(key, id))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, false, err
+		}
+		rows := make([]map[string]interface{}, len(ids))
+		for i, cmd := range cmds {
+			rows[i] = decodeRow(cmd.Val())
+		}
+		return rows, true, nil
+	}
+	return nil, false, fmt.Errorf("unsupported %s value", typ)
+}
+
+// redisSheetNames lists the sheets of file cached under prefix, in either storage layout.
+func redisSheetNames(ctx context.Context, rdb *redis.Client, prefix, file string) ([]string, error) {
+	base := prefix + file + ":"
+	keys, err := scanKeys(ctx, rdb, base)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for _, key := range keys {
+		// Row and index keys of the hash layout have further segments.
+		if !strings.Contains(strings.TrimPrefix(key, base), ":") {
+			candidates = append(candidates, key)
+		}
+	}
+
+	pipe := rdb.Pipeline()
+	types := make([]*redis.StatusCmd, len(candidates))
+	layouts := make([]*redis.StringCmd, len(candidates))
+	for i, key := range candidates {
+		types[i] = pipe.Type(ctx, key)
+		layouts[i] = pipe.HGet(ctx, key, "layout")
+	}
+	// HGET on a string key fails with WRONGTYPE; the type decides which result is used.
+	_, _ = pipe.Exec(ctx)
+
+	var sheets []string
+	for i, key := range candidates {
+		if err := types[i].Err(); err != nil {
+			return nil, err
+		}
+		switch types[i].Val() {
+		case "string":
+		case "hash":
+			if layouts[i].Val() != RedisLayoutHash {
+				continue
+			}
+		default:
+			continue
+		}
+		sheets = append(sheets, strings.TrimPrefix(key, base))
+	}
+	return sheets, nil
+}
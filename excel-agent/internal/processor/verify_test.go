@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/xuri/excelize/v2"
+)
+
+// writeItemWorkbook writes a workbook with an ItemList tab and a Draft tab.
+func writeItemWorkbook(t *testing.T, path string) {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	if err := f.SetSheetName("Sheet1", "ItemList"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewSheet("Draft"); err != nil {
+		t.Fatal(err)
+	}
+	for cell, v := range map[string]interface{}{"A1": "ID", "B1": "Price", "A2": 1, "B2": 100, "A3": 2, "B3": 250} {
+		if err := f.SetCellValue("ItemList", cell, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for cell, v := range map[string]interface{}{"A1": "ID", "A2": 1} {
+		if err := f.SetCellValue("Draft", cell, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyPipelineUsesSourceNames(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	xlsxDir, jsonDir := t.TempDir(), t.TempDir()
+	writeItemWorkbook(t, filepath.Join(xlsxDir, "ItemTable_v3.xlsx"))
+	sources := []*Source{{Name: "Item", Workbook: "ItemTable_v3.xlsx", Include: []string{"ItemList"}}}
+	if _, err := ProcessXlsxSources(xlsxDir, jsonDir, sources, &ConvertOptions{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CacheJSONToRedis(ctx, jsonDir, mr.Addr(), 0, &RedisOptions{Layout: RedisLayoutJSON}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sources []*Source
+		files   []string
+	}{
+		{"sources manifest", sources, nil},
+		{"conversion manifest", nil, nil},
+		{"file by output name", sources, []string{"Item.json"}},
+		{"file by workbook name", sources, []string{"ItemTable_v3.xlsx"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := VerifyPipeline(ctx, VerifyOptions{
+				XlsxDir: xlsxDir, JsonDir: jsonDir, Convert: &ConvertOptions{},
+				RedisAddr: mr.Addr(), Files: tt.files, Sources: tt.sources,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []SheetVerification{{File: "Item", Sheet: "ItemList", XlsxRows: 2, JSONRows: 2, RedisRows: 2}}
+			// Without the sources manifest the workbook is read with every tab.
+			if tt.sources == nil {
+				want = append([]SheetVerification{{File: "Item", Sheet: "Draft", XlsxRows: 1, JSONRows: -1, RedisRows: -1,
+					Diffs: []string{"xlsx->json: sheet missing from JSON"}}}, want...)
+			}
+			if !reflect.DeepEqual(report.Sheets, want) {
+				t.Errorf("sheets = %+v, want %+v", report.Sheets, want)
+			}
+		})
+	}
+}
+
+func TestCompareStage(t *testing.T) {
+	layout := &SheetLayout{HeaderRow: 1, TypeRow: 2, CommentRows: []int{4}, DataStartRow: 3}
+	row := func(id int, extra ...string) map[string]interface{} {
+		r := map[string]interface{}{"ID": float64(id)}
+		for _, c := range extra {
+			r[c] = "x"
+		}
+		return r
+	}
+	tests := []struct {
+		name          string
+		before, after []map[string]interface{}
+		want          []string
+	}{
+		{"same rows, int and float alike", []map[string]interface{}{{"ID": int64(1)}}, []map[string]interface{}{row(1)}, nil},
+		{"row count", []map[string]interface{}{row(1), row(2)}, []map[string]interface{}{row(1)}, []string{"s: row count 2 != 1"}},
+		{"columns", []map[string]interface{}{row(1, "Name")}, []map[string]interface{}{row(1, "Desc")},
+			[]string{"s: columns missing: Name", "s: unexpected columns: Desc", "s: 1 rows differ (rows 3)"}},
+		// The comment row 4 sits between the first and second data rows.
+		{"rows skip descriptor rows", []map[string]interface{}{row(1), row(2), row(3)}, []map[string]interface{}{row(1), row(9), row(8)},
+			[]string{"s: 2 rows differ (rows 5, 6)"}},
+	}
+	for _, tt := range tests {
+		if got := compareStage("s", tt.before, tt.after, layout); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: diffs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	var before, after []map[string]interface{}
+	for i := 0; i < maxListedRows+2; i++ {
+		before, after = append(before, row(i)), append(after, row(-i-1))
+	}
+	if got := compareStage("s", before, after, DefaultSheetLayout()); len(got) != 1 || !strings.HasSuffix(got[0], "11, ...)") {
+		t.Errorf("long diff = %q, want it cut after %d rows", got, maxListedRows)
+	}
+}
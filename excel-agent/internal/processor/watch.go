@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchOptions configures WatchXlsxDir.
+type WatchOptions struct {
+	XlsxDir   string
+	JsonDir   string
+	Convert   *ConvertOptions
+	RedisAddr string
+	RedisDB   int
+	Redis     *RedisOptions
+	// Debounce is how long a workbook must stay quiet before it is processed.
+	Debounce time.Duration
+	// RulesFile, if set, is checked before caching; workbooks with validation errors are not cached.
+	RulesFile string
+	// Sources, if set, gives listed workbooks their stable name, tab selection and header row.
+	Sources []*Source
+	Logger  *slog.Logger
+}
+
+// WatchXlsxDir reconverts and recaches a workbook whenever it is created, modified
+// or renamed in XlsxDir. Rapid saves are debounced and Excel lock files (~$*.xlsx)
+// are ignored. It blocks until ctx is cancelled.
+func WatchXlsxDir(ctx context.Context, opts WatchOptions) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer w.Close()
+	if err := w.Add(opts.XlsxDir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", opts.XlsxDir, err)
+	}
+	logger.Info("watching workbooks", "dir", opts.XlsxDir, "debounce", opts.Debounce.String())
+
+	pending := make(map[string]*debounceTimer)
+	ready := make(chan *debounceTimer)
+	defer func() {
+		for _, d := range pending {
+			d.timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if !isWatchedWorkbook(ev.Name) || !ev.Has(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) {
+				continue
+			}
+			path := ev.Name
+			if d, ok := pending[path]; ok && d.timer.Stop() {
+				d.timer.Reset(opts.Debounce)
+				continue
+			}
+			// A timer that already fired is replaced; its cycle is dropped when it arrives.
+			d := &debounceTimer{path: path}
+			d.timer = time.AfterFunc(opts.Debounce, func() {
+				select {
+				case ready <- d:
+				case <-ctx.Done():
+				}
+			})
+			pending[path] = d
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error("watcher error", "error", err)
+
+		case d := <-ready:
+			if pending[d.path] != d {
+				continue
+			}
+			delete(pending, d.path)
+			runWatchCycle(ctx, d.path, opts, logger)
+		}
+	}
+}
+
+// debounceTimer delays the watch cycle of a workbook until its events settle.
+type debounceTimer struct {
+	path  string
+	timer *time.Timer
+}
+
+// isWatchedWorkbook reports whether path is an .xlsx workbook and not an Excel lock file.
+func isWatchedWorkbook(path string) bool {
+	name := filepath.Base(path)
+	return strings.EqualFold(filepath.Ext(name), ".xlsx") && !strings.HasPrefix(name, "~$")
+}
+
+// runWatchCycle converts and caches one workbook, or forgets it if it is gone.
+func runWatchCycle(ctx context.Context, path string, opts WatchOptions, logger *slog.Logger) {
+	start := time.Now()
+	name := filepath.Base(path)
+	log := logger.With("workbook", name)
+
+	convert := opts.Convert
+	if s := sourceForWorkbook(opts.Sources, opts.XlsxDir, path); s != nil {
+		var err error
+		if convert, err = s.ConvertOptions(opts.Convert); err != nil {
+			log.Error("watch cycle failed", "stage", "convert", "error", err)
+			return
+		}
+		log = log.With("source", s.Name)
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		forgetWatchedWorkbook(ctx, path, convert, opts, log, start)
+		return
+	}
+
+	report, err := ConvertWorkbook(path, opts.JsonDir, convert, false)
+	if err != nil {
+		log.Error("watch cycle failed", "stage", "convert", "error", err, "duration", time.Since(start).String())
+		return
+	}
+	if len(report.Converted) == 0 {
+		log.Info("workbook unchanged", "duration", time.Since(start).String())
+		return
+	}
+
+	jsonPath := filepath.Join(opts.JsonDir, excelJSONName(path, convert))
+	validation, err := ValidateConverted(opts.JsonDir, opts.RulesFile, convert.layout(), jsonPath)
+	if err != nil {
+		log.Error("watch cycle failed", "stage", "validate", "error", err, "duration", time.Since(start).String())
+		return
+	}
+	if validation != nil && validation.HasErrors() {
+		log.Error("watch cycle failed", "stage", "validate", "issues", validation.Issues, "duration", time.Since(start).String())
+		return
+	}
+
+	version, err := CacheJSONFileToRedis(ctx, jsonPath, opts.RedisAddr, opts.RedisDB, opts.Redis)
+	if err != nil {
+		log.Error("watch cycle failed", "stage", "redis", "error", err, "duration", time.Since(start).String())
+		return
+	}
+
+	var added, changed, removed []string
+	for _, c := range report.Changes {
+		switch c.Change {
+		case SheetAdded:
+			added = append(added, c.Sheet)
+		case SheetChanged:
+			changed = append(changed, c.Sheet)
+		case SheetRemoved:
+			removed = append(removed, c.Sheet)
+		}
+	}
+	log.Info("workbook converted and cached",
+		"sheets_added", added,
+		"sheets_changed", changed,
+		"sheets_removed", removed,
+		"redis_version", version,
+		"duration", time.Since(start).String(),
+	)
+}
+
+// forgetWatchedWorkbook removes the JSON of a deleted or renamed workbook and publishes
+// a Redis version without its sheets, so Redis keeps matching the JSON directory.
+func forgetWatchedWorkbook(ctx context.Context, path string, convert *ConvertOptions, opts WatchOptions, log *slog.Logger, start time.Time) {
+	report, err := ForgetWorkbook(filepath.Base(path), opts.JsonDir)
+	if err != nil {
+		log.Error("watch cycle failed", "stage", "forget", "error", err)
+		return
+	}
+	if len(report.Removed) == 0 {
+		log.Info("workbook removed", "sheets_removed", 0, "duration", time.Since(start).String())
+		return
+	}
+	version, err := RemoveJSONFileFromRedis(ctx, excelJSONName(path, convert), opts.RedisAddr, opts.RedisDB, opts.Redis)
+	if err != nil {
+		log.Error("watch cycle failed", "stage", "redis", "error", err, "duration", time.Since(start).String())
+		return
+	}
+	log.Info("workbook removed",
+		"sheets_removed", len(report.Changes),
+		"redis_version", version,
+		"duration", time.Since(start).String(),
+	)
+}
@@ -0,0 +1,272 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Workbook is the in-memory form of a converted file. Every OutputWriter is fed from it.
+// It keeps sheets in workbook order and columns in header order, and its JSON encoding does too.
+type Workbook struct {
+	// Name is the output base name, e.g. "Character" for Character.xlsx.
+	Name   string
+	Sheets []*Sheet
+}
+
+// Sheet is one converted sheet. Columns lists its columns in header order.
+type Sheet struct {
+	Name    string
+	Columns []string
+	Rows    []map[string]interface{}
+}
+
+// MarshalJSON encodes the workbook as {"Sheet": [rows...], ...} in sheet order.
+func (wb *Workbook) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, s := range wb.Sheets {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		rows, err := s.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("sheet %s: %w", s.Name, err)
+		}
+		b.Write(rows)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// MarshalJSON encodes the rows of the sheet with their keys in column order.
+func (s Sheet) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, row := range s.Rows {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := writeOrderedRow(&b, row, s.Columns); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteByte(']')
+	return b.Bytes(), nil
+}
+
+// writeOrderedRow writes row as a JSON object, keys in columns order first and any others sorted after them.
+func writeOrderedRow(b *bytes.Buffer, row map[string]interface{}, columns []string) error {
+	b.WriteByte('{')
+	n := 0
+	for _, col := range rowKeyOrder(row, columns) {
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		n++
+		key, err := json.Marshal(col)
+		if err != nil {
+			return err
+		}
+		val, err := json.Marshal(row[col])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col, err)
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+// rowKeyOrder lists the keys of row in columns order, followed by unlisted keys in sorted order.
+func rowKeyOrder(row map[string]interface{}, columns []string) []string {
+	keys := make([]string, 0, len(row))
+	listed := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		listed[col] = true
+		if _, ok := row[col]; ok {
+			keys = append(keys, col)
+		}
+	}
+	if len(keys) == len(row) {
+		return keys
+	}
+	var extra []string
+	for k := range row {
+		if !listed[k] {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	return append(keys, extra...)
+}
+
+// sheetMap returns the sheets keyed by name, the shape of the JSON output.
+func (wb *Workbook) sheetMap() map[string][]map[string]interface{} {
+	m := make(map[string][]map[string]interface{}, len(wb.Sheets))
+	for _, s := range wb.Sheets {
+		m[s.Name] = s.Rows
+	}
+	return m
+}
+
+// safeFileName makes a workbook or sheet name usable as a file name.
+func safeFileName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+}
+
+// ReadJSONWorkbook reads a converted JSON file back into the sheet model. Sheets keep
+// their order in the file; a column first seen in a later row (because it was empty
+// above) is placed after the column preceding it in that row.
+// Integral numbers become int64, like typed conversion produces.
+func ReadJSONWorkbook(jsonPath string) (*Workbook, error) {
+	f, err := os.Open(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeJSONWorkbook(f, filepath.Base(jsonPath))
+}
+
+// decodeJSONWorkbook decodes a converted JSON file read from r; name is its file name.
+func decodeJSONWorkbook(r io.Reader, name string) (*Workbook, error) {
+	wb := &Workbook{Name: strings.TrimSuffix(name, filepath.Ext(name))}
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sheet := &Sheet{Name: tok.(string)}
+		if err := readJSONSheet(dec, sheet); err != nil {
+			return nil, fmt.Errorf("%s: sheet %s: %w", name, sheet.Name, err)
+		}
+		wb.Sheets = append(wb.Sheets, sheet)
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return wb, nil
+}
+
+func readJSONSheet(dec *json.Decoder, sheet *Sheet) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("expected an array of rows, got %v", tok)
+	}
+	sheet.Rows = make([]map[string]interface{}, 0)
+	seen := make(map[string]bool)
+	for dec.More() {
+		if err := expectDelim(dec, '{'); err != nil {
+			return err
+		}
+		row := make(map[string]interface{})
+		prev := ""
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			col := tok.(string)
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			row[col] = fromJSONNumber(v)
+			if !seen[col] {
+				seen[col] = true
+				sheet.Columns = insertAfter(sheet.Columns, prev, col)
+			}
+			prev = col
+		}
+		if err := expectDelim(dec, '}'); err != nil {
+			return err
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	return expectDelim(dec, ']')
+}
+
+// insertAfter inserts col right after prev in columns, or first if prev is empty.
+func insertAfter(columns []string, prev, col string) []string {
+	at := 0
+	if prev != "" {
+		for i, c := range columns {
+			if c == prev {
+				at = i + 1
+				break
+			}
+		}
+	}
+	columns = append(columns, "")
+	copy(columns[at+1:], columns[at:])
+	columns[at] = col
+	return columns
+}
+
+// decodeJSONSheet decodes a JSON array of rows, such as a sheet cached in Redis, keeping column order.
+func decodeJSONSheet(data []byte) (*Sheet, error) {
+	sheet := &Sheet{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := readJSONSheet(dec, sheet); err != nil {
+		return nil, err
+	}
+	return sheet, nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %v, got %v", want, tok)
+	}
+	return nil
+}
+
+// fromJSONNumber replaces json.Number values, including nested ones, with int64 or float64.
+func fromJSONNumber(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = fromJSONNumber(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = fromJSONNumber(v[k])
+		}
+	}
+	return v
+}
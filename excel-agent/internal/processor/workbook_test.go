@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorkbookMarshalJSONKeepsOrder(t *testing.T) {
+	wb := &Workbook{Name: "Character", Sheets: []*Sheet{
+		{Name: "Zeta", Columns: []string{"ID", "Name", "Attack"}, Rows: []map[string]interface{}{
+			{"Attack": int64(10), "ID": int64(2), "Name": "Knight"},
+			{"ID": int64(1), "Note": "extra", "Memo": "after", "Attack": 1.5},
+		}},
+		{Name: "Alpha", Columns: []string{"ID"}, Rows: []map[string]interface{}{}},
+	}}
+	data, err := wb.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Zeta":[{"ID":2,"Name":"Knight","Attack":10},{"ID":1,"Attack":1.5,"Memo":"after","Note":"extra"}],"Alpha":[]}`
+	if string(data) != want {
+		t.Errorf("MarshalJSON =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestReadJSONWorkbook(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		sheets  []string
+		columns [][]string
+		rows    [][]map[string]interface{}
+	}{
+		{
+			name:    "sheet and column order",
+			json:    `{"Zeta":[{"ID":1,"Name":"A"}],"Alpha":[{"B":true,"A":null}]}`,
+			sheets:  []string{"Zeta", "Alpha"},
+			columns: [][]string{{"ID", "Name"}, {"B", "A"}},
+			rows:    [][]map[string]interface{}{{{"ID": int64(1), "Name": "A"}}, {{"B": true, "A": nil}}},
+		},
+		{
+			name:    "column first seen in a later row",
+			json:    `{"S":[{"ID":1,"Grade":"R"},{"ID":2,"Name":"B","Grade":"SR"},{"Memo":"m","ID":3}]}`,
+			sheets:  []string{"S"},
+			columns: [][]string{{"Memo", "ID", "Name", "Grade"}},
+			rows: [][]map[string]interface{}{{
+				{"ID": int64(1), "Grade": "R"},
+				{"ID": int64(2), "Name": "B", "Grade": "SR"},
+				{"Memo": "m", "ID": int64(3)},
+			}},
+		},
+		{
+			name:    "numbers",
+			json:    `{"S":[{"I":9007199254740993,"F":2.5,"E":1e3,"Tags":[1,2.5],"Obj":{"n":7}}]}`,
+			sheets:  []string{"S"},
+			columns: [][]string{{"I", "F", "E", "Tags", "Obj"}},
+			rows: [][]map[string]interface{}{{{
+				"I": int64(9007199254740993), "F": 2.5, "E": float64(1000),
+				"Tags": []interface{}{int64(1), 2.5}, "Obj": map[string]interface{}{"n": int64(7)},
+			}}},
+		},
+		{
+			name:    "null sheet",
+			json:    `{"Empty":null}`,
+			sheets:  []string{"Empty"},
+			columns: [][]string{nil},
+			rows:    [][]map[string]interface{}{nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "Book.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+			wb, err := ReadJSONWorkbook(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wb.Name != "Book" || len(wb.Sheets) != len(tt.sheets) {
+				t.Fatalf("workbook %s with %d sheets, want Book with %d", wb.Name, len(wb.Sheets), len(tt.sheets))
+			}
+			for i, s := range wb.Sheets {
+				if s.Name != tt.sheets[i] || !reflect.DeepEqual(s.Columns, tt.columns[i]) || !reflect.DeepEqual(s.Rows, tt.rows[i]) {
+					t.Errorf("sheet %d = %s %v %#v, want %s %v %#v", i, s.Name, s.Columns, s.Rows, tt.sheets[i], tt.columns[i], tt.rows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJSONWorkbookRoundTrip(t *testing.T) {
+	in := `{"UnitData":[{"ID":1001,"Name":"Knight","Attack":12.5,"Tags":["melee"]},{"ID":1002,"Name":"Archer","Attack":9}],"Drops":[]}`
+	wb, err := decodeJSONWorkbook(strings.NewReader(in), "Character.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := wb.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("round trip =\n%s\nwant\n%s", out, in)
+	}
+}
+
+func TestReadJSONWorkbookRejectsBadShapes(t *testing.T) {
+	tests := map[string]string{
+		"not an object":   `[{"ID":1}]`,
+		"sheet not array": `{"S":{"ID":1}}`,
+		"row not object":  `{"S":[1]}`,
+		"truncated":       `{"S":[{"ID":1}`,
+	}
+	for name, in := range tests {
+		if _, err := decodeJSONWorkbook(strings.NewReader(in), "Bad.json"); err == nil || !strings.HasPrefix(err.Error(), "Bad.json") {
+			t.Errorf("%s: err = %v, want an error naming the file", name, err)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"log"
+	"strings"
 
 	"excel-agent/internal/cmd"
 	"excel-agent/internal/config"
 	"excel-agent/internal/flows"
 
+	"github.com/firebase/genkit/go/core/api"
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
+	"github.com/firebase/genkit/go/plugins/ollama"
 )
 
 func main() {
@@ -21,9 +24,13 @@ func main() {
 
 	ctx := context.Background()
 
-	// Init Genkit with Google AI plugin
+	// Init Genkit with Google AI plugin, and Ollama for local embedders
+	plugins := []api.Plugin{&googlegenai.GoogleAI{}}
+	if strings.HasPrefix(cfg.Embedder, "ollama/") {
+		plugins = append(plugins, &ollama.Ollama{ServerAddress: cfg.OllamaServerAddress})
+	}
 	g := genkit.Init(ctx,
-		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+		genkit.WithPlugins(plugins...),
 		genkit.WithDefaultModel(cfg.DefaultModel),
 	)
 